@@ -0,0 +1,378 @@
+// verification_code_cli 验证码服务的运维命令行工具, 用于查看与修复验证码状态, 无需手写依赖按日字段名称的redis命令
+//
+// 用法:
+//
+//	verification_code_cli [全局参数] <命令> [参数]
+//
+// 全局参数(均可通过环境变量设置):
+//
+//	-addr      redis地址, 环境变量 WOW_REDIS_ADDR, 默认 127.0.0.1:6379
+//	-password  redis密码, 环境变量 WOW_REDIS_PASSWORD
+//	-db        redis数据库编号, 环境变量 WOW_REDIS_DB, 默认 0
+//	-location  划分自然日所用的时区(例如 Asia/Shanghai、UTC), 环境变量 WOW_LOCATION, 须与服务端一致.
+//	           status/unban/reset-counters/revoke/stats 依赖按日存储的字段, 必须指定; 其他命令无需指定
+//	-output    输出格式, json 或 table, 默认 table
+//
+// 命令:
+//
+//	status <module> <obj>            查询对象的验证码状态
+//	unban <module> <obj>             解除对象当日因核销失败导致的封禁
+//	reset-counters <module> <obj>    重置对象当日的全部计数
+//	revoke <module> <obj>            吊销对象当前有效的验证码
+//	stats <module> [-date 20060102]  查询业务模块单日的统计数据, 默认为当日
+//	strategy show <module>           查看持久化于redis中的策略
+//	strategy set <module> [-validity N] [-interval N] [-unused N] [-failed N] [-ban threshold:seconds,...]
+//	                                 修改并持久化策略, 未指定的参数保持原值. 须已通过服务端持久化策略
+//	                                 仅写入redis: 运行中的服务需调用ReloadStrategy后生效. ReloadStrategy与其他方法并发调用
+//	                                 并不安全, 须在没有其他调用时执行(或由调用方加锁), 也可创建新的VerificationCodeRdb后整体替换
+//	export <module> [-file path] [-codes include|exclude|hash]
+//	                                 以JSON lines格式导出业务模块的全部状态, 默认写入标准输出
+//	import <module> [-file path] [-overwrite]
+//	                                 恢复export导出的状态, 默认读取标准输入
+//
+// obj须为规范化后的对象名称(与服务端SubjectNormalizer的输出一致).
+// 管理员操作以仅追加的方式写入历史记录, 不会按本工具的配置淘汰服务端的历史记录.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/DontBeProud/wow-easy-go/redis_support/verification_code_rdb"
+	"github.com/DontBeProud/wow-easy-go/utils/wow_time"
+	"github.com/go-redis/redis/v8"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// 未持久化策略时使用的有效期(秒), 仅影响status中验证码已等待核销的时长
+const defaultValidityDuration = 300
+
+// 全局参数
+type globalOptions struct {
+	addr     string
+	password string
+	db       int
+	location string
+	output   string
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+// 解析全局参数并执行命令
+func run(args []string, stdout io.Writer) error {
+	opts, args, err := parseGlobalOptions(args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "status", "unban", "reset-counters", "revoke":
+		return runObjCommand(opts, cmd, args, stdout)
+	case "stats":
+		return runStats(opts, args, stdout)
+	case "strategy":
+		return runStrategy(opts, args, stdout)
+	case "export":
+		return runExport(opts, args, stdout)
+	case "import":
+		return runImport(opts, args, stdout)
+	default:
+		return fmt.Errorf("unknown command %q\n%s", cmd, errUsage)
+	}
+}
+
+var errLocationRequired = fmt.Errorf("-location or WOW_LOCATION is required for commands that depend on natural days, and must match the service, e.g. Asia/Shanghai")
+
+var errUsage = fmt.Errorf("usage: verification_code_cli [-addr host:port] [-password pwd] [-db n] [-location tz] [-output json|table] " +
+	"status|unban|reset-counters|revoke <module> <obj> | stats <module> [-date 20060102] | strategy show|set <module> [...] | " +
+	"export <module> [-file path] [-codes include|exclude|hash] | import <module> [-file path] [-overwrite]")
+
+// 解析全局参数, 未指定的参数使用环境变量
+func parseGlobalOptions(args []string) (globalOptions, []string, error) {
+	fs := flag.NewFlagSet("verification_code_cli", flag.ContinueOnError)
+	opts := globalOptions{}
+	fs.StringVar(&opts.addr, "addr", envOr("WOW_REDIS_ADDR", "127.0.0.1:6379"), "redis address")
+	fs.StringVar(&opts.password, "password", os.Getenv("WOW_REDIS_PASSWORD"), "redis password")
+	db, _ := strconv.Atoi(envOr("WOW_REDIS_DB", "0"))
+	fs.IntVar(&opts.db, "db", db, "redis database")
+	fs.StringVar(&opts.location, "location", os.Getenv("WOW_LOCATION"), "time zone used by the service to split natural days, e.g. Asia/Shanghai")
+	fs.StringVar(&opts.output, "output", "table", "output format: json or table")
+	if err := fs.Parse(args); err != nil {
+		return opts, nil, err
+	}
+	if opts.output != "json" && opts.output != "table" {
+		return opts, nil, fmt.Errorf("invalid output format %q", opts.output)
+	}
+	return opts, fs.Args(), nil
+}
+
+// 连接redis并创建业务模块的Rdb. 若redis中持久化了策略则使用该策略
+// dated: 命令是否依赖按日存储的字段. 若是则必须指定时区, 以免在与服务端时区不同的机器上操作错误日期的字段
+func openRdb(opts globalOptions, module string, dated bool) (*verification_code_rdb.VerificationCodeRdb, error) {
+	optCfg := &verification_code_rdb.VerificationCodeRdbOptionalConfig{
+		StatisticsStrategy: &verification_code_rdb.StatisticsStrategy{},
+		HistoryStrategy:    &verification_code_rdb.HistoryStrategy{AppendOnly: true},
+	}
+	if dated && opts.location == "" {
+		return nil, errLocationRequired
+	}
+	if opts.location != "" {
+		loc, err := time.LoadLocation(opts.location)
+		if err != nil {
+			return nil, err
+		}
+		optCfg.Location = loc
+	}
+
+	strategy, err := verification_code_rdb.CreateVerificationCodeServiceStrategy(defaultValidityDuration, 0, 0, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(&redis.Options{Addr: opts.addr, Password: opts.password, DB: opts.db})
+	rdb, err := verification_code_rdb.CreateVerificationCodeRdbWithOptionalConfig(client, module, *strategy, optCfg)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = rdb.ReloadStrategy(); err != nil {
+		return nil, err
+	}
+	return rdb, nil
+}
+
+// 针对单个对象的命令
+func runObjCommand(opts globalOptions, cmd string, args []string, stdout io.Writer) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: %s <module> <obj>", cmd)
+	}
+	rdb, err := openRdb(opts, args[0], true)
+	if err != nil {
+		return err
+	}
+	obj := args[1]
+
+	switch cmd {
+	case "status":
+		st, err := rdb.QueryVerificationStatus(obj)
+		if err != nil {
+			return err
+		}
+		return printStatus(stdout, opts.output, st)
+	case "unban":
+		if err = rdb.Unban(obj); err != nil {
+			return err
+		}
+		return printResult(stdout, opts.output, map[string]interface{}{"obj": obj, "unbanned": true})
+	case "reset-counters":
+		if err = rdb.ResetCounters(obj); err != nil {
+			return err
+		}
+		return printResult(stdout, opts.output, map[string]interface{}{"obj": obj, "reset": true})
+	default:
+		existed, err := rdb.Revoke(obj)
+		if err != nil {
+			return err
+		}
+		return printResult(stdout, opts.output, map[string]interface{}{"obj": obj, "revoked": existed})
+	}
+}
+
+// 查询业务模块单日的统计数据
+func runStats(opts globalOptions, args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: stats <module> [-date 20060102]")
+	}
+	module := args[0]
+
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	date := fs.String("date", "", "date in format 20060102, defaults to today")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	rdb, err := openRdb(opts, module, true)
+	if err != nil {
+		return err
+	}
+
+	day := wow_time.GetZeroTimeByDateOffsetInLocation(0, rdb.QueryLocation())
+	if *date != "" {
+		if day, err = time.ParseInLocation("20060102", *date, rdb.QueryLocation()); err != nil {
+			return err
+		}
+	}
+
+	stats, err := rdb.QueryDailyStatistics(day, day)
+	if err != nil {
+		return err
+	}
+	return printStatistics(stdout, opts.output, stats[0])
+}
+
+// 查看或修改持久化于redis中的策略
+func runStrategy(opts globalOptions, args []string, stdout io.Writer) error {
+	if len(args) < 2 || (args[0] != "show" && args[0] != "set") {
+		return fmt.Errorf("usage: strategy show|set <module> [...]")
+	}
+	sub, module := args[0], args[1]
+
+	rdb, err := openRdb(opts, module, false)
+	if err != nil {
+		return err
+	}
+
+	// 未持久化策略时rdb的策略为本工具的占位策略, 在其基础上修改并保存会使服务端未指定的各项限制全部失效
+	exist, snapshot, err := rdb.QueryPersistedStrategy()
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return fmt.Errorf("no strategy is persisted for module %q", module)
+	}
+	if sub == "show" {
+		return printStrategy(stdout, opts.output, snapshot)
+	}
+
+	if err = applyStrategyFlags(rdb, args[2:]); err != nil {
+		return err
+	}
+	if err = rdb.SaveStrategy(); err != nil {
+		return err
+	}
+	_, snapshot, err = rdb.QueryPersistedStrategy()
+	if err != nil {
+		return err
+	}
+	return printStrategy(stdout, opts.output, snapshot)
+}
+
+// 导出业务模块的全部状态. 未指定-file时写入标准输出, 此时不再输出导出结果
+func runExport(opts globalOptions, args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: export <module> [-file path] [-codes include|exclude|hash]")
+	}
+	module := args[0]
+
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	file := fs.String("file", "", "output file, defaults to stdout")
+	codes := fs.String("codes", string(verification_code_rdb.CodeExportModeInclude), "how verification codes are exported: include, exclude or hash")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	rdb, err := openRdb(opts, module, false)
+	if err != nil {
+		return err
+	}
+
+	w := stdout
+	if *file != "" {
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	report, err := rdb.ExportState(w, verification_code_rdb.ExportOptions{CodeMode: verification_code_rdb.CodeExportMode(*codes)})
+	if err != nil || *file == "" {
+		return err
+	}
+	return printResult(stdout, opts.output, map[string]interface{}{"module": module, "keys": report.Keys, "excluded": report.Excluded})
+}
+
+// 恢复export导出的状态. 未指定-file时读取标准输入
+func runImport(opts globalOptions, args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: import <module> [-file path] [-overwrite]")
+	}
+	module := args[0]
+
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	file := fs.String("file", "", "input file, defaults to stdin")
+	overwrite := fs.Bool("overwrite", false, "overwrite existing keys")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	rdb, err := openRdb(opts, module, false)
+	if err != nil {
+		return err
+	}
+
+	var rd io.Reader = os.Stdin
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		rd = f
+	}
+	report, err := rdb.ImportState(rd, verification_code_rdb.ImportOptions{Overwrite: *overwrite})
+	if err != nil {
+		return err
+	}
+	return printResult(stdout, opts.output, map[string]interface{}{
+		"module": module, "restored": report.Restored, "expired": report.Expired, "skipped": report.Skipped,
+	})
+}
+
+// 根据strategy set的参数修改策略, 未指定的参数保持原值
+func applyStrategyFlags(rdb *verification_code_rdb.VerificationCodeRdb, args []string) error {
+	fs := flag.NewFlagSet("strategy set", flag.ContinueOnError)
+	validity := fs.Int64("validity", -1, "validity duration in seconds")
+	interval := fs.Int64("interval", -1, "request time interval threshold in seconds")
+	unused := fs.Int("unused", -1, "deny threshold of unused codes")
+	failed := fs.Int("failed", -1, "deny threshold of failed count")
+	ban := fs.String("ban", "", "temporarily ban strategy, e.g. 3:60,5:600. Use \"-\" to clear")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *validity >= 0 {
+		if err := rdb.ModifyValidityDuration(*validity); err != nil {
+			return err
+		}
+	}
+	if *interval >= 0 {
+		rdb.ModifyRequestTimeIntervalThreshold(*interval)
+	}
+	if *unused >= 0 {
+		rdb.ModifyDenyThresholdOfUnusedCode(*unused)
+	}
+	if *failed >= 0 {
+		rdb.ModifyDenyThresholdOfFailedCount(*failed)
+	}
+	if *ban != "" {
+		banStrategy, err := parseBanStrategy(*ban)
+		if err != nil {
+			return err
+		}
+		for threshold := range *rdb.QueryTemporarilyBanStrategy() {
+			rdb.DelTemporarilyBanStrategy(threshold)
+		}
+		for threshold, duration := range banStrategy {
+			rdb.AddTemporarilyBanStrategy(threshold, duration)
+		}
+	}
+	return nil
+}
+
+// 读取环境变量, 未设置时返回默认值
+func envOr(key string, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
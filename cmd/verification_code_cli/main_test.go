@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"github.com/DontBeProud/wow-easy-go/redis_support/verification_code_rdb"
+	"strings"
+	"testing"
+)
+
+func TestParseBanStrategy(t *testing.T) {
+	m, err := parseBanStrategy("5:600, 3:60")
+	if err != nil || m[3] != 60 || m[5] != 600 {
+		t.Error("parseBanStrategy有bug")
+	}
+	if formatBanStrategy(m) != "3:60,5:600" {
+		t.Error("formatBanStrategy有bug")
+	}
+	if _, err = parseBanStrategy("3"); err == nil {
+		t.Error("parseBanStrategy有bug")
+	}
+	if m, err = parseBanStrategy("-"); err != nil || len(m) != 0 {
+		t.Error("parseBanStrategy有bug")
+	}
+}
+
+func TestPrintStatus(t *testing.T) {
+	var buf bytes.Buffer
+	if err := printStatus(&buf, "table", verification_code_rdb.VerificationStatus{ObjName: "13800138000", UnusedCodeCount: 2, ResendCountToday: 1}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if out := strings.Join(strings.Fields(buf.String()), " "); !strings.Contains(out, "unused_code_count 2") || !strings.Contains(out, "resend_count_today 1") {
+		t.Error("table输出有bug")
+	}
+
+	buf.Reset()
+	if err := printStatus(&buf, "json", verification_code_rdb.VerificationStatus{ObjName: "13800138000"}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if !strings.Contains(buf.String(), `"obj": "13800138000"`) {
+		t.Error("json输出有bug")
+	}
+}
+
+func TestPrintStrategy(t *testing.T) {
+	var buf bytes.Buffer
+	if err := printStrategy(&buf, "table", verification_code_rdb.StrategySnapshot{ResendStrategy: verification_code_rdb.ResendStrategy{ReuseValidCode: true}}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if out := strings.Join(strings.Fields(buf.String()), " "); !strings.Contains(out, "reuse_valid_code true") || !strings.Contains(out, "extend_ttl false") {
+		t.Error("策略的table输出有bug")
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	if err := run(nil, &bytes.Buffer{}); err != errUsage {
+		t.Error("缺少命令时应返回用法")
+	}
+	if err := run([]string{"-output", "xml", "status"}, &bytes.Buffer{}); err == nil {
+		t.Error("非法的输出格式应返回错误")
+	}
+
+	t.Setenv("WOW_LOCATION", "")
+	for _, cmd := range []string{"status", "unban", "reset-counters", "revoke"} {
+		if err := run([]string{cmd, "SMS", "13800138000"}, &bytes.Buffer{}); err != errLocationRequired {
+			t.Error("依赖按日存储的字段的命令应要求指定时区: " + cmd)
+		}
+	}
+	if err := run([]string{"stats", "SMS"}, &bytes.Buffer{}); err != errLocationRequired {
+		t.Error("stats应要求指定时区")
+	}
+}
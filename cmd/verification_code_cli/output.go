@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/DontBeProud/wow-easy-go/redis_support/verification_code_rdb"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// 输出对象的验证码状态
+func printStatus(w io.Writer, output string, st verification_code_rdb.VerificationStatus) error {
+	lastErrTime := ""
+	if st.LastErrorTimeExist {
+		lastErrTime = st.LastErrorTime.Format("2006-01-02 15:04:05")
+	}
+
+	rows := [][2]string{
+		{"obj", st.ObjName},
+		{"code_exist", strconv.FormatBool(st.CodeExist)},
+		{"code_ttl", strconv.FormatInt(st.CodeTTL, 10)},
+		{"unused_code_count", strconv.Itoa(st.UnusedCodeCount)},
+		{"errors_count_today", strconv.Itoa(st.ErrorsCountToday)},
+		{"resend_count_today", strconv.Itoa(st.ResendCountToday)},
+		{"last_error_time", lastErrTime},
+	}
+	if st.CodeExist {
+		rows = append(rows, [2]string{"registered_period", strconv.FormatInt(st.RegisteredPeriod, 10)})
+	}
+	return printRows(w, output, rows)
+}
+
+// 输出业务模块单日的统计数据
+func printStatistics(w io.Writer, output string, s verification_code_rdb.DailyStatistics) error {
+	rows := [][2]string{
+		{"date", s.Date},
+		{"issued", strconv.FormatInt(s.IssuedCount, 10)},
+		{"verified", strconv.FormatInt(s.VerifiedCount, 10)},
+		{"failed", strconv.FormatInt(s.FailedCount, 10)},
+		{"unique_subjects", strconv.FormatInt(s.UniqueSubjects, 10)},
+	}
+
+	types := make([]int, 0, len(s.RejectedCount))
+	for it := range s.RejectedCount {
+		types = append(types, int(it))
+	}
+	sort.Ints(types)
+	for _, it := range types {
+		rows = append(rows, [2]string{"rejected:" + strconv.Itoa(it), strconv.FormatInt(s.RejectedCount[verification_code_rdb.InvalidType(it)], 10)})
+	}
+	return printRows(w, output, rows)
+}
+
+// 输出策略
+func printStrategy(w io.Writer, output string, s verification_code_rdb.StrategySnapshot) error {
+	if output == "json" {
+		return printJSON(w, s)
+	}
+
+	return printRows(w, output, [][2]string{
+		{"validity_duration", strconv.FormatInt(s.ValidityDuration, 10)},
+		{"request_time_interval_threshold", strconv.FormatInt(s.RequestTimeIntervalThreshold, 10)},
+		{"deny_threshold_of_unused_code", strconv.Itoa(s.DenyThresholdOfUnusedCode)},
+		{"deny_threshold_of_failed_count", strconv.Itoa(s.DenyThresholdOfFailedCount)},
+		{"temporarily_ban_strategy", formatBanStrategy(s.TemporarilyBanStrategy)},
+		{"fold_width", strconv.FormatBool(s.CodeComparisonStrategy.FoldWidth)},
+		{"strip_separators", strconv.FormatBool(s.CodeComparisonStrategy.StripSeparators)},
+		{"case_insensitive", strconv.FormatBool(s.CodeComparisonStrategy.CaseInsensitive)},
+		{"reuse_valid_code", strconv.FormatBool(s.ResendStrategy.ReuseValidCode)},
+		{"extend_ttl", strconv.FormatBool(s.ResendStrategy.ExtendTTL)},
+		{"max_extension", strconv.FormatInt(s.ExtendStrategy.MaxExtension, 10)},
+	})
+}
+
+// 输出操作结果
+func printResult(w io.Writer, output string, result map[string]interface{}) error {
+	if output == "json" {
+		return printJSON(w, result)
+	}
+
+	keys := make([]string, 0, len(result))
+	for k := range result {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	rows := make([][2]string, 0, len(keys))
+	for _, k := range keys {
+		rows = append(rows, [2]string{k, fmt.Sprint(result[k])})
+	}
+	return printRows(w, output, rows)
+}
+
+// 按输出格式输出键值对. json格式下输出为对象, table格式下每行一对
+func printRows(w io.Writer, output string, rows [][2]string) error {
+	if output == "json" {
+		m := make(map[string]string, len(rows))
+		for _, row := range rows {
+			m[row[0]] = row[1]
+		}
+		return printJSON(w, m)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, row := range rows {
+		fmt.Fprintf(tw, "%s\t%s\n", row[0], row[1])
+	}
+	return tw.Flush()
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// 解析临时封禁策略, 格式为 threshold:seconds,threshold:seconds. "-" 表示清空
+func parseBanStrategy(s string) (map[int]int64, error) {
+	res := map[int]int64{}
+	if s == "-" {
+		return res, nil
+	}
+
+	for _, item := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(item), ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid ban strategy %q, expected threshold:seconds", item)
+		}
+		threshold, err := strconv.Atoi(kv[0])
+		if err != nil || threshold <= 0 {
+			return nil, fmt.Errorf("invalid ban threshold %q", kv[0])
+		}
+		duration, err := strconv.ParseInt(kv[1], 10, 64)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid ban duration %q", kv[1])
+		}
+		res[threshold] = duration
+	}
+	return res, nil
+}
+
+// 格式化临时封禁策略(按阈值升序)
+func formatBanStrategy(m map[int]int64) string {
+	thresholds := make([]int, 0, len(m))
+	for t := range m {
+		thresholds = append(thresholds, t)
+	}
+	sort.Ints(thresholds)
+
+	items := make([]string, 0, len(thresholds))
+	for _, t := range thresholds {
+		items = append(items, strconv.Itoa(t)+":"+strconv.FormatInt(m[t], 10))
+	}
+	return strings.Join(items, ",")
+}
@@ -0,0 +1,132 @@
+package verification_code_handler
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClientIPResolver 客户端IP的解析规则
+type ClientIPResolver struct {
+	TrustForwardedHeaders bool         // 是否信任X-Forwarded-For/X-Real-IP请求头. 仅在服务部署于可信反向代理之后时开启, 否则客户端可伪造IP
+	TrustedProxies        []*net.IPNet // 可信反向代理的网段. 开启TrustForwardedHeaders时, 自右向左跳过X-Forwarded-For中属于可信网段的地址; 为空时视为仅有一层反向代理, 取最右侧(由该代理追加)的地址
+}
+
+// ClientIP 解析请求的客户端IP, 无法解析时返回空字符串
+func (c ClientIPResolver) ClientIP(r *http.Request) string {
+	if c.TrustForwardedHeaders {
+		if ip := c.fromForwardedFor(r.Header.Get("X-Forwarded-For")); ip != "" {
+			return ip
+		}
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+			return ip.String()
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// 从X-Forwarded-For中解析客户端IP
+func (c ClientIPResolver) fromForwardedFor(header string) string {
+	if header == "" {
+		return ""
+	}
+
+	var ips []net.IP
+	for _, s := range strings.Split(header, ",") {
+		ip := net.ParseIP(strings.TrimSpace(s))
+		if ip == nil {
+			return "" // 格式非法, 不信任该请求头
+		}
+		ips = append(ips, ip)
+	}
+
+	// 最左侧的地址可由客户端任意伪造, 只有最右侧的地址由与本服务直连的反向代理追加
+	if len(c.TrustedProxies) == 0 {
+		return ips[len(ips)-1].String()
+	}
+	for i := len(ips) - 1; i >= 0; i-- {
+		if !c.isTrustedProxy(ips[i]) {
+			return ips[i].String()
+		}
+	}
+	return ips[0].String()
+}
+
+// 是否为可信反向代理的地址
+func (c ClientIPResolver) isTrustedProxy(ip net.IP) bool {
+	for _, n := range c.TrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IPLimiter 按客户端IP限流的接口
+type IPLimiter interface {
+	Allow(ip string) (allowed bool, retryAfter time.Duration) // 判断请求是否放行, 不放行时返回距离可再次请求的剩余时长
+}
+
+// LocalIPLimiter 基于本地内存的IP限流器(固定窗口计数). 多实例部署时各实例独立计数
+type LocalIPLimiter struct {
+	mutex     *sync.Mutex
+	threshold int
+	window    time.Duration
+	now       func() time.Time
+	counters  map[string]*ipCounter
+}
+
+type ipCounter struct {
+	start time.Time
+	count int
+}
+
+// CreateLocalIPLimiter 创建基于本地内存的IP限流器. threshold: 单个窗口内允许单个IP通过的请求次数; window: 窗口时长
+func CreateLocalIPLimiter(threshold int, window time.Duration) *LocalIPLimiter {
+	return &LocalIPLimiter{
+		mutex:     &sync.Mutex{},
+		threshold: threshold,
+		window:    window,
+		now:       time.Now,
+		counters:  map[string]*ipCounter{},
+	}
+}
+
+// Allow 判断请求是否放行, 放行则计数+1. 无法解析IP(空字符串)的请求共用同一计数
+func (l LocalIPLimiter) Allow(ip string) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	c, ok := l.counters[ip]
+	if !ok || now.Sub(c.start) >= l.window {
+		l.prune(now)
+		c = &ipCounter{start: now}
+		l.counters[ip] = c
+	}
+
+	if c.count >= l.threshold {
+		return false, c.start.Add(l.window).Sub(now)
+	}
+	c.count++
+	return true, 0
+}
+
+// 清理已过期的窗口
+func (l LocalIPLimiter) prune(now time.Time) {
+	for ip, c := range l.counters {
+		if now.Sub(c.start) >= l.window {
+			delete(l.counters, ip)
+		}
+	}
+}
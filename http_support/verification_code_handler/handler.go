@@ -0,0 +1,265 @@
+package verification_code_handler
+
+import (
+	"encoding/json"
+	"errors"
+	. "github.com/DontBeProud/wow-easy-go/redis_support/verification_code_rdb"
+	"github.com/DontBeProud/wow-easy-go/utils/wow_random"
+	"github.com/DontBeProud/wow-easy-go/utils/wow_regexp"
+	"net/http"
+)
+
+const (
+	defaultCodeLength   = 6       // 默认的验证码长度
+	defaultMaxBodyBytes = 1 << 12 // 请求体的最大字节数
+)
+
+// VerifiedHook 核销成功后的回调, 例如签发登录态. 返回值作为响应体的data字段; 返回error时响应500
+type VerifiedHook func(r *http.Request, subject string) (data interface{}, err error)
+
+// SentHook 验证码发送成功后的回调, 例如记录日志
+type SentHook func(r *http.Request, subject string)
+
+// HandlerRdbInterface 验证码HTTP服务所需的Rdb能力
+type HandlerRdbInterface interface {
+	VerificationCodeRdbInterface
+	VerificationCodeHistoryInterface
+	VerificationCodeRetryAfterInterface
+	Revoke(objName string) (existed bool, err error)
+}
+
+// HandlerConfig 验证码HTTP服务的配置
+type HandlerConfig struct {
+	Rdb              HandlerRdbInterface // 验证码服务Rdb
+	Sender           CodeSender          // 验证码发送器
+	SubjectRegExp    *wow_regexp.RegExp  // 对象名称(手机号码/邮箱等)的校验规则. Rdb配置了SubjectNormalizer时对规范化后的对象名称校验, 为nil时仅由SubjectNormalizer校验; 否则为nil时使用 wow_regexp.DefaultExprChineseMobilePhone
+	CodeGenerator    func() string       // 验证码生成函数, 为nil时生成6位数字验证码
+	Scene            string              // 业务场景, 随请求元数据写入历史记录, 同时作为票据绑定的业务场景
+	IssueTicket      bool                // 核销成功时是否签发票据(Rdb需实现VerificationCodeTicketInterface并配置票据策略, 否则创建失败), 票据随响应体的ticket字段返回
+	IPLimiter        IPLimiter           // 按客户端IP限流, 为nil时不限流
+	ClientIPResolver ClientIPResolver    // 客户端IP的解析规则
+	OnSent           SentHook            // 可选. 验证码发送成功后的回调
+	OnVerified       VerifiedHook        // 可选. 核销成功后的回调
+}
+
+// VerificationCodeHandler 验证码HTTP服务, 提供"发送验证码"与"核销验证码"两个JSON接口
+type VerificationCodeHandler struct {
+	config     HandlerConfig
+	tickets    VerificationCodeTicketInterface     // 签发票据的Rdb, 未开启IssueTicket时为nil
+	normalizer VerificationCodeNormalizerInterface // 规范化对象名称的Rdb, Rdb未配置SubjectNormalizer时为nil
+}
+
+// CreateVerificationCodeHandler 创建验证码HTTP服务
+func CreateVerificationCodeHandler(config HandlerConfig) (*VerificationCodeHandler, error) {
+	return createVerificationCodeHandler(config)
+}
+
+// sendRequest "发送验证码"接口的请求体
+type sendRequest struct {
+	Subject string `json:"subject"`
+}
+
+// verifyRequest "核销验证码"接口的请求体
+type verifyRequest struct {
+	Subject string `json:"subject"`
+	Code    string `json:"code"`
+}
+
+// SendHandler "发送验证码"接口. 请求: POST {"subject": "..."}
+func (h VerificationCodeHandler) SendHandler() http.Handler {
+	return http.HandlerFunc(h.serveSend)
+}
+
+// VerifyHandler "核销验证码"接口. 请求: POST {"subject": "...", "code": "..."}
+func (h VerificationCodeHandler) VerifyHandler() http.Handler {
+	return http.HandlerFunc(h.serveVerify)
+}
+
+// 发送验证码: 校验对象名称 -> IP限流 -> 发送前校验 -> 添加并记录验证码 -> 发送
+func (h VerificationCodeHandler) serveSend(w http.ResponseWriter, r *http.Request) {
+	var req sendRequest
+	if !h.decodeRequest(w, r, &req) {
+		return
+	}
+	var ok bool
+	if req.Subject, ok = h.validateSubject(w, req.Subject); !ok {
+		return
+	}
+
+	meta, ok := h.checkIP(w, r)
+	if !ok {
+		return
+	}
+
+	it, err := h.config.Rdb.PreCheckBeforeSendVerificationCodeWithMetadata(req.Subject, meta)
+	if err != nil || it != UserIsValid {
+		h.writeInvalidType(w, req.Subject, it, err)
+		return
+	}
+
+	// 先记录再发送, 避免发送成功但记录失败时用户收到无法核销的验证码. 发送失败时吊销已记录的验证码
+	code := h.config.CodeGenerator()
+	if err = h.config.Rdb.SetAndRegisterVerificationCodeWithMetadata(req.Subject, code, meta); err != nil {
+		writeError(w, http.StatusServiceUnavailable, ErrCodeServiceUnavailable, "failed to register verification code", 0)
+		return
+	}
+	if err = h.config.Sender.SendCode(req.Subject, code); err != nil {
+		_, _ = h.config.Rdb.Revoke(req.Subject)
+		writeError(w, http.StatusBadGateway, ErrCodeSendFailed, "failed to send verification code", 0)
+		return
+	}
+
+	if h.config.OnSent != nil {
+		h.config.OnSent(r, req.Subject)
+	}
+	writeJSON(w, http.StatusOK, response{Ok: true})
+}
+
+// 核销验证码: 校验对象名称 -> IP限流 -> 核销前校验 -> 核销 -> 回调
+func (h VerificationCodeHandler) serveVerify(w http.ResponseWriter, r *http.Request) {
+	var req verifyRequest
+	if !h.decodeRequest(w, r, &req) {
+		return
+	}
+	var ok bool
+	if req.Subject, ok = h.validateSubject(w, req.Subject); !ok {
+		return
+	}
+	if req.Code == "" {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "code is required", 0)
+		return
+	}
+
+	meta, ok := h.checkIP(w, r)
+	if !ok {
+		return
+	}
+
+	it, err := h.config.Rdb.PreCheckBeforeVerifyAndUseVerificationCodeWithMetadata(req.Subject, meta)
+	if err != nil || it != UserIsValid {
+		h.writeInvalidType(w, req.Subject, it, err)
+		return
+	}
+
+	// 开启票据时在同一次核销中签发, 避免验证码已被核销但票据签发失败
+	var exist, success bool
+	var ticket string
+	if h.tickets != nil {
+		exist, success, ticket, err = h.tickets.VerifyAndUseVerificationCodeWithTicketAndMetadata(req.Subject, req.Code, h.config.Scene, meta)
+	} else {
+		exist, success, err = h.config.Rdb.VerifyAndUseVerificationCodeWithMetadata(req.Subject, req.Code, meta)
+	}
+	switch {
+	case err != nil:
+		writeError(w, http.StatusServiceUnavailable, ErrCodeServiceUnavailable, "verification service is unavailable", 0)
+		return
+	case !exist:
+		writeError(w, http.StatusBadRequest, ErrCodeCodeNotFound, "verification code does not exist or has expired", 0)
+		return
+	case !success:
+		writeError(w, http.StatusBadRequest, ErrCodeCodeIncorrect, "verification code is incorrect", 0)
+		return
+	}
+
+	var data interface{}
+	if h.config.OnVerified != nil {
+		if data, err = h.config.OnVerified(r, req.Subject); err != nil {
+			writeError(w, http.StatusInternalServerError, ErrCodeInternal, "internal error", 0)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, response{Ok: true, Data: data, Ticket: ticket})
+}
+
+// 校验请求方法并解析JSON请求体, 失败时写入错误响应并返回false
+func (h VerificationCodeHandler) decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeInvalidRequest, "method not allowed", 0)
+		return false
+	}
+
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, defaultMaxBodyBytes)).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "invalid JSON body", 0)
+		return false
+	}
+	return true
+}
+
+// 校验对象名称, 返回规范化后的对象名称. 失败时写入错误响应并返回false
+func (h VerificationCodeHandler) validateSubject(w http.ResponseWriter, subject string) (string, bool) {
+	if h.normalizer != nil {
+		normalized, err := h.normalizer.NormalizeSubject(subject)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidSubject, "invalid subject", 0)
+			return subject, false
+		}
+		subject = normalized
+	}
+
+	if h.config.SubjectRegExp != nil && !h.config.SubjectRegExp.MatchString(subject) {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidSubject, "invalid subject", 0)
+		return subject, false
+	}
+	return subject, true
+}
+
+// 解析客户端IP并按IP限流, 被限流时写入错误响应并返回false
+func (h VerificationCodeHandler) checkIP(w http.ResponseWriter, r *http.Request) (RequestMetadata, bool) {
+	meta := RequestMetadata{
+		IP:        h.config.ClientIPResolver.ClientIP(r),
+		Scene:     h.config.Scene,
+		UserAgent: r.UserAgent(),
+	}
+
+	if h.config.IPLimiter != nil {
+		if allowed, retryAfter := h.config.IPLimiter.Allow(meta.IP); !allowed {
+			writeError(w, http.StatusTooManyRequests, ErrCodeTooManyRequests, "too many requests from this IP", retryAfter)
+			return meta, false
+		}
+	}
+	return meta, true
+}
+
+// 根据违规类型写入错误响应
+func (h VerificationCodeHandler) writeInvalidType(w http.ResponseWriter, subject string, it InvalidType, err error) {
+	status, code := statusOfInvalidType(it, err)
+	retryAfter, _ := h.config.Rdb.QueryRetryAfter(subject, it)
+	writeError(w, status, code, messageOfErrCode(code), retryAfter)
+}
+
+func createVerificationCodeHandler(config HandlerConfig) (*VerificationCodeHandler, error) {
+	if config.Rdb == nil {
+		return nil, errors.New("Rdb == nil")
+	}
+	if config.Sender == nil {
+		return nil, errors.New("Sender == nil")
+	}
+
+	h := &VerificationCodeHandler{}
+	if config.IssueTicket {
+		tickets, ok := config.Rdb.(VerificationCodeTicketInterface)
+		if !ok || !tickets.IsTicketEnabled() {
+			return nil, errors.New("IssueTicket requires a Rdb with TicketStrategy")
+		}
+		h.tickets = tickets
+	}
+
+	// 规范化后的对象名称(例如E.164格式的手机号码)不一定符合默认的校验规则, 此时由SubjectNormalizer负责校验
+	if n, ok := config.Rdb.(VerificationCodeNormalizerInterface); ok && n.HasSubjectNormalizer() {
+		h.normalizer = n
+	} else if config.SubjectRegExp == nil {
+		exp, err := wow_regexp.DefaultExprChineseMobilePhone.CreateRegExp()
+		if err != nil {
+			return nil, err
+		}
+		config.SubjectRegExp = exp
+	}
+	if config.CodeGenerator == nil {
+		config.CodeGenerator = func() string {
+			return wow_random.GenerateVerificationCode(defaultCodeLength)
+		}
+	}
+	h.config = config
+	return h, nil
+}
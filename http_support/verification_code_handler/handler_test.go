@@ -0,0 +1,219 @@
+package verification_code_handler
+
+import (
+	"encoding/json"
+	"errors"
+	. "github.com/DontBeProud/wow-easy-go/redis_support/verification_code_rdb"
+	"github.com/go-redis/redis/v8"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testPhoneNum         = "13800138000"
+	testVerifiedPhoneNum = "13800138001"
+	testFailedPhoneNum   = "13800138002" // 向该号码发送验证码总是失败
+	testTicketPhoneNum   = "13800138003"
+)
+
+// 基于不可用的redis创建处于降级(FailOpen)模式的验证码服务, 验证码暂存于本地内存
+func createTestRdb(t *testing.T, optCfg VerificationCodeRdbOptionalConfig) *VerificationCodeRdb {
+	unavailable := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})
+	optCfg.DegradationStrategy = &DegradationStrategy{
+		Policies: map[DegradableOperation]DegradationPolicy{
+			OperationPreCheckBeforeSend:   DegradationPolicyFailOpen,
+			OperationSetAndRegister:       DegradationPolicyFailOpen,
+			OperationPreCheckBeforeVerify: DegradationPolicyFailOpen,
+			OperationVerifyAndUse:         DegradationPolicyFailOpen,
+		},
+		LocalLimiterThreshold: 1,
+	}
+	rdb, err := CreateVerificationCodeRdbWithOptionalConfig(unavailable, "HTTP", VerificationCodeServiceStrategy{ValidityDuration: 300}, &optCfg)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(rdb.Close)
+	return rdb
+}
+
+func createTestHandler(t *testing.T, sent map[string]string) *VerificationCodeHandler {
+	h, err := CreateVerificationCodeHandler(HandlerConfig{
+		Rdb: createTestRdb(t, VerificationCodeRdbOptionalConfig{}),
+		Sender: CodeSenderFunc(func(subject string, code string) error {
+			if subject == testFailedPhoneNum {
+				return errors.New("send failed")
+			}
+			sent[subject] = code
+			return nil
+		}),
+		OnVerified: func(r *http.Request, subject string) (interface{}, error) {
+			return map[string]string{"token": "t-" + subject}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return h
+}
+
+func doRequest(h http.Handler, body string) (*httptest.ResponseRecorder, response) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	var resp response
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+func TestSendAndVerify(t *testing.T) {
+	sent := map[string]string{}
+	h := createTestHandler(t, sent)
+
+	if w, resp := doRequest(h.SendHandler(), `{"subject":"123"}`); w.Code != http.StatusBadRequest || resp.Error.Code != ErrCodeInvalidSubject {
+		t.Error("对象名称校验有bug")
+	}
+
+	if w, _ := doRequest(h.SendHandler(), `{"subject":"`+testPhoneNum+`"}`); w.Code != http.StatusOK || len(sent[testPhoneNum]) != defaultCodeLength {
+		t.Error("发送验证码有bug")
+	}
+
+	// 本地限流器阈值为1, 第二次请求被拒绝
+	if w, resp := doRequest(h.SendHandler(), `{"subject":"`+testPhoneNum+`"}`); w.Code != http.StatusTooManyRequests || resp.Error.Code != ErrCodeRequestTooFrequently {
+		t.Error("违规类型映射有bug")
+	}
+
+	if w, resp := doRequest(h.VerifyHandler(), `{"subject":"`+testPhoneNum+`","code":"000000x"}`); w.Code != http.StatusBadRequest || resp.Error.Code != ErrCodeCodeIncorrect {
+		t.Error("核销验证码有bug")
+	}
+}
+
+func TestVerifySuccess(t *testing.T) {
+	sent := map[string]string{}
+	h := createTestHandler(t, sent)
+
+	if w, _ := doRequest(h.SendHandler(), `{"subject":"`+testVerifiedPhoneNum+`"}`); w.Code != http.StatusOK {
+		t.Fatal("发送验证码有bug")
+	}
+	w, resp := doRequest(h.VerifyHandler(), `{"subject":"`+testVerifiedPhoneNum+`","code":"`+sent[testVerifiedPhoneNum]+`"}`)
+	if w.Code != http.StatusOK || !resp.Ok {
+		t.Fatal("核销验证码有bug")
+	}
+	if data, _ := resp.Data.(map[string]interface{}); data["token"] != "t-"+testVerifiedPhoneNum {
+		t.Error("OnVerified回调有bug")
+	}
+}
+
+func TestSendFailed(t *testing.T) {
+	sent := map[string]string{}
+	h := createTestHandler(t, sent)
+
+	var registered string
+	h.config.CodeGenerator = func() string {
+		registered = "654321"
+		return registered
+	}
+	if w, resp := doRequest(h.SendHandler(), `{"subject":"`+testFailedPhoneNum+`"}`); w.Code != http.StatusBadGateway || resp.Error.Code != ErrCodeSendFailed {
+		t.Fatal("发送失败的处理有bug")
+	}
+	// 发送失败后已记录的验证码被吊销
+	if w, resp := doRequest(h.VerifyHandler(), `{"subject":"`+testFailedPhoneNum+`","code":"`+registered+`"}`); w.Code != http.StatusBadRequest || resp.Error.Code != ErrCodeCodeNotFound {
+		t.Error("发送失败后未吊销验证码")
+	}
+}
+
+func TestIssueTicket(t *testing.T) {
+	sender := CodeSenderFunc(func(subject string, code string) error { return nil })
+	if _, err := CreateVerificationCodeHandler(HandlerConfig{Rdb: createTestRdb(t, VerificationCodeRdbOptionalConfig{}), Sender: sender, IssueTicket: true}); err == nil {
+		t.Error("未配置票据策略时应拒绝开启IssueTicket")
+	}
+
+	h, err := CreateVerificationCodeHandler(HandlerConfig{
+		Rdb:           createTestRdb(t, VerificationCodeRdbOptionalConfig{TicketStrategy: &TicketStrategy{Secret: []byte("secret")}}),
+		Sender:        sender,
+		Scene:         "reset_password",
+		IssueTicket:   true,
+		CodeGenerator: func() string { return "123456" },
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if w, _ := doRequest(h.SendHandler(), `{"subject":"`+testTicketPhoneNum+`"}`); w.Code != http.StatusOK {
+		t.Fatal("发送验证码有bug")
+	}
+	if w, resp := doRequest(h.VerifyHandler(), `{"subject":"`+testTicketPhoneNum+`","code":"123456"}`); w.Code != http.StatusOK || resp.Ticket == "" {
+		t.Error("签发票据有bug")
+	}
+}
+
+func TestNormalizedSubject(t *testing.T) {
+	sent := map[string]string{}
+	h, err := CreateVerificationCodeHandler(HandlerConfig{
+		Rdb: createTestRdb(t, VerificationCodeRdbOptionalConfig{SubjectNormalizer: PhoneNumberNormalizer{}}),
+		Sender: CodeSenderFunc(func(subject string, code string) error {
+			sent[subject] = code
+			return nil
+		}),
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// 配置了SubjectNormalizer时不使用默认的校验规则, 带国际前缀的号码同样合法
+	if w, _ := doRequest(h.SendHandler(), `{"subject":"+86 138-0013-8004"}`); w.Code != http.StatusOK || sent["+8613800138004"] == "" {
+		t.Error("规范化对象名称有bug")
+	}
+	if w, resp := doRequest(h.SendHandler(), `{"subject":"123"}`); w.Code != http.StatusBadRequest || resp.Error.Code != ErrCodeInvalidSubject {
+		t.Error("规范化失败时应拒绝对象名称")
+	}
+}
+
+func TestStatusOfInvalidType(t *testing.T) {
+	if status, code := statusOfInvalidType(InvalidTypeServiceUnavailable, ErrRedisUnavailable); status != http.StatusServiceUnavailable || code != ErrCodeServiceUnavailable {
+		t.Error("违规类型映射有bug")
+	}
+	if status, code := statusOfInvalidType(InvalidTypeCustomBase+1, nil); status != http.StatusForbidden || code != ErrCodeRejectedByRule {
+		t.Error("自定义规则的违规类型映射有bug")
+	}
+
+	w := httptest.NewRecorder()
+	writeError(w, http.StatusTooManyRequests, ErrCodeRequestTooFrequently, "", 1500*time.Millisecond)
+	if w.Header().Get("Retry-After") != "2" {
+		t.Error("Retry-After有bug")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.RemoteAddr = "10.0.0.2:1234"
+	r.Header.Set("X-Forwarded-For", "1.2.3.4, 10.0.0.1")
+
+	if ip := (ClientIPResolver{}).ClientIP(r); ip != "10.0.0.2" {
+		t.Error("不应信任X-Forwarded-For")
+	}
+	_, private, _ := net.ParseCIDR("10.0.0.0/8")
+	if ip := (ClientIPResolver{TrustForwardedHeaders: true, TrustedProxies: []*net.IPNet{private}}).ClientIP(r); ip != "1.2.3.4" {
+		t.Error("X-Forwarded-For解析有bug")
+	}
+	// 未配置可信反向代理时取最右侧的地址, 客户端伪造的最左侧地址不生效
+	if ip := (ClientIPResolver{TrustForwardedHeaders: true}).ClientIP(r); ip != "10.0.0.1" {
+		t.Error("X-Forwarded-For解析有bug")
+	}
+
+	l := CreateLocalIPLimiter(1, time.Minute)
+	if ok, _ := l.Allow("1.2.3.4"); !ok {
+		t.Error("IP限流器有bug")
+	}
+	if ok, retryAfter := l.Allow("1.2.3.4"); ok || retryAfter <= 0 {
+		t.Error("IP限流器有bug")
+	}
+}
+
+func TestAliYunSMSCodeSenderWithSummary(t *testing.T) {
+	var sender SummaryCodeSender = AliYunSMSCodeSender{}
+	if err := sender.SendCodeWithSummary(testPhoneNum, "123456", "transfer 500 CNY"); err == nil {
+		t.Error("未配置SummaryTemplateParam时发送有bug")
+	}
+}
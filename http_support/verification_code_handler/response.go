@@ -0,0 +1,102 @@
+package verification_code_handler
+
+import (
+	"encoding/json"
+	. "github.com/DontBeProud/wow-easy-go/redis_support/verification_code_rdb"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrCode 错误响应中的错误码
+type ErrCode string
+
+const (
+	ErrCodeInvalidRequest          ErrCode = "invalid_request"            // 请求格式错误
+	ErrCodeInvalidSubject          ErrCode = "invalid_subject"            // 对象名称不合法
+	ErrCodeTooManyRequests         ErrCode = "too_many_requests"          // 客户端IP请求过于频繁
+	ErrCodeRequestTooFrequently    ErrCode = "request_too_frequently"     // 请求验证码过于频繁
+	ErrCodeUnusedCodeTooMany       ErrCode = "unused_code_too_many"       // 未核销的验证码过多
+	ErrCodeVerifyFailTooFrequently ErrCode = "verify_fail_too_frequently" // 验证码核销失败过于频繁
+	ErrCodeServiceUnavailable      ErrCode = "service_unavailable"        // 验证码服务不可用
+	ErrCodeBudgetExhausted         ErrCode = "budget_exhausted"           // 业务模块的发送预算已耗尽
+	ErrCodeRejectedByRule          ErrCode = "rejected_by_rule"           // 被自定义规则拒绝
+	ErrCodeSendFailed              ErrCode = "send_failed"                // 验证码发送失败
+	ErrCodeCodeNotFound            ErrCode = "code_not_found"             // 验证码不存在或已过期
+	ErrCodeCodeIncorrect           ErrCode = "code_incorrect"             // 验证码错误
+	ErrCodeInternal                ErrCode = "internal_error"             // 内部错误
+)
+
+// 各错误码对应的默认提示信息
+var errCodeMessages = map[ErrCode]string{
+	ErrCodeInvalidSubject:          "invalid subject",
+	ErrCodeRequestTooFrequently:    "verification code requested too frequently",
+	ErrCodeUnusedCodeTooMany:       "too many unused verification codes today",
+	ErrCodeVerifyFailTooFrequently: "too many failed verification attempts",
+	ErrCodeServiceUnavailable:      "verification service is unavailable",
+	ErrCodeBudgetExhausted:         "verification service is temporarily unavailable",
+	ErrCodeRejectedByRule:          "request rejected",
+}
+
+// 响应体
+type response struct {
+	Ok     bool           `json:"ok"`
+	Data   interface{}    `json:"data,omitempty"`
+	Ticket string         `json:"ticket,omitempty"` // 核销成功时签发的票据
+	Error  *responseError `json:"error,omitempty"`
+}
+
+// 错误响应体
+type responseError struct {
+	Code       ErrCode `json:"code"`
+	Message    string  `json:"message"`
+	RetryAfter int64   `json:"retry_after,omitempty"` // 距离可再次请求的剩余时长(秒)
+}
+
+// 违规类型对应的HTTP状态码及错误码
+func statusOfInvalidType(it InvalidType, err error) (int, ErrCode) {
+	switch it {
+	case InvalidTypeInvalidSubject:
+		return http.StatusBadRequest, ErrCodeInvalidSubject
+	case InvalidTypeRequestTooFrequently:
+		return http.StatusTooManyRequests, ErrCodeRequestTooFrequently
+	case InvalidTypeUnusedCodeTooMany:
+		return http.StatusTooManyRequests, ErrCodeUnusedCodeTooMany
+	case InvalidTypeVerifyFailTooFrequently:
+		return http.StatusTooManyRequests, ErrCodeVerifyFailTooFrequently
+	case InvalidTypeBudgetExhausted:
+		return http.StatusServiceUnavailable, ErrCodeBudgetExhausted
+	}
+	if err == nil && it >= InvalidTypeCustomBase {
+		return http.StatusForbidden, ErrCodeRejectedByRule
+	}
+
+	// 其余情况(redis不可用等)
+	return http.StatusServiceUnavailable, ErrCodeServiceUnavailable
+}
+
+// 错误码对应的默认提示信息
+func messageOfErrCode(code ErrCode) string {
+	if msg, ok := errCodeMessages[code]; ok {
+		return msg
+	}
+	return string(code)
+}
+
+// 写入错误响应. retryAfter大于0时写入Retry-After响应头(向上取整至秒)
+func writeError(w http.ResponseWriter, status int, code ErrCode, message string, retryAfter time.Duration) {
+	e := &responseError{Code: code, Message: message}
+	if retryAfter > 0 {
+		e.RetryAfter = int64(math.Ceil(retryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.FormatInt(e.RetryAfter, 10))
+	}
+	writeJSON(w, status, response{Error: e})
+}
+
+// 写入JSON响应
+func writeJSON(w http.ResponseWriter, status int, body response) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
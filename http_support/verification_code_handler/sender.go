@@ -0,0 +1,56 @@
+package verification_code_handler
+
+import (
+	"errors"
+	"github.com/DontBeProud/wow-easy-go/third_party_service_api/short_message_service/aliyun_sms"
+)
+
+// CodeSender 验证码发送器接口
+type CodeSender interface {
+	SendCode(subject string, code string) error // 向对象发送验证码
+}
+
+// CodeSenderFunc 函数形式的CodeSender
+type CodeSenderFunc func(subject string, code string) error
+
+// SendCode 向对象发送验证码
+func (f CodeSenderFunc) SendCode(subject string, code string) error {
+	return f(subject, code)
+}
+
+// SummaryCodeSender 发送附带可读交易摘要(例如"向尾号1234的账户转账500元")的验证码, 用于绑定交易载荷的验证码
+type SummaryCodeSender interface {
+	SendCodeWithSummary(subject string, code string, summary string) error
+}
+
+// AliYunSMSCodeSender 基于阿里云短信服务的验证码发送器
+type AliYunSMSCodeSender struct {
+	Sender               *aliyun_sms.AliYunSMSClientSender
+	TemplateParam        func(code string) interface{}                 // 根据验证码生成短信模板的原始参数, 由模板的GenerateTemplateParam格式化
+	SummaryTemplateParam func(code string, summary string) interface{} // 可选. 根据验证码及交易摘要生成短信模板的原始参数, 用于SendCodeWithSummary
+}
+
+// SendCode 向手机号码发送验证码短信
+func (s AliYunSMSCodeSender) SendCode(subject string, code string) error {
+	return s.send(subject, s.TemplateParam(code))
+}
+
+// SendCodeWithSummary 向手机号码发送附带交易摘要的验证码短信, 需配置SummaryTemplateParam
+func (s AliYunSMSCodeSender) SendCodeWithSummary(subject string, code string, summary string) error {
+	if s.SummaryTemplateParam == nil {
+		return errors.New("SummaryTemplateParam == nil")
+	}
+	return s.send(subject, s.SummaryTemplateParam(code, summary))
+}
+
+// 发送短信
+func (s AliYunSMSCodeSender) send(subject string, rawTemplateParam interface{}) error {
+	resp, err := s.Sender.SendSms([]string{subject}, nil, nil, rawTemplateParam)
+	if err != nil {
+		return err
+	}
+	if !resp.IsRequestSuccess() {
+		return errors.New("aliyun sms send failed: " + resp.GetRequestStatusCode() + " " + resp.GetRequestStatusMessage())
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package verification_code_message
+
+import (
+	. "github.com/DontBeProud/wow-easy-go/redis_support/verification_code_rdb"
+	. "github.com/DontBeProud/wow-easy-go/third_party_service_api/short_message_service/aliyun_sms/request_status"
+)
+
+// 因账户、签名、模板或请求本身的配置问题导致的错误码. 用户无法自行处理, 统一提示短信服务暂时不可用
+var smsServiceErrorCodes = []string{
+	AliYunSmsApiRequestStatusRamPermissionDeny,
+	AliYunSmsApiRequestStatusSystemError,
+	AliYunSmsApiRequestStatusOutOfService,
+	AliYunSmsApiRequestStatusProductUnSubscript,
+	AliYunSmsApiRequestStatusProductUnsubscribe,
+	AliYunSmsApiRequestStatusAccountNotExists,
+	AliYunSmsApiRequestStatusAccountAbnormal,
+	AliYunSmsApiRequestStatusSecurityFrozenAccount,
+	AliYunSmsApiRequestStatusAmountNotEnough,
+	AliYunSmsApiRequestStatusSmsTemplateIllegal,
+	AliYunSmsApiRequestStatusSmsSignatureIllegal,
+	AliYunSmsApiRequestStatusSmsSignIllegal,
+	AliYunSmsApiRequestStatusSmsSignatureSceneIllegal,
+	AliYunSmsApiRequestStatusSmsContentIllegal,
+	AliYunSmsApiRequestStatusInvalidParameters,
+	AliYunSmsApiRequestStatusInvalidJsonParam,
+	AliYunSmsApiRequestStatusTemplateMissingParameters,
+	AliYunSmsApiRequestStatusTemplateParamsIllegal,
+	AliYunSmsApiRequestStatusParamLengthLimit,
+	AliYunSmsApiRequestStatusParamNotSupportUrl,
+	AliYunSmsApiRequestStatusBlackKeyControlLimit,
+	AliYunSmsApiRequestStatusExtendCodeError,
+	AliYunSmsApiRequestStatusDenyIpRange,
+	AliYunSmsApiRequestStatusMobileCountOverLimit,
+	AliYunSmsApiRequestStatusSignatureDoesNotMatch,
+	AliYunSmsApiRequestStatusInvalidTimeStampExpired,
+	AliYunSmsApiRequestStatusSignatureNonceUsed,
+	AliYunSmsApiRequestStatusInvalidVersion,
+	AliYunSmsApiRequestStatusInvalidActionNotFound,
+}
+
+// 内置的消息模板
+var bundledMessages = map[Locale]Messages{
+	LocaleZhCN: {
+		InvalidTypes: map[InvalidType]Templates{
+			UserIsValid:                        {"校验通过"},
+			InvalidTypeUnusedCodeTooMany:       {"今日获取验证码的次数已达上限({limit}次), 请明日再试", "今日获取验证码的次数已达上限, 请明日再试"},
+			InvalidTypeRequestTooFrequently:    {"获取验证码过于频繁, 请{retry_after}重试", "获取验证码过于频繁, 请稍后重试"},
+			InvalidTypeVerifyFailTooFrequently: {"验证码错误次数过多, 请{retry_after}重试", "验证码错误次数过多, 请稍后重试"},
+			InvalidTypeServiceUnavailable:      {"服务暂时不可用, 请稍后重试"},
+			InvalidTypeInvalidSubject:          {"手机号码或邮箱格式不正确"},
+			InvalidTypeBudgetExhausted:         {"服务繁忙, 请稍后重试"},
+			InvalidTypeCustomBase:              {"请求未通过安全校验, 请稍后重试"},
+		},
+		SmsCodes: withSmsServiceErrorCodes(map[string]Templates{
+			"":                               {"短信服务暂时不可用, 请稍后重试"},
+			AliYunSmsApiRequestStatusSuccess: {"验证码已发送"},
+			AliYunSmsApiRequestStatusMobileNumberIllegal:        {"手机号码格式不正确"},
+			AliYunSmsApiRequestStatusDomesticNumberNotSupported: {"暂不支持向该手机号码发送短信"},
+			AliYunSmsApiRequestStatusBusinessLimitControl:       {"短信发送过于频繁, 请{retry_after}重试", "短信发送过于频繁, 请稍后重试"},
+			AliYunSmsApiRequestStatusDayLimitControl:            {"该手机号码今日接收短信的次数已达上限, 请明日再试"},
+		}),
+	},
+	LocaleEnUS: {
+		InvalidTypes: map[InvalidType]Templates{
+			UserIsValid:                        {"OK"},
+			InvalidTypeUnusedCodeTooMany:       {"You have reached the daily limit of {limit} verification codes. Please try again tomorrow.", "You have reached the daily limit of verification codes. Please try again tomorrow."},
+			InvalidTypeRequestTooFrequently:    {"Verification codes were requested too frequently. Please try again {retry_after}.", "Verification codes were requested too frequently. Please try again later."},
+			InvalidTypeVerifyFailTooFrequently: {"Too many incorrect verification codes. Please try again {retry_after}.", "Too many incorrect verification codes. Please try again later."},
+			InvalidTypeServiceUnavailable:      {"The service is temporarily unavailable. Please try again later."},
+			InvalidTypeInvalidSubject:          {"The phone number or email address is invalid."},
+			InvalidTypeBudgetExhausted:         {"The service is busy. Please try again later."},
+			InvalidTypeCustomBase:              {"The request did not pass the security check. Please try again later."},
+		},
+		SmsCodes: withSmsServiceErrorCodes(map[string]Templates{
+			"":                               {"The SMS service is temporarily unavailable. Please try again later."},
+			AliYunSmsApiRequestStatusSuccess: {"The verification code has been sent."},
+			AliYunSmsApiRequestStatusMobileNumberIllegal:        {"The phone number is invalid."},
+			AliYunSmsApiRequestStatusDomesticNumberNotSupported: {"Text messages cannot be sent to this phone number."},
+			AliYunSmsApiRequestStatusBusinessLimitControl:       {"Text messages were sent too frequently. Please try again {retry_after}.", "Text messages were sent too frequently. Please try again later."},
+			AliYunSmsApiRequestStatusDayLimitControl:            {"This phone number has reached the daily limit of text messages. Please try again tomorrow."},
+		}),
+	},
+}
+
+// 为配置问题导致的错误码填充与未收录的错误码相同的模板
+func withSmsServiceErrorCodes(m map[string]Templates) map[string]Templates {
+	for _, code := range smsServiceErrorCodes {
+		m[code] = m[""]
+	}
+	return m
+}
@@ -0,0 +1,241 @@
+package verification_code_message
+
+import (
+	"errors"
+	. "github.com/DontBeProud/wow-easy-go/redis_support/verification_code_rdb"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Locale 语言
+type Locale string
+
+const (
+	LocaleZhCN Locale = "zh-CN" // 简体中文
+	LocaleEnUS Locale = "en-US" // 英文
+)
+
+// 模板中的占位符
+const (
+	PlaceholderRetryAfter = "{retry_after}" // 距离可再次请求的剩余时长, 例如"30秒后"/"in 30 seconds"
+	PlaceholderLimit      = "{limit}"       // 相关的数量阈值, 例如单日未核销验证码数量的上限
+)
+
+// Templates 同一条消息的候选模板. 按顺序选择第一个所引用的参数均已提供的模板, 均不满足时使用最后一个
+type Templates []string
+
+// Messages 某一语言下的消息模板
+// InvalidTypes中未收录的违规类型(例如自定义规则的违规类型)使用InvalidTypeCustomBase的模板
+// SmsCodes中未收录的阿里云短信错误码使用键为空字符串的模板
+type Messages struct {
+	InvalidTypes map[InvalidType]Templates // 违规类型对应的模板
+	SmsCodes     map[string]Templates      // 阿里云短信API请求状态码(AliYunSmsApiRequestStatus.Code)对应的模板
+}
+
+// MessageParams 渲染模板的参数, 零值表示未知
+type MessageParams struct {
+	RetryAfter time.Duration // 距离可再次请求的剩余时长
+	Limit      int           // 相关的数量阈值
+}
+
+// Catalog 消息目录. 内置zh-CN与en-US两种语言, 支持按业务模块覆盖
+type Catalog struct {
+	defaultLocale Locale
+	mu            sync.RWMutex
+	modules       map[string]map[Locale]Messages // 业务模块名称 -> 语言 -> 覆盖的模板
+}
+
+// CreateCatalog 创建消息目录. defaultLocale: 未指定语言或语言不受支持时使用的语言
+func CreateCatalog(defaultLocale Locale) (*Catalog, error) {
+	if _, ok := bundledMessages[defaultLocale]; !ok {
+		return nil, errors.New("unsupported locale: " + string(defaultLocale))
+	}
+	return &Catalog{defaultLocale: defaultLocale, modules: map[string]map[Locale]Messages{}}, nil
+}
+
+// ModifyModuleMessages 覆盖业务模块在某一语言下的消息模板(与已覆盖的模板合并). moduleName为空时覆盖全部业务模块
+func (c *Catalog) ModifyModuleMessages(moduleName string, locale Locale, msgs Messages) error {
+	return c.modifyModuleMessages(moduleName, locale, msgs)
+}
+
+// QueryInvalidTypeMessage 查询违规类型对应的消息
+func (c *Catalog) QueryInvalidTypeMessage(moduleName string, locale Locale, it InvalidType, params MessageParams) string {
+	locale = c.resolveLocale(locale)
+	t := c.lookup(moduleName, locale, func(m Messages) (Templates, bool) {
+		t, ok := m.InvalidTypes[it]
+		return t, ok
+	})
+	if t == nil {
+		t = c.lookup(moduleName, locale, func(m Messages) (Templates, bool) {
+			t, ok := m.InvalidTypes[InvalidTypeCustomBase]
+			return t, ok
+		})
+	}
+	return render(t, locale, params)
+}
+
+// QuerySmsCodeMessage 查询阿里云短信API请求状态码对应的消息
+func (c *Catalog) QuerySmsCodeMessage(moduleName string, locale Locale, code string, params MessageParams) string {
+	locale = c.resolveLocale(locale)
+	t := c.lookup(moduleName, locale, func(m Messages) (Templates, bool) {
+		t, ok := m.SmsCodes[code]
+		return t, ok
+	})
+	if t == nil {
+		t = c.lookup(moduleName, locale, func(m Messages) (Templates, bool) {
+			t, ok := m.SmsCodes[""]
+			return t, ok
+		})
+	}
+	return render(t, locale, params)
+}
+
+// MessageRdbInterface 查询消息参数所需的Rdb能力
+type MessageRdbInterface interface {
+	VerificationCodeRetryAfterInterface
+	QueryEffectiveStrategy(objName string) (StrategySnapshot, error)
+}
+
+// QueryMessageParams 查询对象因违规类型it被拒绝时渲染消息所需的参数
+func QueryMessageParams(rdb MessageRdbInterface, objName string, it InvalidType) (MessageParams, error) {
+	retryAfter, err := rdb.QueryRetryAfter(objName, it)
+	if err != nil {
+		return MessageParams{}, err
+	}
+	params := MessageParams{RetryAfter: retryAfter}
+
+	switch it {
+	case InvalidTypeUnusedCodeTooMany, InvalidTypeVerifyFailTooFrequently:
+		st, err := rdb.QueryEffectiveStrategy(objName)
+		if err != nil {
+			return params, err
+		}
+		if it == InvalidTypeUnusedCodeTooMany {
+			params.Limit = st.DenyThresholdOfUnusedCode
+		} else {
+			params.Limit = st.DenyThresholdOfFailedCount
+		}
+	}
+	return params, nil
+}
+
+// MatchLocale 根据Accept-Language请求头(或单个语言标签)匹配受支持的语言, 均不受支持时返回空字符串(即使用默认语言)
+func MatchLocale(acceptLanguage string) Locale {
+	for _, tag := range strings.Split(acceptLanguage, ",") {
+		tag = strings.ToLower(strings.TrimSpace(strings.SplitN(tag, ";", 2)[0]))
+		switch {
+		case tag == "zh" || strings.HasPrefix(tag, "zh-"):
+			return LocaleZhCN
+		case tag == "en" || strings.HasPrefix(tag, "en-"):
+			return LocaleEnUS
+		}
+	}
+	return ""
+}
+
+// 覆盖业务模块在某一语言下的消息模板
+func (c *Catalog) modifyModuleMessages(moduleName string, locale Locale, msgs Messages) error {
+	if _, ok := bundledMessages[locale]; !ok {
+		return errors.New("unsupported locale: " + string(locale))
+	}
+	for _, t := range msgs.InvalidTypes {
+		if len(t) == 0 {
+			return errors.New("empty templates")
+		}
+	}
+	for _, t := range msgs.SmsCodes {
+		if len(t) == 0 {
+			return errors.New("empty templates")
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	locales, ok := c.modules[moduleName]
+	if !ok {
+		locales = map[Locale]Messages{}
+		c.modules[moduleName] = locales
+	}
+	merged := Messages{InvalidTypes: map[InvalidType]Templates{}, SmsCodes: map[string]Templates{}}
+	for _, m := range []Messages{locales[locale], msgs} {
+		for k, v := range m.InvalidTypes {
+			merged.InvalidTypes[k] = v
+		}
+		for k, v := range m.SmsCodes {
+			merged.SmsCodes[k] = v
+		}
+	}
+	locales[locale] = merged
+	return nil
+}
+
+// 依次在业务模块的覆盖项、全部业务模块的覆盖项及内置模板中查找
+func (c *Catalog) lookup(moduleName string, locale Locale, find func(m Messages) (Templates, bool)) Templates {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	chain := []Messages{c.modules[moduleName][locale]}
+	if moduleName != "" {
+		chain = append(chain, c.modules[""][locale])
+	}
+	chain = append(chain, bundledMessages[locale])
+	for _, m := range chain {
+		if t, ok := find(m); ok {
+			return t
+		}
+	}
+	return nil
+}
+
+// 不受支持的语言使用默认语言
+func (c *Catalog) resolveLocale(locale Locale) Locale {
+	if _, ok := bundledMessages[locale]; ok {
+		return locale
+	}
+	if matched := MatchLocale(string(locale)); matched != "" {
+		return matched
+	}
+	return c.defaultLocale
+}
+
+// 选择模板并替换占位符
+func render(t Templates, locale Locale, params MessageParams) string {
+	if len(t) == 0 {
+		return ""
+	}
+
+	chosen := t[len(t)-1]
+	for _, candidate := range t {
+		if (params.RetryAfter > 0 || !strings.Contains(candidate, PlaceholderRetryAfter)) &&
+			(params.Limit > 0 || !strings.Contains(candidate, PlaceholderLimit)) {
+			chosen = candidate
+			break
+		}
+	}
+	return strings.NewReplacer(
+		PlaceholderRetryAfter, formatRetryAfter(locale, params.RetryAfter),
+		PlaceholderLimit, strconv.Itoa(params.Limit),
+	).Replace(chosen)
+}
+
+// 将剩余时长格式化为相对时间(向上取整至秒/分钟/小时)
+func formatRetryAfter(locale Locale, d time.Duration) string {
+	n, unit := int64(math.Ceil(d.Hours())), 2
+	switch {
+	case d <= time.Minute:
+		n, unit = int64(math.Ceil(d.Seconds())), 0
+	case d <= time.Hour:
+		n, unit = int64(math.Ceil(d.Minutes())), 1
+	}
+
+	if locale == LocaleEnUS {
+		name := []string{"second", "minute", "hour"}[unit]
+		if n != 1 {
+			name += "s"
+		}
+		return "in " + strconv.FormatInt(n, 10) + " " + name
+	}
+	return strconv.FormatInt(n, 10) + []string{"秒后", "分钟后", "小时后"}[unit]
+}
@@ -0,0 +1,92 @@
+package verification_code_message
+
+import (
+	. "github.com/DontBeProud/wow-easy-go/redis_support/verification_code_rdb"
+	. "github.com/DontBeProud/wow-easy-go/third_party_service_api/short_message_service/aliyun_sms/request_status"
+	"testing"
+	"time"
+)
+
+func TestBundledMessages(t *testing.T) {
+	c, err := CreateCatalog(LocaleZhCN)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	its := []InvalidType{UserIsValid, InvalidTypeUnusedCodeTooMany, InvalidTypeRequestTooFrequently, InvalidTypeVerifyFailTooFrequently,
+		InvalidTypeServiceUnavailable, InvalidTypeInvalidSubject, InvalidTypeBudgetExhausted, InvalidTypeCustomBase + 1}
+	for _, it := range its {
+		zh := c.QueryInvalidTypeMessage("SMS", LocaleZhCN, it, MessageParams{})
+		en := c.QueryInvalidTypeMessage("SMS", LocaleEnUS, it, MessageParams{})
+		if zh == "" || en == "" || zh == en {
+			t.Error("InvalidType消息有bug")
+		}
+	}
+
+	codes := append([]string{AliYunSmsApiRequestStatusSuccess, AliYunSmsApiRequestStatusMobileNumberIllegal, AliYunSmsApiRequestStatusDomesticNumberNotSupported,
+		AliYunSmsApiRequestStatusBusinessLimitControl, AliYunSmsApiRequestStatusDayLimitControl, "isv.UNKNOWN"}, smsServiceErrorCodes...)
+	for _, code := range codes {
+		zh := c.QuerySmsCodeMessage("SMS", LocaleZhCN, code, MessageParams{})
+		en := c.QuerySmsCodeMessage("SMS", LocaleEnUS, code, MessageParams{})
+		if zh == "" || en == "" || zh == en {
+			t.Error("短信错误码消息有bug")
+		}
+	}
+	for locale, m := range bundledMessages {
+		for code := range bundledMessages[LocaleZhCN].SmsCodes {
+			if _, ok := m.SmsCodes[code]; !ok {
+				t.Error("短信错误码消息缺失: " + string(locale) + " " + code)
+			}
+		}
+	}
+}
+
+func TestRenderMessage(t *testing.T) {
+	c, _ := CreateCatalog(LocaleEnUS)
+	if msg := c.QueryInvalidTypeMessage("SMS", LocaleZhCN, InvalidTypeRequestTooFrequently, MessageParams{RetryAfter: 30 * time.Second}); msg != "获取验证码过于频繁, 请30秒后重试" {
+		t.Error("模板渲染有bug: " + msg)
+	}
+	if msg := c.QueryInvalidTypeMessage("SMS", LocaleZhCN, InvalidTypeRequestTooFrequently, MessageParams{}); msg != "获取验证码过于频繁, 请稍后重试" {
+		t.Error("模板渲染有bug: " + msg)
+	}
+	if msg := c.QueryInvalidTypeMessage("SMS", "en-GB", InvalidTypeVerifyFailTooFrequently, MessageParams{RetryAfter: 61 * time.Second}); msg != "Too many incorrect verification codes. Please try again in 2 minutes." {
+		t.Error("模板渲染有bug: " + msg)
+	}
+	if msg := c.QueryInvalidTypeMessage("SMS", "fr-FR", InvalidTypeUnusedCodeTooMany, MessageParams{Limit: 10}); msg != "You have reached the daily limit of 10 verification codes. Please try again tomorrow." {
+		t.Error("模板渲染有bug: " + msg)
+	}
+	if formatRetryAfter(LocaleEnUS, time.Second) != "in 1 second" || formatRetryAfter(LocaleZhCN, 90*time.Minute) != "2小时后" {
+		t.Error("formatRetryAfter有bug")
+	}
+}
+
+func TestModuleMessages(t *testing.T) {
+	c, _ := CreateCatalog(LocaleZhCN)
+	if err := c.ModifyModuleMessages("SMS", "ja-JP", Messages{}); err == nil {
+		t.Error("ModifyModuleMessages有bug")
+	}
+	_ = c.ModifyModuleMessages("", LocaleZhCN, Messages{InvalidTypes: map[InvalidType]Templates{InvalidTypeInvalidSubject: {"账号格式不正确"}}})
+	_ = c.ModifyModuleMessages("SMS", LocaleZhCN, Messages{
+		InvalidTypes: map[InvalidType]Templates{InvalidTypeCustomBase + 1: {"该设备存在风险"}},
+		SmsCodes:     map[string]Templates{AliYunSmsApiRequestStatusDayLimitControl: {"今日短信已达上限"}},
+	})
+
+	if c.QueryInvalidTypeMessage("SMS", LocaleZhCN, InvalidTypeCustomBase+1, MessageParams{}) != "该设备存在风险" ||
+		c.QueryInvalidTypeMessage("Email", LocaleZhCN, InvalidTypeCustomBase+1, MessageParams{}) != "请求未通过安全校验, 请稍后重试" {
+		t.Error("业务模块覆盖有bug")
+	}
+	if c.QueryInvalidTypeMessage("SMS", LocaleZhCN, InvalidTypeInvalidSubject, MessageParams{}) != "账号格式不正确" ||
+		c.QueryInvalidTypeMessage("SMS", LocaleEnUS, InvalidTypeInvalidSubject, MessageParams{}) != "The phone number or email address is invalid." {
+		t.Error("全局覆盖有bug")
+	}
+	if c.QuerySmsCodeMessage("SMS", LocaleZhCN, AliYunSmsApiRequestStatusDayLimitControl, MessageParams{}) != "今日短信已达上限" {
+		t.Error("业务模块覆盖有bug")
+	}
+}
+
+func TestMatchLocale(t *testing.T) {
+	if MatchLocale("zh-TW,zh;q=0.9,en;q=0.8") != LocaleZhCN || MatchLocale("en-GB;q=0.9") != LocaleEnUS ||
+		MatchLocale("fr-FR, en") != LocaleEnUS || MatchLocale("fr") != "" {
+		t.Error("MatchLocale有bug")
+	}
+}
@@ -0,0 +1,32 @@
+package verification_code_webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+)
+
+// 请求头
+const (
+	HeaderId        = "X-Webhook-Id"        // 事件ID, 同一事件的重试请求ID相同, 接收方可据此去重
+	HeaderEvent     = "X-Webhook-Event"     // 事件类型
+	HeaderTimestamp = "X-Webhook-Timestamp" // 请求签名时的unix秒数
+	HeaderSignature = "X-Webhook-Signature" // 请求签名, 格式为"sha256=<十六进制HMAC-SHA256>"
+)
+
+const signaturePrefix = "sha256="
+
+// Sign 计算请求签名: HMAC-SHA256(secret, timestamp + "." + body)
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature 供接收方校验请求签名. 接收方还应校验timestamp与当前时间的差值, 防止请求被重放
+func VerifySignature(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
@@ -0,0 +1,642 @@
+package verification_code_webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	. "github.com/DontBeProud/wow-easy-go/redis_support/verification_code_rdb"
+	"github.com/DontBeProud/wow-easy-go/utils/wow_random"
+	"github.com/DontBeProud/wow-easy-go/utils/wow_time"
+	"github.com/go-redis/redis/v8"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultHTTPTimeout     = 5 * time.Second    // 默认的请求超时时间
+	defaultMaxAttempts     = 8                  // 默认的最大投递次数
+	defaultBaseBackoff     = time.Second        // 默认的首次重试间隔
+	defaultMaxBackoff      = 10 * time.Minute   // 默认的最大重试间隔
+	defaultPollInterval    = time.Second        // 默认的重试队列轮询间隔
+	defaultBatchSize       = 20                 // 默认的单次轮询处理的最大投递数量
+	defaultLeaseDuration   = time.Minute        // 默认的投递租约时长
+	defaultEventBufferSize = 1000               // 默认的事件缓冲区容量
+	maxPendingDeliveries   = 1000               // redis不可用时内存中暂存的最大投递数量, 超出时丢弃最早的投递
+	maxDeadLetters         = 1000               // 死信列表保留的最大数量
+	defaultDeadLetterTTL   = 7 * 24 * time.Hour // 默认的死信列表保留时长
+	purgeScanCount         = 100                // 清除对象的投递时单次ZSCAN的COUNT
+	maxResponseBodyDiscard = 1 << 16            // 读取并丢弃的响应体的最大字节数
+	eventIdByteLength      = 16                 // 事件ID的随机字节数
+)
+
+// Endpoint 接收事件的webhook端点
+type Endpoint struct {
+	Name       string                             // 端点名称, 同一分发器内唯一. 重试队列按名称关联端点
+	URL        string                             // 接收事件的URL, 以POST方式发送JSON
+	Secret     string                             // 请求签名的密钥
+	EventTypes []EventType                        // 订阅的事件类型, 为空时订阅全部事件
+	Filter     func(event VerificationEvent) bool // 可选. 进一步过滤事件, 返回false时不向该端点投递
+}
+
+// DispatcherConfig webhook分发器的配置
+type DispatcherConfig struct {
+	Rdb           *redis.Client  // 存储重试队列的redis, 进程重启后未完成的投递可继续重试
+	ModuleName    string         // 业务模块名称, 作为重试队列字段名称的前缀
+	Endpoints     []Endpoint     // 接收事件的端点
+	HTTPClient    *http.Client   // 为nil时使用超时时间为5秒的默认客户端
+	MaxAttempts   int            // 单个投递的最大尝试次数, 超出后移入死信列表. 小于等于0时使用默认值(8)
+	BaseBackoff   time.Duration  // 首次重试的间隔, 此后每次翻倍. 小于等于0时使用默认值(1秒)
+	MaxBackoff    time.Duration  // 最大重试间隔. 小于等于0时使用默认值(10分钟)
+	PollInterval  time.Duration  // 后台轮询重试队列的间隔. 小于等于0时使用默认值(1秒)
+	BatchSize     int            // 单次轮询处理的最大投递数量. 小于等于0时使用默认值(20)
+	LeaseDuration time.Duration  // 投递租约时长, 投递超过该时长仍未完成(例如进程崩溃)时重新投递. 小于等于0时使用默认值(1分钟)
+	EventBuffer   int            // Handle接收事件的缓冲区容量, 缓冲区已满时丢弃新的事件. 小于等于0时使用默认值(1000)
+	DeadLetterTTL time.Duration  // 死信列表的保留时长, 每次写入死信后重新计时. 小于等于0时使用默认值(7天)
+	Clock         wow_time.Clock // 时钟, 为nil时使用系统时钟
+}
+
+// Payload 请求体
+type Payload struct {
+	Id         string            `json:"id"`          // 事件ID
+	Type       EventType         `json:"type"`        // 事件类型
+	ModuleName string            `json:"module_name"` // 业务模块名称
+	ObjName    string            `json:"obj_name"`    // 事件关联的对象名称
+	Time       time.Time         `json:"time"`        // 事件发生的时间
+	Detail     map[string]string `json:"detail"`      // 附加信息
+}
+
+// DeadLetter 超出最大尝试次数仍未投递成功的事件
+type DeadLetter struct {
+	Endpoint  string  `json:"endpoint"`   // 端点名称
+	Attempts  int     `json:"attempts"`   // 已尝试的次数
+	LastError string  `json:"last_error"` // 最后一次投递失败的原因
+	Payload   Payload `json:"payload"`    // 请求体
+}
+
+// 单个端点的一次投递. 请求体在入队时即序列化, 保证重试时的签名内容一致
+type delivery struct {
+	Endpoint string          `json:"endpoint"`
+	Attempts int             `json:"attempts"`
+	Body     json.RawMessage `json:"body"`
+	due      time.Time       // 下次投递的时间(仅用于内存中暂存的投递)
+}
+
+// 原子地领取到期的投递: 将其score更新为租约到期时间, 租约到期前未完成的投递将被再次领取
+// KEYS[1]: 重试队列. ARGV[1]: 当前时间(毫秒); ARGV[2]: 租约到期时间(毫秒); ARGV[3]: 最大领取数量
+var claimScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[3])
+for _, member in ipairs(due) do
+	redis.call('ZADD', KEYS[1], ARGV[2], member)
+end
+return due
+`)
+
+// Dispatcher webhook分发器. 将验证码服务的事件签名后投递至订阅的端点, 失败时按指数退避重试
+type Dispatcher struct {
+	dropped   uint64 // 因缓冲区已满而丢弃的事件数量. 原子操作, 置于首位以保证64位对齐
+	config    DispatcherConfig
+	endpoints map[string]Endpoint
+	mu        sync.Mutex
+	pending   []delivery             // redis不可用时暂存于内存的投递
+	events    chan VerificationEvent // Handle接收的事件的缓冲区
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// CreateDispatcher 创建webhook分发器. 将Handle配置为验证码服务的EventHandler, 并调用Start启动后台投递
+func CreateDispatcher(config DispatcherConfig) (*Dispatcher, error) {
+	if config.Rdb == nil {
+		return nil, errors.New("Rdb == nil")
+	}
+	if len(config.Endpoints) == 0 {
+		return nil, errors.New("no endpoint")
+	}
+
+	endpoints := make(map[string]Endpoint, len(config.Endpoints))
+	for _, e := range config.Endpoints {
+		if e.Name == "" || e.URL == "" || e.Secret == "" {
+			return nil, errors.New("endpoint name, url and secret must not be empty")
+		}
+		if _, ok := endpoints[e.Name]; ok {
+			return nil, errors.New("duplicate endpoint name: " + e.Name)
+		}
+		endpoints[e.Name] = e
+	}
+
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: defaultHTTPTimeout}
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaultMaxAttempts
+	}
+	if config.BaseBackoff <= 0 {
+		config.BaseBackoff = defaultBaseBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = defaultMaxBackoff
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = defaultPollInterval
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+	if config.LeaseDuration <= 0 {
+		config.LeaseDuration = defaultLeaseDuration
+	}
+	if config.DeadLetterTTL <= 0 {
+		config.DeadLetterTTL = defaultDeadLetterTTL
+	}
+	if config.EventBuffer <= 0 {
+		config.EventBuffer = defaultEventBufferSize
+	}
+	if config.Clock == nil {
+		config.Clock = wow_time.RealClock{}
+	}
+
+	return &Dispatcher{config: config, endpoints: endpoints, events: make(chan VerificationEvent, config.EventBuffer)}, nil
+}
+
+// Handle 处理验证码服务的事件, 可直接作为VerificationCodeRdbOptionalConfig.EventHandler
+// 事件放入缓冲区后立即返回, 不访问redis也不等待HTTP请求完成. 后台投递(或ProcessDue)将其写入redis的重试队列, redis不可用时暂存于内存
+// 缓冲区已满时丢弃事件, 丢弃的数量可通过QueryDroppedEvents查询
+func (d *Dispatcher) Handle(event VerificationEvent) {
+	select {
+	case d.events <- event:
+	default:
+		atomic.AddUint64(&d.dropped, 1)
+	}
+}
+
+// Start 启动后台投递
+func (d *Dispatcher) Start() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stop != nil {
+		return
+	}
+	d.stop = make(chan struct{})
+	d.done = make(chan struct{})
+	go d.run(d.stop, d.done)
+}
+
+// Stop 停止后台投递并等待进行中的投递完成. 缓冲区中的事件写入重试队列, 未完成的投递保留在重试队列中, 下次启动后继续
+func (d *Dispatcher) Stop() {
+	d.mu.Lock()
+	stop, done := d.stop, d.done
+	d.stop, d.done = nil, nil
+	d.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+	d.drainEvents()
+}
+
+// ProcessDue 立即处理全部到期的投递, 返回投递成功的数量. 后台投递即周期性地调用该方法
+func (d *Dispatcher) ProcessDue() (int, error) {
+	return d.processDue()
+}
+
+// QueryDroppedEvents 查询因缓冲区已满而丢弃的事件数量
+func (d *Dispatcher) QueryDroppedEvents() uint64 {
+	return atomic.LoadUint64(&d.dropped)
+}
+
+// QueryQueueLength 查询重试队列中等待投递的数量(不含缓冲区中的事件及redis不可用时暂存于内存的投递)
+func (d *Dispatcher) QueryQueueLength() (int64, error) {
+	return d.config.Rdb.ZCard(context.TODO(), d.getRedisFieldNameQueue()).Result()
+}
+
+// QueryDeadLetters 查询最近的死信(按移入时间降序排列)
+func (d *Dispatcher) QueryDeadLetters(limit int) ([]DeadLetter, error) {
+	return d.queryDeadLetters(limit)
+}
+
+// PurgeSubject 删除与对象相关的全部投递(缓冲区中的事件、暂存于内存的投递、重试队列及死信列表), 用于响应个人信息删除请求. 返回删除的投递数量
+// objName需与事件中的对象名称一致(即验证码服务规范化后的对象名称). 正在投递中的请求不受影响
+func (d *Dispatcher) PurgeSubject(moduleName string, objName string) (int, error) {
+	return d.purgeSubject(moduleName, objName)
+}
+
+// 将事件序列化并为每个订阅的端点生成投递
+func (d *Dispatcher) dispatch(event VerificationEvent) {
+	var deliveries []delivery
+	var body []byte
+	for _, e := range d.config.Endpoints {
+		if !e.subscribes(event) {
+			continue
+		}
+		if body == nil {
+			var err error
+			if body, err = d.encodePayload(event); err != nil {
+				return
+			}
+		}
+		deliveries = append(deliveries, delivery{Endpoint: e.Name, Body: body})
+	}
+	if len(deliveries) == 0 {
+		return
+	}
+
+	now := d.config.Clock.Now()
+	// redis不可用的事件无需再访问redis, 直接暂存于内存, redis恢复后由processPending写入重试队列
+	if event.Type == EventTypeRedisUnavailable {
+		d.holdPending(deliveries, now)
+		return
+	}
+	if err := d.enqueue(deliveries, now); err != nil {
+		d.holdPending(deliveries, now)
+	}
+}
+
+// 将缓冲区中的事件全部取出并生成投递
+func (d *Dispatcher) drainEvents() {
+	for {
+		select {
+		case event := <-d.events:
+			d.dispatch(event)
+		default:
+			return
+		}
+	}
+}
+
+// 端点是否订阅了该事件
+func (e Endpoint) subscribes(event VerificationEvent) bool {
+	if len(e.EventTypes) > 0 {
+		subscribed := false
+		for _, t := range e.EventTypes {
+			if t == event.Type {
+				subscribed = true
+				break
+			}
+		}
+		if !subscribed {
+			return false
+		}
+	}
+	return e.Filter == nil || e.Filter(event)
+}
+
+// 序列化请求体
+func (d *Dispatcher) encodePayload(event VerificationEvent) ([]byte, error) {
+	id, err := wow_random.GenerateSecureRandomHexString(eventIdByteLength)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Payload{
+		Id:         id,
+		Type:       event.Type,
+		ModuleName: event.ModuleName,
+		ObjName:    event.ObjName,
+		Time:       event.Time,
+		Detail:     event.Detail,
+	})
+}
+
+// 将投递写入redis的重试队列, 于due时刻到期
+func (d *Dispatcher) enqueue(deliveries []delivery, due time.Time) error {
+	members := make([]*redis.Z, 0, len(deliveries))
+	for _, dl := range deliveries {
+		member, err := json.Marshal(dl)
+		if err != nil {
+			return err
+		}
+		members = append(members, &redis.Z{Score: float64(due.UnixMilli()), Member: string(member)})
+	}
+	return d.config.Rdb.ZAdd(context.TODO(), d.getRedisFieldNameQueue(), members...).Err()
+}
+
+// redis不可用时将投递暂存于内存
+func (d *Dispatcher) holdPending(deliveries []delivery, due time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, dl := range deliveries {
+		dl.due = due
+		d.pending = append(d.pending, dl)
+	}
+	if len(d.pending) > maxPendingDeliveries {
+		d.pending = d.pending[len(d.pending)-maxPendingDeliveries:]
+	}
+}
+
+// 后台投递
+func (d *Dispatcher) run(stop chan struct{}, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(d.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case event := <-d.events:
+			d.dispatch(event)
+		case <-ticker.C:
+			_, _ = d.processDue()
+		}
+	}
+}
+
+// 处理全部到期的投递
+func (d *Dispatcher) processDue() (int, error) {
+	d.drainEvents()
+	delivered := d.processPending()
+	for {
+		n, claimed, err := d.processQueue()
+		delivered += n
+		if err != nil || claimed < d.config.BatchSize {
+			return delivered, err
+		}
+	}
+}
+
+// 处理暂存于内存的投递: redis恢复可用时转入重试队列, 否则直接投递到期的投递
+func (d *Dispatcher) processPending() int {
+	d.mu.Lock()
+	pending := d.pending
+	d.pending = nil
+	d.mu.Unlock()
+	if len(pending) == 0 {
+		return 0
+	}
+
+	if d.persistPending(pending) == nil {
+		return 0
+	}
+
+	now := d.config.Clock.Now()
+	var due, remain []delivery
+	for _, dl := range pending {
+		if dl.due.After(now) {
+			remain = append(remain, dl)
+		} else {
+			due = append(due, dl)
+		}
+	}
+
+	delivered := 0
+	for i, err := range d.deliverAll(due) {
+		dl := due[i]
+		if err == nil {
+			delivered++
+			continue
+		}
+		dl.Attempts++
+		if dl.Attempts < d.config.MaxAttempts {
+			dl.due = now.Add(d.backoff(dl.Attempts))
+			remain = append(remain, dl)
+		}
+	}
+
+	d.mu.Lock()
+	d.pending = append(remain, d.pending...)
+	d.mu.Unlock()
+	return delivered
+}
+
+// 将暂存于内存的投递按各自的到期时间写入重试队列
+func (d *Dispatcher) persistPending(pending []delivery) error {
+	_, err := d.config.Rdb.Pipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+		for _, dl := range pending {
+			member, err := json.Marshal(dl)
+			if err != nil {
+				continue
+			}
+			pipe.ZAdd(context.TODO(), d.getRedisFieldNameQueue(), &redis.Z{Score: float64(dl.due.UnixMilli()), Member: string(member)})
+		}
+		return nil
+	})
+	return err
+}
+
+// 领取并投递一批到期的投递. 返回投递成功的数量及领取的数量
+func (d *Dispatcher) processQueue() (delivered int, claimed int, err error) {
+	ctx := context.TODO()
+	now := d.config.Clock.Now()
+	key := d.getRedisFieldNameQueue()
+	members, err := claimScript.Run(ctx, d.config.Rdb, []string{key},
+		now.UnixMilli(), now.Add(d.config.LeaseDuration).UnixMilli(), d.config.BatchSize,
+	).StringSlice()
+	if err != nil || len(members) == 0 {
+		return 0, 0, err
+	}
+
+	deliveries := make([]delivery, len(members))
+	for i, member := range members {
+		// 无法解析的投递将投递失败并移入死信列表
+		_ = json.Unmarshal([]byte(member), &deliveries[i])
+	}
+	results := d.deliverAll(deliveries)
+
+	_, err = d.config.Rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, member := range members {
+			pipe.ZRem(ctx, key, member)
+			if results[i] == nil {
+				delivered++
+				continue
+			}
+
+			dl := deliveries[i]
+			dl.Attempts++
+			if dl.Attempts < d.config.MaxAttempts && dl.Endpoint != "" {
+				retry, _ := json.Marshal(dl)
+				pipe.ZAdd(ctx, key, &redis.Z{Score: float64(now.Add(d.backoff(dl.Attempts)).UnixMilli()), Member: string(retry)})
+				continue
+			}
+
+			dead := DeadLetter{Endpoint: dl.Endpoint, Attempts: dl.Attempts, LastError: results[i].Error()}
+			_ = json.Unmarshal(dl.Body, &dead.Payload)
+			value, _ := json.Marshal(dead)
+			pipe.LPush(ctx, d.getRedisFieldNameDeadLetter(), string(value))
+			pipe.LTrim(ctx, d.getRedisFieldNameDeadLetter(), 0, maxDeadLetters-1)
+			pipe.Expire(ctx, d.getRedisFieldNameDeadLetter(), d.config.DeadLetterTTL)
+		}
+		return nil
+	})
+	return delivered, len(members), err
+}
+
+// 并发地投递, 返回每个投递的结果
+func (d *Dispatcher) deliverAll(deliveries []delivery) []error {
+	results := make([]error, len(deliveries))
+	wg := sync.WaitGroup{}
+	for i := range deliveries {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = d.deliver(deliveries[i])
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+// 向端点发送签名后的请求, 响应2xx视为投递成功
+func (d *Dispatcher) deliver(dl delivery) error {
+	e, ok := d.endpoints[dl.Endpoint]
+	if !ok {
+		return errors.New("unknown endpoint: " + dl.Endpoint)
+	}
+
+	var p Payload
+	if err := json.Unmarshal(dl.Body, &p); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.URL, bytes.NewReader(dl.Body))
+	if err != nil {
+		return err
+	}
+	timestamp := d.config.Clock.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderId, p.Id)
+	req.Header.Set(HeaderEvent, string(p.Type))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(e.Secret, timestamp, dl.Body))
+
+	resp, err := d.config.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxResponseBodyDiscard))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// 第attempts次投递失败后的重试间隔
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	b := d.config.BaseBackoff
+	for i := 1; i < attempts; i++ {
+		b *= 2
+		if b >= d.config.MaxBackoff {
+			return d.config.MaxBackoff
+		}
+	}
+	if b > d.config.MaxBackoff {
+		return d.config.MaxBackoff
+	}
+	return b
+}
+
+// 查询最近的死信
+func (d *Dispatcher) queryDeadLetters(limit int) ([]DeadLetter, error) {
+	if limit <= 0 {
+		return nil, errors.New("limit <= 0")
+	}
+
+	values, err := d.config.Rdb.LRange(context.TODO(), d.getRedisFieldNameDeadLetter(), 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]DeadLetter, 0, len(values))
+	for _, v := range values {
+		var dl DeadLetter
+		if json.Unmarshal([]byte(v), &dl) == nil {
+			res = append(res, dl)
+		}
+	}
+	return res, nil
+}
+
+// 删除与对象相关的全部投递
+func (d *Dispatcher) purgeSubject(moduleName string, objName string) (int, error) {
+	// 缓冲区中的事件先生成投递, 再统一按请求体过滤
+	d.drainEvents()
+	purged := d.purgePending(moduleName, objName)
+
+	ctx := context.TODO()
+	queue, deadLetter := d.getRedisFieldNameQueue(), d.getRedisFieldNameDeadLetter()
+	var members []interface{}
+	var cursor uint64
+	for {
+		values, next, err := d.config.Rdb.ZScan(ctx, queue, cursor, "", purgeScanCount).Result()
+		if err != nil {
+			return purged, err
+		}
+		// ZSCAN依次返回成员及其score
+		for i := 0; i < len(values); i += 2 {
+			var dl delivery
+			if json.Unmarshal([]byte(values[i]), &dl) == nil && dl.concerns(moduleName, objName) {
+				members = append(members, values[i])
+			}
+		}
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+
+	values, err := d.config.Rdb.LRange(ctx, deadLetter, 0, -1).Result()
+	if err != nil {
+		return purged, err
+	}
+	letters := map[string]bool{}
+	for _, v := range values {
+		var dead DeadLetter
+		if json.Unmarshal([]byte(v), &dead) == nil && dead.Payload.ModuleName == moduleName && dead.Payload.ObjName == objName {
+			letters[v] = true
+		}
+	}
+	if len(members) == 0 && len(letters) == 0 {
+		return purged, nil
+	}
+
+	var cmds []*redis.IntCmd
+	if _, err = d.config.Rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if len(members) > 0 {
+			cmds = append(cmds, pipe.ZRem(ctx, queue, members...))
+		}
+		for v := range letters {
+			cmds = append(cmds, pipe.LRem(ctx, deadLetter, 0, v))
+		}
+		return nil
+	}); err != nil {
+		return purged, err
+	}
+	for _, cmd := range cmds {
+		purged += int(cmd.Val())
+	}
+	return purged, nil
+}
+
+// 删除暂存于内存的与对象相关的投递, 返回删除的数量
+func (d *Dispatcher) purgePending(moduleName string, objName string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	remain := d.pending[:0]
+	for _, dl := range d.pending {
+		if !dl.concerns(moduleName, objName) {
+			remain = append(remain, dl)
+		}
+	}
+	purged := len(d.pending) - len(remain)
+	d.pending = remain
+	return purged
+}
+
+// 投递的请求体是否与对象相关
+func (dl delivery) concerns(moduleName string, objName string) bool {
+	var p Payload
+	return json.Unmarshal(dl.Body, &p) == nil && p.ModuleName == moduleName && p.ObjName == objName
+}
+
+// 生成存储重试队列(有序集合, score为下次投递时间的unix毫秒数)的字段名称
+func (d *Dispatcher) getRedisFieldNameQueue() string {
+	return d.config.ModuleName + "VerificationCodeWebhookQueue"
+}
+
+// 生成存储死信列表的字段名称
+func (d *Dispatcher) getRedisFieldNameDeadLetter() string {
+	return d.config.ModuleName + "VerificationCodeWebhookDeadLetter"
+}
@@ -0,0 +1,232 @@
+package verification_code_webhook
+
+import (
+	"context"
+	"encoding/json"
+	. "github.com/DontBeProud/wow-easy-go/redis_support/verification_code_rdb"
+	"github.com/DontBeProud/wow-easy-go/utils/wow_time"
+	"github.com/go-redis/redis/v8"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testSecret = "webhook-secret"
+
+// 记录收到的请求的测试服务, failFirst: 每个路径的前若干次请求响应500
+type testReceiver struct {
+	mu        sync.Mutex
+	failFirst map[string]int
+	received  map[string][]Payload
+	attempts  map[string]int
+	badSigned int
+}
+
+func createTestReceiver(t *testing.T, failFirst map[string]int) (*testReceiver, *httptest.Server) {
+	rc := &testReceiver{failFirst: failFirst, received: map[string][]Payload{}, attempts: map[string]int{}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		timestamp, _ := strconv.ParseInt(req.Header.Get(HeaderTimestamp), 10, 64)
+
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		if !VerifySignature(testSecret, timestamp, body, req.Header.Get(HeaderSignature)) {
+			rc.badSigned++
+		}
+		rc.attempts[req.URL.Path]++
+		if rc.attempts[req.URL.Path] <= rc.failFirst[req.URL.Path] {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var p Payload
+		_ = json.Unmarshal(body, &p)
+		if p.Id != req.Header.Get(HeaderId) || string(p.Type) != req.Header.Get(HeaderEvent) {
+			rc.badSigned++
+		}
+		rc.received[req.URL.Path] = append(rc.received[req.URL.Path], p)
+	}))
+	t.Cleanup(srv.Close)
+	return rc, srv
+}
+
+func (rc *testReceiver) count(path string) int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return len(rc.received[path])
+}
+
+func TestSignature(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+	sig := Sign(testSecret, 1700000000, body)
+	if !VerifySignature(testSecret, 1700000000, body, sig) {
+		t.Error("VerifySignature有bug")
+	}
+	if VerifySignature(testSecret, 1700000001, body, sig) || VerifySignature("other", 1700000000, body, sig) || VerifySignature(testSecret, 1700000000, []byte(`{"id":"2"}`), sig) {
+		t.Error("VerifySignature有bug")
+	}
+}
+
+// redis不可用时投递暂存于内存, 仍按过滤规则投递并按退避间隔重试
+func TestDispatcherWithoutRedis(t *testing.T) {
+	rc, srv := createTestReceiver(t, map[string]int{"/siem": 1})
+	clock := wow_time.CreateFakeClock(time.Now())
+	d, err := CreateDispatcher(DispatcherConfig{
+		Rdb:        redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"}),
+		ModuleName: "Webhook",
+		Endpoints: []Endpoint{
+			{Name: "siem", URL: srv.URL + "/siem", Secret: testSecret},
+			{Name: "oncall", URL: srv.URL + "/oncall", Secret: testSecret, EventTypes: []EventType{EventTypeSubjectBanned}, Filter: func(event VerificationEvent) bool {
+				return event.ObjName != "ignored"
+			}},
+		},
+		MaxAttempts: 2,
+		BaseBackoff: time.Minute,
+		Clock:       clock,
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	d.Handle(VerificationEvent{Type: EventTypeSubjectBanned, ModuleName: "Webhook", ObjName: "13800138000", Time: clock.Now(), Detail: map[string]string{"until": "tomorrow"}})
+	d.Handle(VerificationEvent{Type: EventTypeSubjectBanned, ModuleName: "Webhook", ObjName: "ignored", Time: clock.Now()})
+	d.Handle(VerificationEvent{Type: EventTypeBudgetExhausted, ModuleName: "Webhook", Time: clock.Now()})
+
+	// siem首次请求失败, 其余请求成功; oncall仅订阅封禁事件且过滤了ignored
+	if n, _ := d.ProcessDue(); n != 3 || rc.count("/siem") != 2 || rc.count("/oncall") != 1 {
+		t.Error("ProcessDue有bug")
+	}
+	if p := rc.received["/oncall"]; len(p) != 1 || p[0].ObjName != "13800138000" || p[0].Detail["until"] != "tomorrow" || p[0].ModuleName != "Webhook" {
+		t.Error("Payload有bug")
+	}
+
+	// 未到重试时间
+	if n, _ := d.ProcessDue(); n != 0 {
+		t.Error("backoff有bug")
+	}
+	clock.Advance(time.Minute)
+	if n, _ := d.ProcessDue(); n != 1 || rc.count("/siem") != 3 {
+		t.Error("retry有bug")
+	}
+	if rc.badSigned != 0 {
+		t.Error("Sign有bug")
+	}
+}
+
+// Handle不阻塞调用方: 缓冲区已满时丢弃事件; redis不可用的事件不访问redis, 直接暂存于内存
+func TestDispatcherHandle(t *testing.T) {
+	rc, srv := createTestReceiver(t, nil)
+	d, err := CreateDispatcher(DispatcherConfig{
+		Rdb:         redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"}),
+		ModuleName:  "Webhook",
+		Endpoints:   []Endpoint{{Name: "siem", URL: srv.URL + "/siem", Secret: testSecret}},
+		EventBuffer: 2,
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for i := 0; i < 3; i++ {
+		d.Handle(VerificationEvent{Type: EventTypeRedisUnavailable, ModuleName: "Webhook", Time: time.Now()})
+	}
+	if d.QueryDroppedEvents() != 1 {
+		t.Error("QueryDroppedEvents有bug")
+	}
+	if n, _ := d.ProcessDue(); n != 2 || rc.count("/siem") != 2 {
+		t.Error("ProcessDue有bug")
+	}
+
+	// 清除对象的投递: 缓冲区中的事件及暂存于内存的投递均被删除
+	d.Handle(VerificationEvent{Type: EventTypeSubjectBanned, ModuleName: "Webhook", ObjName: "13800138000", Time: time.Now()})
+	d.Handle(VerificationEvent{Type: EventTypeSubjectBanned, ModuleName: "Webhook", ObjName: "13900139000", Time: time.Now()})
+	if n, _ := d.PurgeSubject("Webhook", "13800138000"); n != 1 {
+		t.Error("PurgeSubject有bug")
+	}
+	if n, _ := d.ProcessDue(); n != 1 || rc.count("/siem") != 3 || rc.received["/siem"][2].ObjName != "13900139000" {
+		t.Error("PurgeSubject有bug")
+	}
+
+	// 停止前缓冲区中的事件同样会被处理
+	d.Start()
+	d.Handle(VerificationEvent{Type: EventTypeSubjectBanned, ModuleName: "Webhook", ObjName: "13800138000", Time: time.Now()})
+	d.Stop()
+	if n, _ := d.ProcessDue(); n != 1 || rc.count("/siem") != 4 {
+		t.Error("Stop有bug")
+	}
+}
+
+// 基于redis的重试队列: 重启(重新创建分发器)后继续重试, 超出最大尝试次数后移入死信列表
+func TestDispatcherWithRedis(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:6379"})
+	if err := rdb.Ping(context.TODO()).Err(); err != nil {
+		t.Skip("redis is unavailable: " + err.Error())
+	}
+	rdb.Del(context.TODO(), "WebhookTestVerificationCodeWebhookQueue", "WebhookTestVerificationCodeWebhookDeadLetter")
+
+	rc, srv := createTestReceiver(t, map[string]int{"/siem": 1, "/broken": 100})
+	clock := wow_time.CreateFakeClock(time.Now())
+	config := DispatcherConfig{
+		Rdb:        rdb,
+		ModuleName: "WebhookTest",
+		Endpoints: []Endpoint{
+			{Name: "siem", URL: srv.URL + "/siem", Secret: testSecret},
+			{Name: "broken", URL: srv.URL + "/broken", Secret: testSecret},
+		},
+		MaxAttempts: 2,
+		BaseBackoff: time.Minute,
+		Clock:       clock,
+	}
+	d, err := CreateDispatcher(config)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	d.Handle(VerificationEvent{Type: EventTypeSubjectRiskThreshold, ModuleName: "WebhookTest", ObjName: "13800138000", Time: clock.Now()})
+	// Handle仅将事件放入缓冲区, 不访问redis
+	if l, _ := d.QueryQueueLength(); l != 0 {
+		t.Error("Handle有bug")
+	}
+	if n, _ := d.ProcessDue(); n != 0 {
+		t.Error("ProcessDue有bug")
+	}
+	if l, _ := d.QueryQueueLength(); l != 2 {
+		t.Error("ProcessDue有bug")
+	}
+
+	// 重新创建分发器, 重试队列中的投递继续重试
+	d, _ = CreateDispatcher(config)
+	clock.Advance(time.Minute)
+	if n, _ := d.ProcessDue(); n != 1 || rc.count("/siem") != 1 {
+		t.Error("retry有bug")
+	}
+	if l, _ := d.QueryQueueLength(); l != 0 {
+		t.Error("retry有bug")
+	}
+	letters, err := d.QueryDeadLetters(10)
+	if err != nil || len(letters) != 1 || letters[0].Endpoint != "broken" || letters[0].Attempts != 2 || letters[0].Payload.ObjName != "13800138000" {
+		t.Error("QueryDeadLetters有bug")
+	}
+	if rdb.TTL(context.TODO(), "WebhookTestVerificationCodeWebhookDeadLetter").Val() <= 0 {
+		t.Error("死信列表的保留时长有bug")
+	}
+
+	// 清除对象的投递: 重试队列及死信列表中的投递均被删除, 其他对象的投递不受影响
+	d.Handle(VerificationEvent{Type: EventTypeSubjectBanned, ModuleName: "WebhookTest", ObjName: "13800138000", Time: clock.Now()})
+	d.Handle(VerificationEvent{Type: EventTypeSubjectBanned, ModuleName: "WebhookTest", ObjName: "13900139000", Time: clock.Now()})
+	if n, err := d.PurgeSubject("WebhookTest", "13800138000"); err != nil || n != 3 {
+		t.Error("PurgeSubject有bug")
+	}
+	if l, _ := d.QueryQueueLength(); l != 2 {
+		t.Error("PurgeSubject有bug")
+	}
+	if letters, _ = d.QueryDeadLetters(10); len(letters) != 0 {
+		t.Error("PurgeSubject有bug")
+	}
+	_, _ = d.PurgeSubject("WebhookTest", "13900139000")
+	if rc.badSigned != 0 {
+		t.Error("Sign有bug")
+	}
+}
@@ -0,0 +1,105 @@
+package base
+
+import (
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultHealthProbeInterval 默认的健康探测间隔
+	DefaultHealthProbeInterval = 5 * time.Second
+)
+
+// HealthProbe 基于VerifyConnection的redis健康探针. 定期探测redis是否可用, 并在可用状态发生切换时回调onChange
+type HealthProbe struct {
+	HealthProbeInterface
+	rDb      *redis.Client
+	interval time.Duration
+	healthy  int32                         // 1: 可用 0: 不可用
+	onChange func(healthy bool, err error) // 状态切换回调, err为探测失败时的错误信息
+	stopChan chan struct{}
+	stopOnce *sync.Once
+}
+
+type HealthProbeInterface interface {
+	IsHealthy() bool      // redis当前是否可用
+	Probe() (bool, error) // 立即探测一次, 并根据结果更新状态
+	Stop()                // 停止定期探测
+}
+
+// CreateHealthProbe 创建并启动健康探针
+// interval: 探测间隔, 小于等于0时使用 DefaultHealthProbeInterval
+// onChange: 可选, 可用状态发生切换时的回调
+func CreateHealthProbe(rDb *redis.Client, interval time.Duration, onChange func(healthy bool, err error)) (*HealthProbe, error) {
+	if rDb == nil {
+		return nil, errors.New("rdb == nil")
+	}
+
+	if interval <= 0 {
+		interval = DefaultHealthProbeInterval
+	}
+
+	p := &HealthProbe{
+		rDb:      rDb,
+		interval: interval,
+		healthy:  1,
+		onChange: onChange,
+		stopChan: make(chan struct{}),
+		stopOnce: &sync.Once{},
+	}
+	_, _ = p.Probe()
+	go p.loop()
+	return p, nil
+}
+
+// IsHealthy redis当前是否可用
+func (p *HealthProbe) IsHealthy() bool {
+	return atomic.LoadInt32(&p.healthy) == 1
+}
+
+// Probe 立即探测一次, 并根据结果更新状态(状态发生切换时触发回调)
+func (p *HealthProbe) Probe() (bool, error) {
+	ok, err := VerifyConnection(p.rDb)
+
+	newState := int32(0)
+	if ok {
+		newState = 1
+	}
+	if atomic.SwapInt32(&p.healthy, newState) != newState && p.onChange != nil {
+		p.onChange(ok, err)
+	}
+	return ok, err
+}
+
+// Stop 停止定期探测
+func (p *HealthProbe) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stopChan)
+	})
+}
+
+func (p *HealthProbe) loop() {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stopChan:
+			return
+		case <-ticker.C:
+			_, _ = p.Probe()
+		}
+	}
+}
+
+// IsUnavailableError 判断错误是否由redis不可用(网络错误、连接池耗尽等)导致. redis.Nil及redis服务端返回的错误不属于此类
+func IsUnavailableError(err error) bool {
+	if err == nil || err == redis.Nil {
+		return false
+	}
+	var redisErr redis.Error
+	return !errors.As(err, &redisErr)
+}
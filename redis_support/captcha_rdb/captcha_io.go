@@ -0,0 +1,81 @@
+package captcha_rdb
+
+import (
+	. "github.com/DontBeProud/wow-easy-go/redis_support/verification_code_rdb"
+	"github.com/DontBeProud/wow-easy-go/utils/wow_captcha"
+	"github.com/DontBeProud/wow-easy-go/utils/wow_random"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	challengeIdByteLength = 16 // 挑战ID的随机字节数
+)
+
+// 生成图形验证码, 并以挑战ID为对象名称存储验证码文本
+func (c CaptchaRdb) generateCaptcha() (challengeId string, img []byte, err error) {
+	text, img, err := c.config.GenerateCaptcha()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if challengeId, err = c.registerCaptcha(text); err != nil {
+		return "", nil, err
+	}
+	return challengeId, img, nil
+}
+
+// 生成挑战ID并存储验证码文本
+func (c CaptchaRdb) registerCaptcha(text string) (challengeId string, err error) {
+	challengeId, err = wow_random.GenerateSecureRandomHexString(challengeIdByteLength)
+	if err != nil {
+		return "", err
+	}
+
+	if err = c.codeRdb.SetAndRegisterVerificationCode(challengeId, text); err != nil {
+		return "", err
+	}
+	// 挑战ID仅使用一次, 无需按日记录其未核销的验证码. 立即清除, 仅保留随验证码过期的字段, 避免大量挑战ID的按日字段滞留至次日
+	if err = c.codeRdb.ResetCounters(challengeId); err != nil {
+		return "", err
+	}
+	return challengeId, nil
+}
+
+// 核销图形验证码(先校验挑战ID的状态, 再进行核销). 每个挑战ID仅可尝试一次, 核销失败后即失效
+func (c CaptchaRdb) verifyCaptcha(challengeId string, answer string) (it InvalidType, exist bool, success bool, err error) {
+	if it, err = c.codeRdb.PreCheckBeforeVerifyAndUseVerificationCode(challengeId); err != nil || it != UserIsValid {
+		return it, false, false, err
+	}
+
+	exist, success, err = c.codeRdb.VerifyAndUseVerificationCode(challengeId, answer)
+	if err != nil || !exist || success {
+		return it, exist, success, err
+	}
+
+	// 核销失败: 吊销验证码并清除失败计数, 避免对短验证码进行穷举
+	if _, err = c.codeRdb.Revoke(challengeId); err != nil {
+		return it, exist, false, err
+	}
+	return it, exist, false, c.codeRdb.ResetCounters(challengeId)
+}
+
+func createCaptchaRdb(rdb *redis.Client, moduleName string, strategy VerificationCodeServiceStrategy, config wow_captcha.CaptchaConfig) (*CaptchaRdb, error) {
+	if err := config.CheckError(); err != nil {
+		return nil, err
+	}
+
+	// 图形验证码的答案忽略全角/半角、空白; 字符统一按大写字形绘制, 用户无法分辨大小写, 因此同时忽略大小写
+	strategy.CodeComparisonStrategy.FoldWidth = true
+	strategy.CodeComparisonStrategy.StripSeparators = true
+	strategy.CodeComparisonStrategy.CaseInsensitive = true
+
+	codeRdb, err := CreateVerificationCodeRdb(rdb, moduleName, strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CaptchaRdb{
+		codeRdb: codeRdb,
+		config:  config,
+	}, nil
+}
@@ -0,0 +1,53 @@
+package captcha_rdb
+
+import (
+	"github.com/DontBeProud/wow-easy-go/redis_support/base"
+	. "github.com/DontBeProud/wow-easy-go/redis_support/verification_code_rdb"
+	"github.com/DontBeProud/wow-easy-go/utils/wow_captcha"
+	"github.com/go-redis/redis/v8"
+)
+
+// CaptchaRdb 图形验证码服务. 以挑战ID作为对象名称存储于VerificationCodeRdb中, 复用其有效期及一次性核销机制. 每个挑战ID仅可尝试一次
+type CaptchaRdb struct {
+	CaptchaRdbInterface
+	codeRdb *VerificationCodeRdb      // 存储与核销图形验证码的Rdb
+	config  wow_captcha.CaptchaConfig // 图形验证码的生成配置
+}
+
+// CreateCaptchaRdb 创建图形验证码服务
+// strategy: 图形验证码的存储与核销策略. 每个挑战ID仅可尝试一次, 因此其中的失败次数及未核销的验证码数量限制不生效
+func CreateCaptchaRdb(rdb *redis.Client, moduleName string, strategy VerificationCodeServiceStrategy, config wow_captcha.CaptchaConfig) (*CaptchaRdb, error) {
+	return createCaptchaRdb(rdb, moduleName, strategy, config)
+}
+
+type CaptchaRdbInterface interface {
+	base.RdbBaseInterface
+	GenerateCaptcha() (challengeId string, img []byte, err error)
+	VerifyCaptcha(challengeId string, answer string) (it InvalidType, exist bool, success bool, err error)
+	QueryCaptchaConfig() wow_captcha.CaptchaConfig
+}
+
+// VerifyConnection 判断redis是否成功连接并可用
+func (c CaptchaRdb) VerifyConnection() (bool, error) {
+	return c.codeRdb.VerifyConnection()
+}
+
+// GenerateCaptcha 生成图形验证码
+// challengeId: 挑战ID, 需随图片一同返回给客户端, 核销时回传
+// img: PNG格式的图片内容
+func (c CaptchaRdb) GenerateCaptcha() (challengeId string, img []byte, err error) {
+	return c.generateCaptcha()
+}
+
+// VerifyCaptcha 核销图形验证码
+// it: 挑战ID当前的状态, 若不为UserIsValid则说明该挑战ID已被禁止核销(例如失败次数过多), 此时exist与success无意义
+// exist: 挑战ID对应的验证码是否存在(不存在或已过期/已核销/已尝试)
+// success: 是否核销成功. 核销失败后挑战ID即失效, 需重新生成图形验证码
+func (c CaptchaRdb) VerifyCaptcha(challengeId string, answer string) (it InvalidType, exist bool, success bool, err error) {
+	return c.verifyCaptcha(challengeId, answer)
+}
+
+// QueryCaptchaConfig 查询图形验证码的生成配置
+func (c CaptchaRdb) QueryCaptchaConfig() wow_captcha.CaptchaConfig {
+	return c.config
+}
@@ -0,0 +1,70 @@
+package captcha_rdb
+
+import (
+	. "github.com/DontBeProud/wow-easy-go/redis_support/verification_code_rdb"
+	"github.com/DontBeProud/wow-easy-go/utils/wow_captcha"
+	"github.com/go-redis/redis/v8"
+	"testing"
+)
+
+const (
+	redisCon    = "localhost:6379"
+	redisPsw    = ""
+	redisDb     = 1
+	testCaptcha = "AB12"
+)
+
+var (
+	r = redis.NewClient(&redis.Options{
+		Addr:     redisCon,
+		Password: redisPsw,
+		DB:       redisDb,
+	})
+
+	strategy, _ = CreateVerificationCodeServiceStrategy(120, 0, 0, 0, nil)
+)
+
+func TestGenerateCaptcha(t *testing.T) {
+	c, err := CreateCaptchaRdb(r, "Captcha", *strategy, wow_captcha.DefaultCaptchaConfig())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	challengeId, img, err := c.GenerateCaptcha()
+	if err != nil || challengeId == "" || len(img) == 0 {
+		t.Error("生成图形验证码有bug")
+	}
+	if st, _ := c.codeRdb.QueryVerificationStatus(challengeId); !st.CodeExist || st.UnusedCodeCount != 0 {
+		t.Error("挑战ID的按日字段未清除")
+	}
+}
+
+func TestVerifyCaptcha(t *testing.T) {
+	c, err := CreateCaptchaRdb(r, "Captcha", *strategy, wow_captcha.DefaultCaptchaConfig())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// 答案忽略大小写及空白
+	challengeId, err := c.registerCaptcha(testCaptcha)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if it, exist, success, err := c.VerifyCaptcha(challengeId, " ab12 "); err != nil || it != UserIsValid || !exist || !success {
+		t.Error("核销图形验证码有bug")
+	}
+	if _, exist, success, _ := c.VerifyCaptcha(challengeId, testCaptcha); exist || success {
+		t.Error("图形验证码仅能核销一次")
+	}
+
+	// 答错后挑战ID即失效, 正确答案也无法再核销, 且不遗留失败计数
+	challengeId, _ = c.registerCaptcha(testCaptcha)
+	if _, exist, success, err := c.VerifyCaptcha(challengeId, "XXXX"); err != nil || !exist || success {
+		t.Error("核销图形验证码有bug")
+	}
+	if _, exist, success, _ := c.VerifyCaptcha(challengeId, testCaptcha); exist || success {
+		t.Error("答错后图形验证码未失效")
+	}
+	if st, _ := c.codeRdb.QueryVerificationStatus(challengeId); st.CodeExist || st.ErrorsCountToday != 0 || st.UnusedCodeCount != 0 {
+		t.Error("答错后遗留了挑战ID的数据")
+	}
+}
@@ -0,0 +1,178 @@
+package verification_code_rdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+// VerificationCodeAdminInterface 运维操作: 解封、重置、作废及策略的持久化
+type VerificationCodeAdminInterface interface {
+	Unban(objName string) error
+	ResetCounters(objName string) error
+	Revoke(objName string) (existed bool, err error)
+	SaveStrategy() error
+	QueryPersistedStrategy() (exist bool, snapshot StrategySnapshot, err error)
+	QueryLocation() *time.Location
+}
+
+// 管理员操作的名称, 记录于历史记录的Detail["operation"]中
+const (
+	AdminOperationUnban         = "unban"
+	AdminOperationResetCounters = "reset_counters"
+	AdminOperationRevoke        = "revoke"
+)
+
+// StrategySnapshot 可序列化的策略快照, 用于将策略持久化至redis或以JSON格式展示
+type StrategySnapshot struct {
+	ValidityDuration             int64                  `json:"validity_duration"`
+	RequestTimeIntervalThreshold int64                  `json:"request_time_interval_threshold"`
+	DenyThresholdOfUnusedCode    int                    `json:"deny_threshold_of_unused_code"`
+	DenyThresholdOfFailedCount   int                    `json:"deny_threshold_of_failed_count"`
+	TemporarilyBanStrategy       map[int]int64          `json:"temporarily_ban_strategy,omitempty"`
+	CodeComparisonStrategy       CodeComparisonStrategy `json:"code_comparison_strategy"`
+	ResendStrategy               ResendStrategy         `json:"resend_strategy"`
+	ExtendStrategy               ExtendStrategy         `json:"extend_strategy"`
+}
+
+// Snapshot 生成策略快照
+func (s VerificationCodeServiceStrategy) Snapshot() StrategySnapshot {
+	res := StrategySnapshot{
+		ValidityDuration:             s.ValidityDuration,
+		RequestTimeIntervalThreshold: s.RequestTimeIntervalThreshold,
+		DenyThresholdOfUnusedCode:    s.DenyThresholdOfUnusedCode,
+		DenyThresholdOfFailedCount:   s.DenyThresholdOfFailedCount,
+		CodeComparisonStrategy:       s.CodeComparisonStrategy,
+		ResendStrategy:               s.ResendStrategy,
+		ExtendStrategy:               s.ExtendStrategy,
+	}
+	if s.TemporarilyBanStrategy != nil {
+		res.TemporarilyBanStrategy = *s.QueryTemporarilyBanStrategy()
+	}
+	return res
+}
+
+// ToStrategy 根据策略快照创建策略
+func (s StrategySnapshot) ToStrategy() (*VerificationCodeServiceStrategy, error) {
+	res, err := CreateVerificationCodeServiceStrategy(s.ValidityDuration, s.RequestTimeIntervalThreshold, s.DenyThresholdOfUnusedCode, s.DenyThresholdOfFailedCount, &s.TemporarilyBanStrategy)
+	if err != nil {
+		return nil, err
+	}
+	res.CodeComparisonStrategy = s.CodeComparisonStrategy
+	res.ResendStrategy = s.ResendStrategy
+	res.ExtendStrategy = s.ExtendStrategy
+	return res, nil
+}
+
+// 查询对象的验证码状态
+func (r VerificationCodeRdb) queryVerificationStatus(objName string) (VerificationStatus, error) {
+	res, err := r.batchQueryVerificationStatus([]string{objName})
+	if err != nil {
+		return VerificationStatus{ObjName: objName}, err
+	}
+	return res[0], res[0].Err
+}
+
+// 解除对象当日因核销失败导致的封禁(删除当日失败次数与最后一次失败的时间)
+func (r VerificationCodeRdb) unban(objName string) error {
+	if err := r.rDb.Del(context.TODO(),
+		r.getRedisFieldNameVerificationCodeErrorCount(objName),
+		r.getRedisFieldNameVerificationCodeLastFailedTime(objName),
+	).Err(); err != nil {
+		return err
+	}
+	r.historyAdmin(objName, AdminOperationUnban, nil)
+	return nil
+}
+
+// 重置对象当日的全部计数(未核销的验证码集合、失败次数、最后一次失败的时间、重发次数). 当前有效的验证码不受影响
+func (r VerificationCodeRdb) resetCounters(objName string) error {
+	if err := r.rDb.Del(context.TODO(),
+		r.getRedisFieldNameVerificationCodeSet(objName),
+		r.getRedisFieldNameVerificationCodeErrorCount(objName),
+		r.getRedisFieldNameVerificationCodeLastFailedTime(objName),
+		r.getRedisFieldNameVerificationCodeResendCount(objName),
+	).Err(); err != nil {
+		return err
+	}
+	r.historyAdmin(objName, AdminOperationResetCounters, nil)
+	return nil
+}
+
+// 吊销对象当前有效的验证码(删除验证码并从当日未核销的验证码集合中移除)
+func (r VerificationCodeRdb) revoke(objName string) (existed bool, err error) {
+	if r.isDegraded() {
+		return r.degradedRevoke(objName)
+	}
+
+	exist, code, err := r.getVerificationCode(objName)
+	if err != nil || !exist {
+		return false, err
+	}
+
+	if _, err = r.rDb.Pipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+		pipe.Del(context.TODO(), r.getRedisFieldNameVerificationCode(objName))
+		pipe.SRem(context.TODO(), r.getRedisFieldNameVerificationCodeSet(objName), code)
+		pipe.Del(context.TODO(), r.getRedisFieldNameVerificationCodeExtension(objName))
+		return nil
+	}); err != nil {
+		return false, err
+	}
+	r.historyAdmin(objName, AdminOperationRevoke, nil)
+	return true, nil
+}
+
+// 将当前策略持久化至redis
+func (r VerificationCodeRdb) saveStrategy() error {
+	b, err := json.Marshal(r.strategy.Snapshot())
+	if err != nil {
+		return err
+	}
+	return r.rDb.Set(context.TODO(), r.getRedisFieldNameVerificationCodeStrategy(), b, 0).Err()
+}
+
+// 查询持久化于redis中的策略
+func (r VerificationCodeRdb) queryPersistedStrategy() (exist bool, snapshot StrategySnapshot, err error) {
+	b, err := r.rDb.Get(context.TODO(), r.getRedisFieldNameVerificationCodeStrategy()).Bytes()
+	if err == redis.Nil {
+		return false, snapshot, nil
+	}
+	if err != nil {
+		return false, snapshot, err
+	}
+	if err = json.Unmarshal(b, &snapshot); err != nil {
+		return false, snapshot, errors.New("invalid persisted strategy: " + err.Error())
+	}
+	return true, snapshot, nil
+}
+
+// 使用持久化于redis中的策略替换当前策略, 不存在持久化的策略时保持不变
+func (r *VerificationCodeRdb) reloadStrategy() (loaded bool, err error) {
+	exist, snapshot, err := r.queryPersistedStrategy()
+	if err != nil || !exist {
+		return false, err
+	}
+
+	s, err := snapshot.ToStrategy()
+	if err != nil {
+		return false, err
+	}
+	r.strategy = *s
+	return true, nil
+}
+
+// 记录管理员操作(未开启历史记录时不做任何处理)
+func (r VerificationCodeRdb) historyAdmin(objName string, operation string, detail map[string]string) {
+	d := map[string]string{"operation": operation}
+	for k, v := range detail {
+		d[k] = v
+	}
+	r.appendHistory(HistoryRecord{Action: HistoryActionAdmin, ObjName: objName, Detail: d})
+}
+
+// 生成持久化业务模块策略的字段名称
+func (r VerificationCodeRdb) getRedisFieldNameVerificationCodeStrategy() string {
+	return r.ModuleName + "VerificationCodeStrategy"
+}
@@ -0,0 +1,431 @@
+package verification_code_rdb
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// VerificationCodeBackupInterface 导出及导入业务模块的状态
+type VerificationCodeBackupInterface interface {
+	ExportState(w io.Writer, opt ExportOptions) (ExportReport, error)
+	ImportState(rd io.Reader, opt ImportOptions) (ImportReport, error)
+}
+
+const (
+	defaultExportScanCount = 100      // 导出时单次SCAN的COUNT
+	maxImportLineBytes     = 64 << 20 // 导入时单行的最大字节数
+	hashedCodePrefix       = "sha256:"
+	stateEncodingBase64    = "base64"
+)
+
+// CodeExportMode 导出时对验证码的处理方式
+type CodeExportMode string
+
+const (
+	CodeExportModeInclude CodeExportMode = "include" // 原样导出验证码(默认)
+	CodeExportModeExclude CodeExportMode = "exclude" // 不导出验证码、未核销的验证码集合及验证码的累计延长时长
+	CodeExportModeHash    CodeExportMode = "hash"    // 以SHA-256摘要代替验证码导出. 恢复后请求间隔、未核销数量等状态保持不变, 但验证码无法再被核销
+)
+
+// 按字段名称(去除业务模块名称及"VerificationCode"后)的前缀区分字段的种类. 前缀存在包含关系时较长的在前
+var stateKeyKinds = []string{
+	"StatisticsSubjects", "Statistics", "StrategyOverride", "Strategy", "OverrideIndex",
+	"TrustedDevice", "DeviceIndex", "BudgetDaily", "BudgetHourly", "ResendCount", "TicketUsed",
+	"ErrorCount", "LastErrorTime", "Extension", "HistoryIndex", "History", "Flow", "Webhook", "Set",
+}
+
+// ExportOptions 导出的选项
+type ExportOptions struct {
+	CodeMode  CodeExportMode // 对验证码的处理方式, 为空时原样导出
+	ScanCount int64          // 单次SCAN的COUNT, 小于等于0时使用默认值(100)
+}
+
+// ImportOptions 导入的选项
+type ImportOptions struct {
+	Overwrite bool // 字段已存在时是否覆盖, 为false时跳过已存在的字段
+}
+
+// StateHeader 导出文件的首行
+type StateHeader struct {
+	ModuleName string         `json:"module_name"` // 导出的业务模块名称
+	ExportedAt time.Time      `json:"exported_at"` // 导出的时间, 导入时据此扣减剩余有效期
+	CodeMode   CodeExportMode `json:"code_mode"`   // 对验证码的处理方式
+}
+
+// StateRecord 导出文件中的单个字段(首行之后每行一个)
+type StateRecord struct {
+	Key      string          `json:"key"`                // 字段名称(不含业务模块名称), 导入时拼接目标业务模块的名称
+	Type     string          `json:"type"`               // 字段类型: string/hash/set/zset/list/stream
+	TTL      int64           `json:"ttl_ms"`             // 导出时的剩余有效期(毫秒), -1表示永不过期
+	Value    json.RawMessage `json:"value"`              // 字段的值
+	Encoding string          `json:"encoding,omitempty"` // 值的编码, 非UTF-8的字符串(例如HyperLogLog)为base64
+}
+
+// ExportReport 导出的结果
+type ExportReport struct {
+	Keys     int // 导出的字段数量
+	Excluded int // 按CodeExportModeExclude跳过的字段数量
+}
+
+// ImportReport 导入的结果
+type ImportReport struct {
+	Restored int // 恢复的字段数量
+	Expired  int // 扣减导出至今的时长后已过期而跳过的字段数量
+	Skipped  int // 已存在而跳过的字段数量
+}
+
+// 有序集合的成员
+type stateZMember struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// 流的消息
+type stateStreamMessage struct {
+	Id     string                 `json:"id"`
+	Values map[string]interface{} `json:"values"`
+}
+
+// 使用SCAN遍历业务模块的全部字段, 以JSON lines格式写入w. 遍历期间字段仍可能被修改, 导出的结果并非同一时刻的快照
+func (r VerificationCodeRdb) exportState(w io.Writer, opt ExportOptions) (report ExportReport, err error) {
+	if r.isDegraded() {
+		return report, ErrRedisUnavailable
+	}
+	switch opt.CodeMode {
+	case "":
+		opt.CodeMode = CodeExportModeInclude
+	case CodeExportModeInclude, CodeExportModeExclude, CodeExportModeHash:
+	default:
+		return report, errors.New("unknown code export mode: " + string(opt.CodeMode))
+	}
+	if opt.ScanCount <= 0 {
+		opt.ScanCount = defaultExportScanCount
+	}
+
+	enc := json.NewEncoder(w)
+	if err = enc.Encode(StateHeader{ModuleName: r.ModuleName, ExportedAt: r.now(), CodeMode: opt.CodeMode}); err != nil {
+		return report, err
+	}
+
+	ctx := context.TODO()
+	pattern := escapeScanPattern(r.ModuleName+"VerificationCode") + "*"
+	seen := map[string]bool{}
+	var cursor uint64
+	for {
+		var keys []string
+		if keys, cursor, err = r.rDb.Scan(ctx, cursor, pattern, opt.ScanCount).Result(); err != nil {
+			return report, err
+		}
+		for _, key := range keys {
+			// SCAN可能重复返回同一字段
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			rec, ok, excluded, err := r.exportKey(ctx, key, opt.CodeMode)
+			if err != nil {
+				return report, err
+			}
+			if excluded {
+				report.Excluded++
+			}
+			if !ok {
+				continue
+			}
+			if err = enc.Encode(rec); err != nil {
+				return report, err
+			}
+			report.Keys++
+		}
+		if cursor == 0 {
+			return report, nil
+		}
+	}
+}
+
+// 导出单个字段. ok为false时该字段已不存在或被排除, excluded为true时该字段按CodeExportModeExclude被排除
+func (r VerificationCodeRdb) exportKey(ctx context.Context, key string, mode CodeExportMode) (rec StateRecord, ok bool, excluded bool, err error) {
+	typ, err := r.rDb.Type(ctx, key).Result()
+	if err != nil || typ == "none" {
+		return rec, false, false, err
+	}
+	kind := stateKeyKind(strings.TrimPrefix(key, r.ModuleName+"VerificationCode"), typ)
+	if mode == CodeExportModeExclude && (kind == "" || kind == "Set" || kind == "Extension") {
+		return rec, false, true, nil
+	}
+
+	var value interface{}
+	switch typ {
+	case "string":
+		v, err := r.rDb.Get(ctx, key).Result()
+		if err != nil {
+			return rec, false, false, ignoreNil(err)
+		}
+		if kind == "" && mode == CodeExportModeHash {
+			v = hashStoredCode(v)
+		}
+		if !utf8.ValidString(v) {
+			v = base64.StdEncoding.EncodeToString([]byte(v))
+			rec.Encoding = stateEncodingBase64
+		}
+		value = v
+	case "hash":
+		v, err := r.rDb.HGetAll(ctx, key).Result()
+		if err != nil || len(v) == 0 {
+			return rec, false, false, err
+		}
+		if kind == "Extension" && mode == CodeExportModeHash {
+			v["code"] = hashStoredCode(v["code"])
+		}
+		value = v
+	case "set":
+		v, err := r.rDb.SMembers(ctx, key).Result()
+		if err != nil || len(v) == 0 {
+			return rec, false, false, err
+		}
+		if kind == "Set" && mode == CodeExportModeHash {
+			for i := range v {
+				v[i] = hashStoredCode(v[i])
+			}
+		}
+		value = v
+	case "zset":
+		v, err := r.rDb.ZRangeWithScores(ctx, key, 0, -1).Result()
+		if err != nil || len(v) == 0 {
+			return rec, false, false, err
+		}
+		members := make([]stateZMember, len(v))
+		for i, z := range v {
+			members[i] = stateZMember{Member: z.Member.(string), Score: z.Score}
+		}
+		value = members
+	case "list":
+		v, err := r.rDb.LRange(ctx, key, 0, -1).Result()
+		if err != nil || len(v) == 0 {
+			return rec, false, false, err
+		}
+		value = v
+	case "stream":
+		v, err := r.rDb.XRange(ctx, key, "-", "+").Result()
+		if err != nil || len(v) == 0 {
+			return rec, false, false, err
+		}
+		messages := make([]stateStreamMessage, len(v))
+		for i, m := range v {
+			messages[i] = stateStreamMessage{Id: m.ID, Values: m.Values}
+		}
+		value = messages
+	default:
+		return rec, false, false, nil
+	}
+
+	ttl, err := r.rDb.PTTL(ctx, key).Result()
+	if err != nil {
+		return rec, false, false, err
+	}
+	if ttl == -2 {
+		// 读取期间已过期
+		return rec, false, false, nil
+	}
+	if rec.Value, err = json.Marshal(value); err != nil {
+		return rec, false, false, err
+	}
+	rec.Key = strings.TrimPrefix(key, r.ModuleName)
+	rec.Type = typ
+	rec.TTL = -1
+	if ttl > 0 {
+		rec.TTL = ttl.Milliseconds()
+	}
+	return rec, true, false, nil
+}
+
+// 从exportState导出的内容恢复字段. 剩余有效期扣减导出至今的时长, 已过期的字段不再恢复
+func (r VerificationCodeRdb) importState(rd io.Reader, opt ImportOptions) (report ImportReport, err error) {
+	if r.isDegraded() {
+		return report, ErrRedisUnavailable
+	}
+
+	scanner := bufio.NewScanner(rd)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxImportLineBytes)
+	if !scanner.Scan() {
+		if err = scanner.Err(); err == nil {
+			err = errors.New("missing header")
+		}
+		return report, err
+	}
+	var header StateHeader
+	if err = json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return report, err
+	}
+	elapsed := r.now().Sub(header.ExportedAt).Milliseconds()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	ctx := context.TODO()
+	for scanner.Scan() {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var rec StateRecord
+		if err = json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return report, err
+		}
+
+		ttl := rec.TTL
+		if ttl >= 0 {
+			if ttl -= elapsed; ttl <= 0 {
+				report.Expired++
+				continue
+			}
+		}
+
+		key := r.ModuleName + rec.Key
+		if !opt.Overwrite {
+			n, err := r.rDb.Exists(ctx, key).Result()
+			if err != nil {
+				return report, err
+			}
+			if n > 0 {
+				report.Skipped++
+				continue
+			}
+		}
+		if err = r.importRecord(ctx, key, rec, ttl); err != nil {
+			return report, err
+		}
+		report.Restored++
+	}
+	return report, scanner.Err()
+}
+
+// 原子地写入单个字段. ttl: 剩余有效期(毫秒), 小于0时永不过期
+func (r VerificationCodeRdb) importRecord(ctx context.Context, key string, rec StateRecord, ttl int64) error {
+	var write func(pipe redis.Pipeliner)
+	switch rec.Type {
+	case "string":
+		var v string
+		if err := json.Unmarshal(rec.Value, &v); err != nil {
+			return err
+		}
+		if rec.Encoding == stateEncodingBase64 {
+			b, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				return err
+			}
+			v = string(b)
+		}
+		write = func(pipe redis.Pipeliner) { pipe.Set(ctx, key, v, 0) }
+	case "hash":
+		var v map[string]string
+		if err := json.Unmarshal(rec.Value, &v); err != nil {
+			return err
+		}
+		write = func(pipe redis.Pipeliner) { pipe.HSet(ctx, key, v) }
+	case "set":
+		var v []string
+		if err := json.Unmarshal(rec.Value, &v); err != nil {
+			return err
+		}
+		write = func(pipe redis.Pipeliner) {
+			members := make([]interface{}, len(v))
+			for i, m := range v {
+				members[i] = m
+			}
+			pipe.SAdd(ctx, key, members...)
+		}
+	case "zset":
+		var v []stateZMember
+		if err := json.Unmarshal(rec.Value, &v); err != nil {
+			return err
+		}
+		write = func(pipe redis.Pipeliner) {
+			members := make([]*redis.Z, len(v))
+			for i, z := range v {
+				members[i] = &redis.Z{Score: z.Score, Member: z.Member}
+			}
+			pipe.ZAdd(ctx, key, members...)
+		}
+	case "list":
+		var v []string
+		if err := json.Unmarshal(rec.Value, &v); err != nil {
+			return err
+		}
+		write = func(pipe redis.Pipeliner) {
+			values := make([]interface{}, len(v))
+			for i, e := range v {
+				values[i] = e
+			}
+			pipe.RPush(ctx, key, values...)
+		}
+	case "stream":
+		var v []stateStreamMessage
+		if err := json.Unmarshal(rec.Value, &v); err != nil {
+			return err
+		}
+		write = func(pipe redis.Pipeliner) {
+			for _, m := range v {
+				pipe.XAdd(ctx, &redis.XAddArgs{Stream: key, ID: m.Id, Values: m.Values})
+			}
+		}
+	default:
+		return errors.New("unknown key type: " + rec.Type)
+	}
+
+	_, err := r.rDb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		write(pipe)
+		if ttl > 0 {
+			pipe.PExpire(ctx, key, time.Duration(ttl)*time.Millisecond)
+		}
+		return nil
+	})
+	return err
+}
+
+// 字段的种类. 不属于任何已知种类的字符串即为验证码, 此时返回空字符串
+func stateKeyKind(suffix string, typ string) string {
+	for _, kind := range stateKeyKinds {
+		if strings.HasPrefix(suffix, kind) {
+			// 对象名称恰好以种类名称开头时, 借助字段类型区分
+			if (kind == "Set" && typ != "set") || (kind == "Extension" && typ != "hash") {
+				continue
+			}
+			return kind
+		}
+	}
+	if typ == "string" {
+		return ""
+	}
+	return "Unknown"
+}
+
+// 以摘要代替存储的验证码
+func hashStoredCode(stored string) string {
+	if stored == "" || strings.HasPrefix(stored, hashedCodePrefix) {
+		return stored
+	}
+	return hashedCodePrefix + payloadDigest([]byte(stored))
+}
+
+// 转义SCAN的MATCH模式中的特殊字符
+func escapeScanPattern(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`).Replace(s)
+}
+
+// 字段已不存在时不视为错误
+func ignoreNil(err error) error {
+	if err == redis.Nil {
+		return nil
+	}
+	return err
+}
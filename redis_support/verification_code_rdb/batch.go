@@ -0,0 +1,225 @@
+package verification_code_rdb
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+// VerificationCodeBatchInterface 批量校验及状态查询
+type VerificationCodeBatchInterface interface {
+	BatchPreCheckBeforeSendVerificationCode(objNames []string) []BatchPreCheckResult
+	BatchPreCheckBeforeVerifyAndUseVerificationCode(objNames []string) []BatchPreCheckResult
+	BatchQueryVerificationStatus(objNames []string) ([]VerificationStatus, error)
+	QueryVerificationStatus(objName string) (VerificationStatus, error)
+}
+
+// VerificationStatus 对象当前的验证码状态, 即各Query*方法的查询结果
+type VerificationStatus struct {
+	ObjName            string    // 对象名称
+	CodeExist          bool      // 是否存在未核销且未过期的验证码
+	CodeTTL            int64     // 验证码剩余的有效时长(秒), 同QueryVerificationCodeTTL
+	RegisteredPeriod   int64     // 验证码已等待核销的时长(秒), 验证码不存在时无意义. 同QueryVerificationCodeRegisteredPeriod
+	UnusedCodeCount    int       // 当日未核销的验证码数量, 同QueryCountOfUnusedVerificationCode
+	ErrorsCountToday   int       // 当日验证错误的次数, 同QueryErrorsCountToday
+	LastErrorTimeExist bool      // 当日是否存在验证错误的记录
+	LastErrorTime      time.Time // 当日最后一次验证错误的时间, 同QueryLastErrorTime
+	ResendCountToday   int       // 当日复用已有验证码的重发次数, 同QueryResendCountToday
+	Err                error     // 查询该对象时发生的错误. 不为nil时其余字段无意义
+}
+
+// BatchPreCheckResult 批量校验中单个对象的校验结果
+type BatchPreCheckResult struct {
+	ObjName     string      // 对象名称
+	InvalidType InvalidType // 校验结果, 同PreCheckBeforeSendVerificationCode/PreCheckBeforeVerifyAndUseVerificationCode
+	Err         error       // 校验该对象时发生的错误. 不为nil时InvalidType无意义
+}
+
+// 单个对象在管道中的查询命令
+type verificationStatusCmds struct {
+	code      *redis.StringCmd
+	ttl       *redis.DurationCmd
+	unusedCnt *redis.IntCmd
+	errorCnt  *redis.StringCmd
+	lastErrTm *redis.StringCmd
+	resendCnt *redis.StringCmd
+}
+
+// 批量查询对象的验证码状态(通过管道在一次往返中完成全部查询)
+func (r VerificationCodeRdb) batchQueryVerificationStatus(objNames []string) ([]VerificationStatus, error) {
+	if len(objNames) == 0 {
+		return nil, nil
+	}
+
+	// 规范化对象名称, 规范化失败的对象不参与查询
+	normalized := make([]string, len(objNames))
+	normalizeErrs := make([]error, len(objNames))
+	for i, objName := range objNames {
+		normalized[i], normalizeErrs[i] = r.normalizeSubject(objName)
+	}
+
+	cmds := make([]verificationStatusCmds, len(objNames))
+	_, err := r.rDb.Pipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+		for i, objName := range normalized {
+			if normalizeErrs[i] != nil {
+				continue
+			}
+			cmds[i] = verificationStatusCmds{
+				code:      pipe.Get(context.TODO(), r.getRedisFieldNameVerificationCode(objName)),
+				ttl:       pipe.TTL(context.TODO(), r.getRedisFieldNameVerificationCode(objName)),
+				unusedCnt: pipe.SCard(context.TODO(), r.getRedisFieldNameVerificationCodeSet(objName)),
+				errorCnt:  pipe.Get(context.TODO(), r.getRedisFieldNameVerificationCodeErrorCount(objName)),
+				lastErrTm: pipe.Get(context.TODO(), r.getRedisFieldNameVerificationCodeLastFailedTime(objName)),
+				resendCnt: pipe.Get(context.TODO(), r.getRedisFieldNameVerificationCodeResendCount(objName)),
+			}
+		}
+		return nil
+	})
+	// 管道整体执行失败(例如redis不可用)
+	if err != nil && err != redis.Nil && allCmdsFailed(cmds, err) {
+		return nil, err
+	}
+
+	res := make([]VerificationStatus, len(objNames))
+	for i, objName := range objNames {
+		if normalizeErrs[i] != nil {
+			res[i] = VerificationStatus{ObjName: objName, Err: normalizeErrs[i]}
+			continue
+		}
+		res[i] = r.parseVerificationStatus(objName, cmds[i])
+	}
+	return res, nil
+}
+
+// 解析单个对象的查询结果. 键不存在(redis.Nil)视为无记录, 其余错误记录于Err中
+func (r VerificationCodeRdb) parseVerificationStatus(objName string, cmds verificationStatusCmds) VerificationStatus {
+	st := VerificationStatus{ObjName: objName}
+
+	if err := cmds.code.Err(); err != nil && err != redis.Nil {
+		st.Err = err
+		return st
+	}
+	st.CodeExist = cmds.code.Err() == nil
+
+	ttl, err := cmds.ttl.Result()
+	if err != nil && err != redis.Nil {
+		st.Err = err
+		return st
+	}
+	st.CodeTTL = int64(ttl.Seconds())
+	st.RegisteredPeriod = r.strategy.ValidityDuration - st.CodeTTL
+
+	unusedCnt, err := cmds.unusedCnt.Result()
+	if err != nil && err != redis.Nil {
+		st.Err = err
+		return st
+	}
+	st.UnusedCodeCount = int(unusedCnt)
+
+	if st.ErrorsCountToday, err = cmds.errorCnt.Int(); err != nil && err != redis.Nil {
+		st.Err = err
+		return st
+	}
+
+	lastErrTm, err := cmds.lastErrTm.Int64()
+	if err != nil && err != redis.Nil {
+		st.Err = err
+		return st
+	}
+	st.LastErrorTimeExist = err == nil
+	if st.LastErrorTimeExist {
+		st.LastErrorTime = time.Unix(lastErrTm, 0)
+	}
+
+	if st.ResendCountToday, err = cmds.resendCnt.Int(); err != nil && err != redis.Nil {
+		st.Err = err
+	}
+	return st
+}
+
+// 批量校验对象当前状态是否合法. 校验规则与单个对象的校验一致, 但仅查询状态, 不计入统计数据
+func (r VerificationCodeRdb) batchPreCheck(op DegradableOperation, objNames []string) []BatchPreCheckResult {
+	res := make([]BatchPreCheckResult, len(objNames))
+
+	statusList, err := r.batchQueryVerificationStatus(objNames)
+	degraded := r.isDegraded() || r.shouldDegrade(err)
+	overrides := r.batchQueryStrategyOverrides(objNames, degraded || err != nil)
+	for i, objName := range objNames {
+		res[i].ObjName = objName
+
+		normalized, er := r.normalizeSubject(objName)
+		switch {
+		case er != nil:
+			res[i].InvalidType, res[i].Err = InvalidTypeInvalidSubject, er
+		case degraded:
+			res[i].InvalidType, res[i].Err = r.degradedPeekPreCheck(op, normalized)
+		case err != nil:
+			res[i].Err = err
+		case statusList[i].Err != nil:
+			res[i].Err = statusList[i].Err
+		default:
+			sr := r
+			if o, ok := overrides[normalized]; ok {
+				sr.strategy = o.Apply(r.strategy)
+			}
+			res[i].InvalidType, res[i].Err = sr.judgePreCheck(op, normalized, statusList[i])
+		}
+	}
+	return res
+}
+
+// 批量查询对象的策略覆盖项(对象名称 -> 覆盖项). 未开启按对象覆盖策略、skip为true或查询失败时返回nil
+func (r VerificationCodeRdb) batchQueryStrategyOverrides(objNames []string, skip bool) map[string]StrategyOverride {
+	if !r.strategyOverride || skip {
+		return nil
+	}
+
+	normalized := make([]string, 0, len(objNames))
+	for _, objName := range objNames {
+		if n, err := r.normalizeSubject(objName); err == nil {
+			normalized = append(normalized, n)
+		}
+	}
+	list, err := r.queryStrategyOverrides(normalized)
+	if err != nil {
+		return nil
+	}
+
+	res := make(map[string]StrategyOverride, len(list))
+	for _, item := range list {
+		res[item.ObjName] = item.Override
+	}
+	return res
+}
+
+// 根据对象的验证码状态按顺序执行规则链. 内置规则直接基于已查询的状态判断, 自定义规则逐个执行
+func (r VerificationCodeRdb) judgePreCheck(op DegradableOperation, objName string, st VerificationStatus) (InvalidType, error) {
+	for _, rule := range r.rules.rulesOf(op) {
+		var it InvalidType
+		var err error
+		switch rule.(type) {
+		case RequestTooFrequentlyRule:
+			it = invalidTypeIf(judgeIsRequestTooFrequently(st.CodeTTL, r.strategy.ValidityDuration, r.strategy.RequestTimeIntervalThreshold), InvalidTypeRequestTooFrequently)
+		case VerifyFailTooFrequentlyRule:
+			it = invalidTypeIf(judgeIsVerifyFailTooFrequently(st.ErrorsCountToday, r.strategy.DenyThresholdOfFailedCount, st.LastErrorTimeExist, st.LastErrorTime, r.now(), r.strategy.TemporarilyBanStrategy), InvalidTypeVerifyFailTooFrequently)
+		case UnusedCodeTooManyRule:
+			it = invalidTypeIf(judgeIsUnusedCodeTooMany(st.UnusedCodeCount, r.strategy.DenyThresholdOfUnusedCode, st.CodeExist), InvalidTypeUnusedCodeTooMany)
+		default:
+			it, err = r.evaluateRule(rule, r.createRuleContext(op, objName))
+		}
+		if err != nil || it != UserIsValid {
+			return it, err
+		}
+	}
+	return UserIsValid, nil
+}
+
+// 判断管道中的命令是否全部因同一错误而失败
+func allCmdsFailed(cmds []verificationStatusCmds, err error) bool {
+	for _, c := range cmds {
+		if c.code != nil && c.code.Err() != err {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,203 @@
+package verification_code_rdb
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"time"
+)
+
+// VerificationCodeBudgetInterface 发送预算的查询
+type VerificationCodeBudgetInterface interface {
+	QueryBudgetUsage() (BudgetUsage, error)
+}
+
+const (
+	defaultBudgetSoftLimitRatio = 0.8 // 默认的软限制比例
+
+	budgetFieldMessages = "messages" // 已发送的短信数量
+	budgetFieldCost     = "cost"     // 已产生的预估费用
+)
+
+const (
+	EventTypeBudgetSoftLimit EventType = "budget_soft_limit" // 业务模块的用量首次达到软限制
+	EventTypeBudgetExhausted EventType = "budget_exhausted"  // 业务模块的用量已达上限, 拒绝发送
+)
+
+// BudgetStrategy 业务模块的发送预算(全部对象共享), 用于防御分散至大量号码的短信轰炸. 各项上限为0时不做该项限制
+// redis不可用时无法占用预算, 发送前的校验不受DegradationPolicyFailOpen影响, 始终拒绝
+type BudgetStrategy struct {
+	DailyMaxMessages  int64   // 每日短信数量上限
+	DailyMaxCost      int64   // 每日预估费用上限, 单位与CostPerMessage一致
+	HourlyMaxMessages int64   // 每小时短信数量上限
+	HourlyMaxCost     int64   // 每小时预估费用上限, 单位与CostPerMessage一致
+	CostPerMessage    int64   // 单条短信的预估费用(例如以厘为单位)
+	SoftLimitRatio    float64 // 软限制比例, 用量首次达到上限的该比例时触发EventTypeBudgetSoftLimit事件. 小于等于0或大于等于1时使用默认值(0.8)
+}
+
+// BudgetUsage 业务模块当前的预算用量
+type BudgetUsage struct {
+	DailyMessages  int64 // 当日已发送的短信数量
+	DailyCost      int64 // 当日已产生的预估费用
+	HourlyMessages int64 // 当前小时已发送的短信数量
+	HourlyCost     int64 // 当前小时已产生的预估费用
+}
+
+// 原子地校验并占用预算. 任一上限将被突破时不占用预算, 返回 {0, 用量...}; 否则占用预算并返回 {1, 占用后的用量...}
+// KEYS: 当日用量, 当前小时用量
+// ARGV: 单条费用, 每日数量上限, 每日费用上限, 每小时数量上限, 每小时费用上限, 当日用量的过期时间, 当前小时用量的过期时间
+var budgetReserveScript = redis.NewScript(`
+local cost = tonumber(ARGV[1])
+local limits = {{tonumber(ARGV[2]), tonumber(ARGV[3])}, {tonumber(ARGV[4]), tonumber(ARGV[5])}}
+local usage = {}
+local allowed = 1
+for i = 1, 2 do
+	local msgs = tonumber(redis.call('HGET', KEYS[i], 'messages') or '0')
+	local spent = tonumber(redis.call('HGET', KEYS[i], 'cost') or '0')
+	if (limits[i][1] > 0 and msgs + 1 > limits[i][1]) or (limits[i][2] > 0 and spent + cost > limits[i][2]) then
+		allowed = 0
+	end
+	usage[i] = {msgs, spent}
+end
+if allowed == 1 then
+	for i = 1, 2 do
+		usage[i][1] = redis.call('HINCRBY', KEYS[i], 'messages', 1)
+		usage[i][2] = redis.call('HINCRBY', KEYS[i], 'cost', cost)
+		redis.call('EXPIREAT', KEYS[i], ARGV[5 + i])
+	end
+end
+return {allowed, usage[1][1], usage[1][2], usage[2][1], usage[2][2]}
+`)
+
+// 软限制比例
+func (s BudgetStrategy) softLimitRatio() float64 {
+	if s.SoftLimitRatio <= 0 || s.SoftLimitRatio >= 1 {
+		return defaultBudgetSoftLimitRatio
+	}
+	return s.SoftLimitRatio
+}
+
+// 校验并占用一条短信的预算. 未配置预算时始终放行
+// 预算在发送前的校验通过时即被占用, 因此发送失败的短信同样计入用量
+func (r VerificationCodeRdb) reserveBudget() (allowed bool, err error) {
+	if r.budget == nil {
+		return true, nil
+	}
+
+	now := r.now()
+	hour := now.Truncate(time.Hour)
+	b := r.budget
+	res, err := budgetReserveScript.Run(context.TODO(), r.rDb,
+		[]string{r.getRedisFieldNameVerificationCodeBudgetDaily(now), r.getRedisFieldNameVerificationCodeBudgetHourly(now)},
+		b.CostPerMessage, b.DailyMaxMessages, b.DailyMaxCost, b.HourlyMaxMessages, b.HourlyMaxCost,
+		r.tomorrowZeroTime().Unix(), hour.Add(time.Hour).Unix(),
+	).Int64Slice()
+	if err != nil {
+		return false, err
+	}
+
+	usage := BudgetUsage{DailyMessages: res[1], DailyCost: res[2], HourlyMessages: res[3], HourlyCost: res[4]}
+	if res[0] == 0 {
+		r.emitEvent(EventTypeBudgetExhausted, "", formatBudgetUsage(usage))
+		return false, nil
+	}
+
+	if r.budget.crossedSoftLimit(usage) {
+		r.emitEvent(EventTypeBudgetSoftLimit, "", formatBudgetUsage(usage))
+	}
+	return true, nil
+}
+
+// 本次占用是否使某项用量首次达到软限制
+func (s BudgetStrategy) crossedSoftLimit(u BudgetUsage) bool {
+	ratio := s.softLimitRatio()
+	crossed := func(cur int64, step int64, max int64) bool {
+		if max <= 0 {
+			return false
+		}
+		soft := float64(max) * ratio
+		return float64(cur) >= soft && float64(cur-step) < soft
+	}
+	return crossed(u.DailyMessages, 1, s.DailyMaxMessages) || crossed(u.DailyCost, s.CostPerMessage, s.DailyMaxCost) ||
+		crossed(u.HourlyMessages, 1, s.HourlyMaxMessages) || crossed(u.HourlyCost, s.CostPerMessage, s.HourlyMaxCost)
+}
+
+// 用量是否已无法容纳下一条短信(当日, 当前小时)
+func (s BudgetStrategy) exhausted(u BudgetUsage) (daily bool, hourly bool) {
+	full := func(msgs int64, cost int64, maxMsgs int64, maxCost int64) bool {
+		return (maxMsgs > 0 && msgs+1 > maxMsgs) || (maxCost > 0 && cost+s.CostPerMessage > maxCost)
+	}
+	return full(u.DailyMessages, u.DailyCost, s.DailyMaxMessages, s.DailyMaxCost),
+		full(u.HourlyMessages, u.HourlyCost, s.HourlyMaxMessages, s.HourlyMaxCost)
+}
+
+// 查询业务模块当前的预算用量
+func (r VerificationCodeRdb) queryBudgetUsage() (BudgetUsage, error) {
+	now := r.now()
+	var daily, hourly *redis.SliceCmd
+	if _, err := r.rDb.Pipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+		daily = pipe.HMGet(context.TODO(), r.getRedisFieldNameVerificationCodeBudgetDaily(now), budgetFieldMessages, budgetFieldCost)
+		hourly = pipe.HMGet(context.TODO(), r.getRedisFieldNameVerificationCodeBudgetHourly(now), budgetFieldMessages, budgetFieldCost)
+		return nil
+	}); err != nil && err != redis.Nil {
+		return BudgetUsage{}, err
+	}
+
+	return BudgetUsage{
+		DailyMessages:  parseBudgetValue(daily.Val(), 0),
+		DailyCost:      parseBudgetValue(daily.Val(), 1),
+		HourlyMessages: parseBudgetValue(hourly.Val(), 0),
+		HourlyCost:     parseBudgetValue(hourly.Val(), 1),
+	}, nil
+}
+
+// 因预算耗尽被拒绝后, 距离预算恢复的剩余时长
+func (r VerificationCodeRdb) budgetRetryAfter() (time.Duration, error) {
+	if r.budget == nil {
+		return 0, nil
+	}
+
+	usage, err := r.queryBudgetUsage()
+	if err != nil {
+		return 0, err
+	}
+
+	now := r.now()
+	daily, hourly := r.budget.exhausted(usage)
+	switch {
+	case daily:
+		return r.tomorrowZeroTime().Sub(now), nil
+	case hourly:
+		return now.Truncate(time.Hour).Add(time.Hour).Sub(now), nil
+	default:
+		return 0, nil
+	}
+}
+
+func parseBudgetValue(vals []interface{}, i int) int64 {
+	if i >= len(vals) {
+		return 0
+	}
+	s, _ := vals[i].(string)
+	v, _ := strconv.ParseInt(s, 10, 64)
+	return v
+}
+
+func formatBudgetUsage(u BudgetUsage) map[string]string {
+	return map[string]string{
+		"daily_messages":  strconv.FormatInt(u.DailyMessages, 10),
+		"daily_cost":      strconv.FormatInt(u.DailyCost, 10),
+		"hourly_messages": strconv.FormatInt(u.HourlyMessages, 10),
+		"hourly_cost":     strconv.FormatInt(u.HourlyCost, 10),
+	}
+}
+
+// 生成存储业务模块当日预算用量的字段名称
+func (r VerificationCodeRdb) getRedisFieldNameVerificationCodeBudgetDaily(t time.Time) string {
+	return r.ModuleName + "VerificationCodeBudgetDaily" + t.Format("20060102")
+}
+
+// 生成存储业务模块当前小时预算用量的字段名称
+func (r VerificationCodeRdb) getRedisFieldNameVerificationCodeBudgetHourly(t time.Time) string {
+	return r.ModuleName + "VerificationCodeBudgetHourly" + t.Format("2006010215")
+}
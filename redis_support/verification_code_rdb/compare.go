@@ -0,0 +1,63 @@
+package verification_code_rdb
+
+import (
+	"crypto/subtle"
+	"strings"
+	"unicode"
+)
+
+// VerificationCodeComparisonInterface 验证码比对规则的查询及修改
+type VerificationCodeComparisonInterface interface {
+	QueryCodeComparisonStrategy() CodeComparisonStrategy
+	ModifyCodeComparisonStrategy(cs CodeComparisonStrategy)
+}
+
+// CodeComparisonStrategy 验证码比对策略. 比对前按配置对存储的验证码与用户输入分别进行规范化, 比对本身始终为恒定时间比较
+type CodeComparisonStrategy struct {
+	FoldWidth       bool `json:"fold_width"`       // 将全角字符转换为半角字符(例如中文输入法输入的全角数字)
+	StripSeparators bool `json:"strip_separators"` // 去除空白及分隔符(空格、连字符、下划线、点号等)
+	CaseInsensitive bool `json:"case_insensitive"` // 忽略大小写(适用于包含字母的验证码)
+}
+
+// Normalize 按比对策略对验证码进行规范化
+func (c CodeComparisonStrategy) Normalize(code string) string {
+	if c.FoldWidth {
+		code = strings.Map(foldWidth, code)
+	}
+
+	if c.StripSeparators {
+		code = strings.Map(func(r rune) rune {
+			if isSeparator(r) {
+				return -1
+			}
+			return r
+		}, code)
+	}
+
+	if c.CaseInsensitive {
+		code = strings.ToLower(code)
+	}
+	return code
+}
+
+// Equal 判断存储的验证码与用户输入是否一致(规范化后进行恒定时间比较)
+func (c CodeComparisonStrategy) Equal(stored string, input string) bool {
+	return subtle.ConstantTimeCompare([]byte(c.Normalize(stored)), []byte(c.Normalize(input))) == 1
+}
+
+// 全角字符转换为半角字符
+func foldWidth(r rune) rune {
+	switch {
+	case r >= 0xFF01 && r <= 0xFF5E: // 全角ASCII
+		return r - 0xFEE0
+	case r == 0x3000: // 全角空格
+		return ' '
+	default:
+		return r
+	}
+}
+
+// 是否为空白或分隔符
+func isSeparator(r rune) bool {
+	return unicode.IsSpace(r) || unicode.Is(unicode.Pd, r) || strings.ContainsRune("_.,·", r)
+}
@@ -0,0 +1,24 @@
+package verification_code_rdb
+
+import (
+	"github.com/DontBeProud/wow-easy-go/utils/wow_time"
+	"time"
+)
+
+// VerificationCodeRdbOptionalConfig VerificationCodeRdb的可选配置项
+type VerificationCodeRdbOptionalConfig struct {
+	DegradationStrategy *DegradationStrategy // redis不可用时的降级策略. 为nil时不进行降级, redis不可用时直接返回错误
+	EventHandler        EventHandler         // 事件回调, 例如redis可用状态的切换
+	RiskThresholds      []int                // 对象当日核销失败次数的风险阈值, 失败次数达到其中任一值时触发EventTypeSubjectRiskThreshold事件. 为空时不触发
+	StatisticsStrategy  *StatisticsStrategy  // 按日统计的配置. 为nil时不进行统计
+	Clock               wow_time.Clock       // 时钟, 用于生成按日存储的字段名称以及判断封禁是否到期. 为nil时使用系统时间. 注意redis中的过期时间仍基于redis服务端的时间
+	Location            *time.Location       // 划分自然日所用的时区, 决定按日存储的字段名称以及每日计数的重置时刻. 为nil时使用wow_time.GetDefaultLocation()
+	SubjectNormalizer   SubjectNormalizer    // 对象名称规范化接口, 在生成任何redis字段名称前调用. 为nil时不做规范化. 内置 PhoneNumberNormalizer / EmailNormalizer
+	BudgetStrategy      *BudgetStrategy      // 业务模块的发送预算(短信数量及预估费用), 在发送前的校验中原子地占用. 为nil时不限制
+	TicketStrategy      *TicketStrategy      // 核销成功后签发票据的配置. 为nil时不签发票据
+	DeviceTrustStrategy *DeviceTrustStrategy // 受信任设备("记住此设备")的配置. 为nil时不签发设备令牌
+	HistoryStrategy     *HistoryStrategy     // 历史记录的配置. 开启后下发、核销、拒绝、封禁及管理员操作均追加至业务模块的redis流中. 为nil时不记录
+	StrategyOverride    bool                 // 是否开启按对象覆盖策略(SetStrategyOverride). 开启后每次发送及核销前额外查询一次redis以合并对象的策略覆盖项
+	SendRules           []Rule               // 发送验证码前按顺序执行的规则链. 为nil时使用 DefaultSendRules()
+	VerifyRules         []Rule               // 核销验证码前按顺序执行的规则链. 为nil时使用 DefaultVerifyRules()
+}
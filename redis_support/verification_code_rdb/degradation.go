@@ -0,0 +1,386 @@
+package verification_code_rdb
+
+import (
+	"errors"
+	"github.com/DontBeProud/wow-easy-go/redis_support/base"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VerificationCodeDegradationInterface 降级模式的查询及后台资源的释放
+type VerificationCodeDegradationInterface interface {
+	IsDegraded() bool
+	Close()
+}
+
+// DegradationPolicy redis不可用时的降级策略
+type DegradationPolicy int
+
+const (
+	DegradationPolicyFailClosed DegradationPolicy = iota // 拒绝请求(默认)
+	DegradationPolicyFailOpen                            // 放行请求, 由本地内存限流器兜底. 验证码暂存于本地内存, redis恢复后迁移至redis. 配置了发送预算时发送前的校验不可放行
+	DegradationPolicyQueue                               // 写操作暂存于本地队列, redis恢复后按序重放. 校验类操作无法延后执行, 按FailClosed处理
+)
+
+// DegradableOperation 受降级策略控制的操作
+type DegradableOperation int
+
+const (
+	OperationPreCheckBeforeSend   DegradableOperation = iota + 1 // PreCheckBeforeSendVerificationCode
+	OperationSetAndRegister                                      // SetAndRegisterVerificationCode
+	OperationPreCheckBeforeVerify                                // PreCheckBeforeVerifyAndUseVerificationCode
+	OperationVerifyAndUse                                        // VerifyAndUseVerificationCode
+)
+
+const (
+	defaultLocalLimiterThreshold  = 3                // 本地限流器默认在单个窗口内允许单个对象通过的请求次数
+	defaultLocalLimiterWindow     = 10 * time.Minute // 本地限流器默认的窗口时长
+	defaultQueueCapacity          = 1000             // 本地队列默认的容量
+	defaultLocalCodeFailThreshold = 5                // 策略未限制单日验证错误次数时, 本地暂存的验证码允许的失败次数
+)
+
+// ErrRedisUnavailable redis不可用且降级策略为拒绝请求
+var ErrRedisUnavailable = errors.New("redis is unavailable")
+
+// DegradationStrategy redis不可用时的降级策略配置
+type DegradationStrategy struct {
+	Policies              map[DegradableOperation]DegradationPolicy // 各操作的降级策略, 未配置的操作按FailClosed处理
+	ProbeInterval         time.Duration                             // 健康探测间隔, 小于等于0时使用 base.DefaultHealthProbeInterval
+	LocalLimiterThreshold int                                       // FailOpen模式下, 本地限流器在单个窗口内允许单个对象通过的请求次数. 小于等于0时使用默认值
+	LocalLimiterWindow    time.Duration                             // FailOpen模式下, 本地限流器的窗口时长. 小于等于0时使用默认值
+	QueueCapacity         int                                       // Queue模式下, 本地队列的容量, 队列已满时按FailClosed处理. 小于等于0时使用默认值
+}
+
+// 查询操作对应的降级策略
+func (s DegradationStrategy) policyOf(op DegradableOperation) DegradationPolicy {
+	if p, ok := s.Policies[op]; ok {
+		return p
+	}
+	return DegradationPolicyFailClosed
+}
+
+// 降级控制器, 持有健康探针及降级模式下的本地状态
+type degradationController struct {
+	strategy   DegradationStrategy
+	probe      *base.HealthProbe
+	limiter    *localLimiter
+	localCodes *localCodeStore
+	queueMutex sync.Mutex
+	queue      []func() error
+}
+
+// 创建降级控制器并启动健康探针. redis可用状态发生切换时触发事件, 恢复可用时迁移本地验证码并重放队列中的写操作
+func (r VerificationCodeRdb) createDegradationController(strategy DegradationStrategy) (*degradationController, error) {
+	if strategy.LocalLimiterThreshold <= 0 {
+		strategy.LocalLimiterThreshold = defaultLocalLimiterThreshold
+	}
+	if strategy.LocalLimiterWindow <= 0 {
+		strategy.LocalLimiterWindow = defaultLocalLimiterWindow
+	}
+	if strategy.QueueCapacity <= 0 {
+		strategy.QueueCapacity = defaultQueueCapacity
+	}
+
+	dc := &degradationController{
+		strategy:   strategy,
+		limiter:    createLocalLimiter(strategy.LocalLimiterThreshold, strategy.LocalLimiterWindow),
+		localCodes: createLocalCodeStore(),
+	}
+
+	probe, err := base.CreateHealthProbe(r.rDb, strategy.ProbeInterval, func(healthy bool, err error) {
+		if !healthy {
+			detail := map[string]string{}
+			if err != nil {
+				detail["error"] = err.Error()
+			}
+			r.emitEvent(EventTypeRedisUnavailable, "", detail)
+			return
+		}
+		r.emitEvent(EventTypeRedisRecovered, "", nil)
+		r.recoverFromDegradation(dc)
+	})
+	if err != nil {
+		return nil, err
+	}
+	dc.probe = probe
+	return dc, nil
+}
+
+// 当前是否处于降级模式
+func (r VerificationCodeRdb) isDegraded() bool {
+	return r.degradation != nil && !r.degradation.probe.IsHealthy()
+}
+
+// 操作返回的错误是否应触发降级. 若是, 则立即异步探测一次以尽快切换模式
+func (r VerificationCodeRdb) shouldDegrade(err error) bool {
+	if r.degradation == nil || !base.IsUnavailableError(err) {
+		return false
+	}
+	go func() { _, _ = r.degradation.probe.Probe() }()
+	return true
+}
+
+// 降级模式下的校验(发送前/核销前). FailOpen模式下各操作分别由本地限流器计数
+// 配置了发送预算时, 发送前的校验始终按FailClosed处理: 本地限流器仅能限制单个对象, 无法代替业务模块的预算防御分散至大量号码的短信轰炸
+func (r VerificationCodeRdb) degradedPreCheck(op DegradableOperation, objName string) (InvalidType, error) {
+	return r.degradedCheck(op, objName, r.degradation.limiter.allow)
+}
+
+// 降级模式下仅查询状态的校验(批量校验), 不占用本地限流器的计数
+func (r VerificationCodeRdb) degradedPeekPreCheck(op DegradableOperation, objName string) (InvalidType, error) {
+	return r.degradedCheck(op, objName, r.degradation.limiter.peek)
+}
+
+func (r VerificationCodeRdb) degradedCheck(op DegradableOperation, objName string, allow func(key string, now time.Time) bool) (InvalidType, error) {
+	if r.degradation.strategy.policyOf(op) != DegradationPolicyFailOpen || (op == OperationPreCheckBeforeSend && r.budget != nil) {
+		return InvalidTypeServiceUnavailable, ErrRedisUnavailable
+	}
+
+	if !allow(strconv.Itoa(int(op))+":"+r.ModuleName+":"+objName, r.now()) {
+		return InvalidTypeRequestTooFrequently, nil
+	}
+	return UserIsValid, nil
+}
+
+// 降级模式下添加并记录验证码
+func (r VerificationCodeRdb) degradedSetAndRegister(objName string, verCode string, expireNanoDuration time.Duration) error {
+	dc := r.degradation
+	switch dc.strategy.policyOf(OperationSetAndRegister) {
+	case DegradationPolicyFailOpen:
+		dc.localCodes.set(r.ModuleName, objName, verCode, r.now().Add(expireNanoDuration))
+		return nil
+	case DegradationPolicyQueue:
+		expireAt := r.now().Add(expireNanoDuration)
+		return dc.enqueue(func() error {
+			// 重放时扣除排队期间流逝的有效期
+			if remain := expireAt.Sub(r.now()); remain > 0 {
+				return r.registerVerificationCode(objName, verCode, remain)
+			}
+			return nil
+		})
+	default:
+		return ErrRedisUnavailable
+	}
+}
+
+// 降级模式下核销验证码(仅FailOpen模式下可核销暂存于本地内存的验证码)
+func (r VerificationCodeRdb) degradedVerifyAndUse(objName string, verCode string) (exist bool, success bool, err error) {
+	if r.degradation.strategy.policyOf(OperationVerifyAndUse) != DegradationPolicyFailOpen {
+		return false, false, ErrRedisUnavailable
+	}
+
+	failThreshold := r.strategy.DenyThresholdOfFailedCount
+	if failThreshold <= 0 {
+		failThreshold = defaultLocalCodeFailThreshold
+	}
+	exist, success = r.degradation.localCodes.verifyAndUse(r.ModuleName, objName, verCode, r.now(), r.strategy.CodeComparisonStrategy, failThreshold)
+	return exist, success, nil
+}
+
+// 降级模式下核销验证码. 本地暂存的验证码均未绑定交易载荷, 绑定交易载荷及需签发设备令牌的核销直接返回ErrRedisUnavailable
+func (r VerificationCodeRdb) degradedVerifyAndUseWithDigest(objName string, verCode string, digest string) (exist bool, success bool, err error) {
+	if digest != "" || r.deviceGrant != nil {
+		return false, false, ErrRedisUnavailable
+	}
+	return r.degradedVerifyAndUse(objName, verCode)
+}
+
+// 降级模式下吊销验证码(仅FailOpen模式下可吊销暂存于本地内存的验证码)
+func (r VerificationCodeRdb) degradedRevoke(objName string) (existed bool, err error) {
+	if r.degradation.strategy.policyOf(OperationSetAndRegister) != DegradationPolicyFailOpen {
+		return false, ErrRedisUnavailable
+	}
+	return r.degradation.localCodes.remove(r.ModuleName, objName) > 0, nil
+}
+
+// redis恢复可用后, 将本地暂存的验证码迁移至redis, 并按序重放队列中的写操作
+func (r VerificationCodeRdb) recoverFromDegradation(dc *degradationController) {
+	for _, c := range dc.localCodes.drain() {
+		if remain := c.expireAt.Sub(r.now()); remain > 0 {
+			owner := r
+			owner.ModuleName = c.moduleName
+			_ = owner.registerVerificationCode(c.objName, c.code, remain)
+		}
+	}
+
+	for _, fn := range dc.drainQueue() {
+		_ = fn()
+	}
+}
+
+// 写操作入队. 队列已满时返回ErrRedisUnavailable
+func (dc *degradationController) enqueue(fn func() error) error {
+	dc.queueMutex.Lock()
+	defer dc.queueMutex.Unlock()
+
+	if len(dc.queue) >= dc.strategy.QueueCapacity {
+		return ErrRedisUnavailable
+	}
+	dc.queue = append(dc.queue, fn)
+	return nil
+}
+
+// 取出队列中全部的写操作
+func (dc *degradationController) drainQueue() []func() error {
+	dc.queueMutex.Lock()
+	defer dc.queueMutex.Unlock()
+
+	q := dc.queue
+	dc.queue = nil
+	return q
+}
+
+// 本地内存限流器(固定窗口计数)
+type localLimiter struct {
+	mutex     sync.Mutex
+	threshold int
+	window    time.Duration
+	counters  map[string]*localLimiterCounter
+}
+
+type localLimiterCounter struct {
+	start time.Time
+	count int
+}
+
+func createLocalLimiter(threshold int, window time.Duration) *localLimiter {
+	return &localLimiter{
+		threshold: threshold,
+		window:    window,
+		counters:  map[string]*localLimiterCounter{},
+	}
+}
+
+// 判断请求是否放行, 放行则计数+1
+func (l *localLimiter) allow(key string, now time.Time) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	c, ok := l.counters[key]
+	if !ok || now.Sub(c.start) >= l.window {
+		l.prune(now)
+		c = &localLimiterCounter{start: now}
+		l.counters[key] = c
+	}
+
+	if c.count >= l.threshold {
+		return false
+	}
+	c.count++
+	return true
+}
+
+// 判断请求是否会被放行, 不计数
+func (l *localLimiter) peek(key string, now time.Time) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	c, ok := l.counters[key]
+	return !ok || now.Sub(c.start) >= l.window || c.count < l.threshold
+}
+
+// 清理已过期的窗口
+func (l *localLimiter) prune(now time.Time) {
+	for key, c := range l.counters {
+		if now.Sub(c.start) >= l.window {
+			delete(l.counters, key)
+		}
+	}
+}
+
+// 删除对象在全部操作上的计数. key: 不含操作前缀的部分(业务模块名称:对象名称)
+func (l *localLimiter) remove(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for k := range l.counters {
+		if parts := strings.SplitN(k, ":", 2); len(parts) == 2 && parts[1] == key {
+			delete(l.counters, k)
+		}
+	}
+}
+
+// 降级模式下暂存于本地内存的验证码. 多租户共享降级控制器, 因此需记录验证码所属的业务模块
+type localCode struct {
+	moduleName string
+	objName    string
+	code       string
+	expireAt   time.Time
+	failures   int // 核销失败的次数
+}
+
+type localCodeStore struct {
+	mutex sync.Mutex
+	codes map[string]localCode
+}
+
+func createLocalCodeStore() *localCodeStore {
+	return &localCodeStore{codes: map[string]localCode{}}
+}
+
+func (s *localCodeStore) set(moduleName string, objName string, verCode string, expireAt time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.codes[moduleName+":"+objName] = localCode{moduleName: moduleName, objName: objName, code: verCode, expireAt: expireAt}
+}
+
+// 核销本地暂存的验证码, 核销成功后删除. 失败次数达到failThreshold后验证码作废, 避免降级期间被暴力猜解
+func (s *localCodeStore) verifyAndUse(moduleName string, objName string, verCode string, now time.Time, cs CodeComparisonStrategy, failThreshold int) (exist bool, success bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := moduleName + ":" + objName
+	c, ok := s.codes[key]
+	if !ok || now.After(c.expireAt) {
+		delete(s.codes, key)
+		return false, false
+	}
+
+	if !cs.Equal(c.code, verCode) {
+		if c.failures++; c.failures >= failThreshold {
+			delete(s.codes, key)
+		} else {
+			s.codes[key] = c
+		}
+		return true, false
+	}
+	delete(s.codes, key)
+	return true, true
+}
+
+// 校验本地暂存的验证码但不核销
+func (s *localCodeStore) peek(moduleName string, objName string, verCode string, now time.Time, cs CodeComparisonStrategy) (exist bool, success bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	c, ok := s.codes[moduleName+":"+objName]
+	if !ok || now.After(c.expireAt) {
+		return false, false
+	}
+	return true, cs.Equal(c.code, verCode)
+}
+
+// 删除对象暂存的验证码, 返回删除的数量
+func (s *localCodeStore) remove(moduleName string, objName string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := moduleName + ":" + objName
+	if _, ok := s.codes[key]; !ok {
+		return 0
+	}
+	delete(s.codes, key)
+	return 1
+}
+
+// 取出全部暂存的验证码
+func (s *localCodeStore) drain() map[string]localCode {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	codes := s.codes
+	s.codes = map[string]localCode{}
+	return codes
+}
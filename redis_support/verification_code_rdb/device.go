@@ -0,0 +1,307 @@
+package verification_code_rdb
+
+import (
+	"context"
+	"errors"
+	"github.com/DontBeProud/wow-easy-go/utils/wow_random"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"time"
+)
+
+// VerificationCodeDeviceInterface 受信任设备令牌的签发、校验及撤销
+type VerificationCodeDeviceInterface interface {
+	VerifyAndUseVerificationCodeWithDeviceToken(objName string, verCode string, fingerprint string) (exist bool, success bool, token string, err error)
+	IssueDeviceToken(objName string, fingerprint string) (token string, err error)
+	CheckDeviceToken(objName string, fingerprint string, token string) (trusted bool, err error)
+	RevokeDeviceToken(objName string, token string) (existed bool, err error)
+	RevokeTrustedDevice(objName string, deviceId string) (existed bool, err error)
+	RevokeAllTrustedDevices(objName string) (int, error)
+	ListTrustedDevices(objName string) ([]TrustedDevice, error)
+}
+
+const (
+	defaultDeviceTokenLifetime = 30 * 24 * time.Hour // 设备令牌默认的有效期
+	deviceTokenByteLength      = 32                  // 设备令牌的随机字节数
+)
+
+// 管理员操作的名称(受信任设备)
+const (
+	AdminOperationRevokeTrustedDevice     = "revoke_trusted_device"
+	AdminOperationRevokeAllTrustedDevices = "revoke_all_trusted_devices"
+)
+
+var (
+	// ErrDeviceTrustDisabled 未配置受信任设备策略
+	ErrDeviceTrustDisabled = errors.New("device trust is not enabled")
+	// ErrEmptyFingerprint 设备指纹为空
+	ErrEmptyFingerprint = errors.New("device fingerprint is empty")
+)
+
+// 写入设备令牌并淘汰超出数量上限的令牌. 参数依次为: 令牌字段, 对象的设备索引, 对象名称, 设备指纹的摘要, 签发时间(unix秒),
+// 有效期(毫秒), 当前时间及过期时间(unix毫秒), 设备ID, 单个对象最多的受信任设备数量, 令牌字段名称的前缀
+const issueDeviceTokenLua = `
+local function issueDeviceToken(deviceKey, index, sub, fp, created, lifetime, now, expireAt, deviceId, max, prefix)
+	redis.call('HSET', deviceKey, 'sub', sub, 'fp', fp, 'created', created)
+	redis.call('PEXPIRE', deviceKey, lifetime)
+	redis.call('ZREMRANGEBYSCORE', index, '-inf', '(' .. now)
+	redis.call('ZADD', index, expireAt, deviceId)
+	redis.call('PEXPIRE', index, lifetime)
+	local excess = redis.call('ZCARD', index) - tonumber(max)
+	if tonumber(max) > 0 and excess > 0 then
+		local evicted = redis.call('ZPOPMIN', index, excess)
+		for i = 1, #evicted, 2 do
+			redis.call('DEL', prefix .. evicted[i])
+		end
+	end
+end
+`
+
+var issueDeviceTokenScript = redis.NewScript(issueDeviceTokenLua + `
+issueDeviceToken(KEYS[1], KEYS[2], unpack(ARGV))
+return 1
+`)
+
+// 与verifyAndUseScript一致地核销验证码, 核销成功时在同一脚本中签发设备令牌
+var verifyAndIssueDeviceTokenScript = redis.NewScript(issueDeviceTokenLua + `
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+redis.call('DEL', KEYS[1])
+redis.call('SREM', KEYS[2], ARGV[2])
+issueDeviceToken(KEYS[3], KEYS[4], unpack(ARGV, 3))
+return 1
+`)
+
+// DeviceTrustStrategy 受信任设备("记住此设备")的配置. 核销成功后可为设备签发令牌, 令牌有效期内该设备可跳过短信验证
+type DeviceTrustStrategy struct {
+	Lifetime             time.Duration // 设备令牌的有效期, 小于等于0时使用默认值(30天)
+	MaxDevicesPerSubject int           // 单个对象最多的受信任设备数量, 超出时淘汰最早签发的令牌. 为0时不限制
+}
+
+// TrustedDevice 受信任设备
+type TrustedDevice struct {
+	DeviceId  string    // 设备ID(令牌的摘要), 可用于RevokeTrustedDevice
+	CreatedAt time.Time // 令牌的签发时间
+	ExpireAt  time.Time // 令牌的过期时间
+}
+
+// 设备令牌的有效期
+func (s DeviceTrustStrategy) lifetime() time.Duration {
+	if s.Lifetime <= 0 {
+		return defaultDeviceTokenLifetime
+	}
+	return s.Lifetime
+}
+
+// 核销成功时一并签发的设备令牌
+type deviceTokenGrant struct {
+	token       string // 设备令牌
+	fingerprint string // 设备指纹
+}
+
+// 为对象的设备签发令牌. redis中仅保存令牌及设备指纹的摘要
+func (r VerificationCodeRdb) issueDeviceToken(objName string, fingerprint string) (string, error) {
+	if r.deviceTrust == nil {
+		return "", ErrDeviceTrustDisabled
+	}
+	if fingerprint == "" {
+		return "", ErrEmptyFingerprint
+	}
+	if r.isDegraded() {
+		return "", ErrRedisUnavailable
+	}
+
+	token, err := wow_random.GenerateSecureRandomHexString(deviceTokenByteLength)
+	if err != nil {
+		return "", err
+	}
+	keys, args := r.deviceTokenKeysAndArgs(objName, deviceTokenGrant{token: token, fingerprint: fingerprint})
+	if err = issueDeviceTokenScript.Run(context.TODO(), r.rDb, keys, args...).Err(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// 核销验证码, 核销成功时为设备签发令牌. 令牌与验证码的核销在同一脚本中完成, 不会出现验证码已被核销但令牌签发失败的情况
+// redis不可用时无法签发令牌, 返回ErrRedisUnavailable且不核销降级模式下暂存于本地内存的验证码
+func (r VerificationCodeRdb) verifyAndUseVerificationCodeWithDeviceToken(objName string, verCode string, fingerprint string) (exist bool, success bool, token string, err error) {
+	if r.deviceTrust == nil {
+		return false, false, "", ErrDeviceTrustDisabled
+	}
+	if fingerprint == "" {
+		return false, false, "", ErrEmptyFingerprint
+	}
+	if r.isDegraded() {
+		return false, false, "", ErrRedisUnavailable
+	}
+
+	if token, err = wow_random.GenerateSecureRandomHexString(deviceTokenByteLength); err != nil {
+		return false, false, "", err
+	}
+	r.deviceGrant = &deviceTokenGrant{token: token, fingerprint: fingerprint}
+	if exist, success, err = r.verifyAndUseVerificationCode(objName, verCode); err != nil || !success {
+		return exist, success, "", err
+	}
+	return exist, success, token, nil
+}
+
+// 签发设备令牌的脚本所需的字段名称及参数
+func (r VerificationCodeRdb) deviceTokenKeysAndArgs(objName string, grant deviceTokenGrant) ([]string, []interface{}) {
+	deviceId := deviceDigest(grant.token)
+	now := r.now()
+	lifetime := r.deviceTrust.lifetime()
+	keys := []string{
+		r.getRedisFieldNameVerificationCodeTrustedDevice(deviceId),
+		r.getRedisFieldNameVerificationCodeDeviceIndex(objName),
+	}
+	args := []interface{}{
+		objName,
+		deviceDigest(grant.fingerprint),
+		now.Unix(),
+		lifetime.Milliseconds(),
+		now.UnixMilli(),
+		now.Add(lifetime).UnixMilli(),
+		deviceId,
+		r.deviceTrust.MaxDevicesPerSubject,
+		r.getRedisFieldNameVerificationCodeTrustedDevice(""),
+	}
+	return keys, args
+}
+
+// 校验设备令牌: 令牌存在且未过期, 并且与对象及设备指纹均一致
+func (r VerificationCodeRdb) checkDeviceToken(objName string, fingerprint string, token string) (trusted bool, err error) {
+	if r.deviceTrust == nil {
+		return false, ErrDeviceTrustDisabled
+	}
+	if fingerprint == "" || token == "" {
+		return false, nil
+	}
+	if r.isDegraded() {
+		return false, ErrRedisUnavailable
+	}
+
+	vals, err := r.rDb.HMGet(context.TODO(), r.getRedisFieldNameVerificationCodeTrustedDevice(deviceDigest(token)), "sub", "fp").Result()
+	if err != nil {
+		return false, err
+	}
+	sub, _ := vals[0].(string)
+	fp, _ := vals[1].(string)
+	return sub != "" && sub == objName && digestEqual(fp, deviceDigest(fingerprint)), nil
+}
+
+// 吊销对象的单个受信任设备. existed: 吊销前是否存在
+func (r VerificationCodeRdb) revokeTrustedDevice(objName string, deviceId string) (existed bool, err error) {
+	if r.deviceTrust == nil {
+		return false, ErrDeviceTrustDisabled
+	}
+
+	ctx := context.TODO()
+	key := r.getRedisFieldNameVerificationCodeTrustedDevice(deviceId)
+	sub, err := r.rDb.HGet(ctx, key, "sub").Result()
+	if err == redis.Nil || (err == nil && sub != objName) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if _, err = r.rDb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		pipe.ZRem(ctx, r.getRedisFieldNameVerificationCodeDeviceIndex(objName), deviceId)
+		return nil
+	}); err != nil {
+		return false, err
+	}
+	r.historyAdmin(objName, AdminOperationRevokeTrustedDevice, map[string]string{"device_id": deviceId})
+	return true, nil
+}
+
+// 吊销对象的全部受信任设备, 返回吊销的数量
+func (r VerificationCodeRdb) revokeAllTrustedDevices(objName string) (int, error) {
+	if r.deviceTrust == nil {
+		return 0, ErrDeviceTrustDisabled
+	}
+
+	ctx := context.TODO()
+	index := r.getRedisFieldNameVerificationCodeDeviceIndex(objName)
+	deviceIds, err := r.rDb.ZRange(ctx, index, 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	if len(deviceIds) == 0 {
+		return 0, nil
+	}
+
+	keys := make([]string, len(deviceIds))
+	for i, deviceId := range deviceIds {
+		keys[i] = r.getRedisFieldNameVerificationCodeTrustedDevice(deviceId)
+	}
+	var del *redis.IntCmd
+	if _, err = r.rDb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		del = pipe.Del(ctx, keys...)
+		pipe.Del(ctx, index)
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+
+	n := int(del.Val())
+	r.historyAdmin(objName, AdminOperationRevokeAllTrustedDevices, map[string]string{"count": strconv.Itoa(n)})
+	return n, nil
+}
+
+// 列出对象全部未过期的受信任设备(按签发时间升序排列)
+func (r VerificationCodeRdb) listTrustedDevices(objName string) ([]TrustedDevice, error) {
+	if r.deviceTrust == nil {
+		return nil, ErrDeviceTrustDisabled
+	}
+
+	ctx := context.TODO()
+	now := strconv.FormatInt(r.now().UnixMilli(), 10)
+	entries, err := r.rDb.ZRangeByScoreWithScores(ctx, r.getRedisFieldNameVerificationCodeDeviceIndex(objName), &redis.ZRangeBy{Min: now, Max: "+inf"}).Result()
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+
+	createdCmds := make([]*redis.StringCmd, len(entries))
+	if _, err = r.rDb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, e := range entries {
+			createdCmds[i] = pipe.HGet(ctx, r.getRedisFieldNameVerificationCodeTrustedDevice(e.Member.(string)), "created")
+		}
+		return nil
+	}); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	var res []TrustedDevice
+	for i, e := range entries {
+		created, err := createdCmds[i].Int64()
+		if err != nil {
+			// 令牌已被删除
+			continue
+		}
+		res = append(res, TrustedDevice{
+			DeviceId:  e.Member.(string),
+			CreatedAt: time.Unix(created, 0),
+			ExpireAt:  time.UnixMilli(int64(e.Score)),
+		})
+	}
+	return res, nil
+}
+
+// 计算令牌或设备指纹的摘要
+func deviceDigest(s string) string {
+	return payloadDigest([]byte(s))
+}
+
+// 根据设备ID生成存储受信任设备的字段名称
+func (r VerificationCodeRdb) getRedisFieldNameVerificationCodeTrustedDevice(deviceId string) string {
+	return r.ModuleName + "VerificationCodeTrustedDevice" + deviceId
+}
+
+// 根据对象名称生成存储对象全部受信任设备索引(有序集合, score为过期时间的unix毫秒数)的字段名称
+func (r VerificationCodeRdb) getRedisFieldNameVerificationCodeDeviceIndex(objName string) string {
+	return r.ModuleName + "VerificationCodeDeviceIndex" + objName
+}
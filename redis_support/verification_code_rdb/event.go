@@ -0,0 +1,51 @@
+package verification_code_rdb
+
+import "time"
+
+// EventType 验证码服务的事件类型
+type EventType string
+
+const (
+	EventTypeRedisUnavailable     EventType = "redis_unavailable"      // redis不可用, 进入降级模式
+	EventTypeRedisRecovered       EventType = "redis_recovered"        // redis恢复可用, 退出降级模式
+	EventTypeSubjectBanned        EventType = "subject_banned"         // 对象的失败次数达到封禁阈值
+	EventTypeSubjectRejected      EventType = "subject_rejected"       // 对象的请求被校验规则拒绝(每次拒绝均触发), Detail["invalid_type"]为拒绝的原因
+	EventTypeSubjectRiskThreshold EventType = "subject_risk_threshold" // 对象当日的核销失败次数达到风险阈值(RiskThresholds), 每个阈值每日至多触发一次. Detail["failed_count"]为当日失败次数
+)
+
+// VerificationEvent 验证码服务事件
+type VerificationEvent struct {
+	Type       EventType         // 事件类型
+	ModuleName string            // 业务模块名称
+	ObjName    string            // 事件关联的对象名称, 与具体对象无关的事件为空
+	Time       time.Time         // 事件发生的时间
+	Detail     map[string]string // 附加信息
+}
+
+// EventHandler 事件回调函数. 回调在触发事件的goroutine中同步执行, 不应长时间阻塞
+type EventHandler func(event VerificationEvent)
+
+// 当日失败次数是否恰好达到某一风险阈值(失败次数逐次累加, 因此每个阈值每日仅命中一次)
+func (r VerificationCodeRdb) isRiskThreshold(failedCount int) bool {
+	for _, threshold := range r.riskThresholds {
+		if threshold > 0 && failedCount == threshold {
+			return true
+		}
+	}
+	return false
+}
+
+// 触发事件
+func (r VerificationCodeRdb) emitEvent(eventType EventType, objName string, detail map[string]string) {
+	if r.eventHandler == nil {
+		return
+	}
+
+	r.eventHandler(VerificationEvent{
+		Type:       eventType,
+		ModuleName: r.ModuleName,
+		ObjName:    objName,
+		Time:       r.now(),
+		Detail:     detail,
+	})
+}
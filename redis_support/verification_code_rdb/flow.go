@@ -0,0 +1,434 @@
+package verification_code_rdb
+
+import (
+	"context"
+	"errors"
+	"github.com/DontBeProud/wow-easy-go/utils/wow_random"
+	"github.com/go-redis/redis/v8"
+	"strings"
+	"time"
+)
+
+const (
+	defaultFlowTTL         = 15 * time.Minute // 流程默认的有效期
+	flowIdByteLength       = 16               // 流程ID的随机字节数
+	flowFieldPrefixSubject = "subject:"       // 流程哈希表中存储因素对象名称的字段前缀
+	flowFieldPrefixDone    = "done:"          // 流程哈希表中标记因素已通过验证的字段前缀
+	flowMarkDoneNotFound   = -1               // flowMarkDoneScript: 流程不存在或已过期
+)
+
+var (
+	// ErrFlowNotFound 流程不存在或已过期
+	ErrFlowNotFound = errors.New("verification flow not found or expired")
+	// ErrUnknownFactor 流程未声明该因素
+	ErrUnknownFactor = errors.New("unknown verification flow factor")
+)
+
+// 标记因素已通过验证, 并判断全部因素是否均已通过验证
+// KEYS[1]: 流程. ARGV[1]: 需标记的字段; ARGV[2...]: 全部因素的标记字段. 返回 -1: 流程不存在; 0: 尚未全部通过; 1: 全部通过
+var flowMarkDoneScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return -1
+end
+redis.call('HSET', KEYS[1], ARGV[1], 1)
+for i = 2, #ARGV do
+	if not redis.call('HGET', KEYS[1], ARGV[i]) then
+		return 0
+	end
+end
+return 1
+`)
+
+// 全部因素均已通过验证时删除流程并返回流程的全部字段, 否则返回空
+// KEYS[1]: 流程. ARGV: 全部因素的标记字段
+var flowFinishScript = redis.NewScript(`
+for i = 1, #ARGV do
+	if not redis.call('HGET', KEYS[1], ARGV[i]) then
+		return {}
+	end
+end
+local fields = redis.call('HGETALL', KEYS[1])
+redis.call('DEL', KEYS[1])
+return fields
+`)
+
+// FlowFactorRdbInterface 多因素流程中的因素所需的验证码服务能力
+type FlowFactorRdbInterface interface {
+	VerificationCodeRdbInterface
+	VerificationCodePayloadInterface
+}
+
+// FlowFactor 多因素流程中的单个因素
+type FlowFactor struct {
+	Name string                 // 因素名称, 例如"sms"/"email", 在流程内唯一
+	Rdb  FlowFactorRdbInterface // 该因素使用的验证码服务. 发送及核销均复用其策略与频率限制
+}
+
+// VerificationFlowStrategy 多因素流程的策略
+type VerificationFlowStrategy struct {
+	Factors []FlowFactor  // 需全部通过验证的因素, 通过顺序不限
+	TTL     time.Duration // 流程的有效期(自创建时起, 全部因素共享), 小于等于0时使用默认值(15分钟)
+}
+
+// FlowFactorStatus 流程中单个因素的状态
+type FlowFactorStatus struct {
+	Name     string // 因素名称
+	Subject  string // 因素对应的对象名称(手机号码/邮箱等)
+	Verified bool   // 是否已通过验证
+}
+
+// FlowStatus 流程的状态
+type FlowStatus struct {
+	FlowId    string             // 流程ID
+	Factors   []FlowFactorStatus // 各因素的状态, 顺序与策略中声明的顺序一致
+	Completed bool               // 是否全部因素均已通过验证
+	TTL       time.Duration      // 流程剩余的有效期
+}
+
+// VerificationFlow 多因素验证流程(例如账号找回需同时验证短信与邮箱), 流程状态存储于redis中
+type VerificationFlow struct {
+	VerificationFlowInterface
+	ModuleName string                   // 业务模块名称, 流程相关的redis-key与该字段关联
+	rDb        *redis.Client            // 存储流程状态的redis对象
+	strategy   VerificationFlowStrategy // 策略
+}
+
+// VerificationFlowInterface 多因素验证流程接口
+type VerificationFlowInterface interface {
+	Start(subjects map[string]string) (flowId string, err error)
+	PreCheckBeforeSend(flowId string, factor string) (it InvalidType, err error)
+	SetAndRegisterVerificationCode(flowId string, factor string, verCode string) error
+	PreCheckBeforeVerify(flowId string, factor string) (it InvalidType, err error)
+	Verify(flowId string, factor string, verCode string) (exist bool, success bool, completed bool, err error)
+	QueryFlowStatus(flowId string) (FlowStatus, error)
+	Finish(flowId string) (completed bool, subjects map[string]string, err error)
+	ForgetSubject(subject string) (deleted int, err error)
+}
+
+// CreateVerificationFlow 创建多因素验证流程
+func CreateVerificationFlow(rdb *redis.Client, moduleName string, strategy VerificationFlowStrategy) (*VerificationFlow, error) {
+	return createVerificationFlow(rdb, moduleName, strategy)
+}
+
+// Start 创建流程. subjects: 因素名称 -> 对象名称, 须覆盖策略中声明的全部因素. 返回的flowId用于后续各步骤
+func (f VerificationFlow) Start(subjects map[string]string) (flowId string, err error) {
+	return f.start(subjects)
+}
+
+// PreCheckBeforeSend 向流程中的某一因素发送验证码前的校验, 复用该因素的验证码服务的校验规则
+func (f VerificationFlow) PreCheckBeforeSend(flowId string, factor string) (it InvalidType, err error) {
+	rdb, subject, err := f.factorOf(flowId, factor)
+	if err != nil {
+		return InvalidTypeServiceUnavailable, err
+	}
+	return rdb.PreCheckBeforeSendVerificationCode(subject)
+}
+
+// SetAndRegisterVerificationCode 添加并记录流程中某一因素的验证码. 验证码与流程及因素绑定, 仅能通过本流程的Verify核销
+// 降级模式下无法绑定, 返回ErrRedisUnavailable
+func (f VerificationFlow) SetAndRegisterVerificationCode(flowId string, factor string, verCode string) error {
+	rdb, subject, err := f.factorOf(flowId, factor)
+	if err != nil {
+		return err
+	}
+	return rdb.SetAndRegisterVerificationCodeWithPayload(subject, verCode, flowFactorPayload(flowId, factor))
+}
+
+// PreCheckBeforeVerify 核销流程中某一因素的验证码前的校验, 复用该因素的验证码服务的校验规则
+func (f VerificationFlow) PreCheckBeforeVerify(flowId string, factor string) (it InvalidType, err error) {
+	rdb, subject, err := f.factorOf(flowId, factor)
+	if err != nil {
+		return InvalidTypeServiceUnavailable, err
+	}
+	return rdb.PreCheckBeforeVerifyAndUseVerificationCode(subject)
+}
+
+// Verify 核销流程中某一因素的验证码, 核销成功时标记该因素已通过验证
+// completed: 是否全部因素均已通过验证. 流程不存在或已过期时返回ErrFlowNotFound
+func (f VerificationFlow) Verify(flowId string, factor string, verCode string) (exist bool, success bool, completed bool, err error) {
+	return f.verify(flowId, factor, verCode)
+}
+
+// QueryFlowStatus 查询流程的状态. 流程不存在或已过期时返回ErrFlowNotFound
+func (f VerificationFlow) QueryFlowStatus(flowId string) (FlowStatus, error) {
+	return f.queryFlowStatus(flowId)
+}
+
+// Finish 结束流程: 全部因素均已通过验证时删除流程(流程仅能成功结束一次)并返回各因素的对象名称; 否则保留流程并返回completed为false
+func (f VerificationFlow) Finish(flowId string) (completed bool, subjects map[string]string, err error) {
+	return f.finish(flowId)
+}
+
+// ForgetSubject 删除涉及该对象(任一因素的对象名称与subject经该因素的验证码服务规范化后一致)的全部流程, 返回删除的流程数量, 用于响应个人信息删除请求
+// 流程通过SCAN查找, 不会长时间阻塞redis. 各因素的验证码等数据需另行通过因素的验证码服务的ForgetSubject清除
+func (f VerificationFlow) ForgetSubject(subject string) (deleted int, err error) {
+	return f.forgetSubject(subject)
+}
+
+func createVerificationFlow(rdb *redis.Client, moduleName string, strategy VerificationFlowStrategy) (*VerificationFlow, error) {
+	if rdb == nil {
+		return nil, errors.New("rdb == nil")
+	}
+	if moduleName == "" {
+		return nil, errors.New("ModuleName == \"\"")
+	}
+	if len(strategy.Factors) == 0 {
+		return nil, errors.New("len(Factors) == 0")
+	}
+
+	names := map[string]bool{}
+	for _, factor := range strategy.Factors {
+		if factor.Name == "" || factor.Rdb == nil {
+			return nil, errors.New("invalid factor: Name == \"\" or Rdb == nil")
+		}
+		if names[factor.Name] {
+			return nil, errors.New("duplicate factor: " + factor.Name)
+		}
+		names[factor.Name] = true
+	}
+	if strategy.TTL <= 0 {
+		strategy.TTL = defaultFlowTTL
+	}
+
+	return &VerificationFlow{ModuleName: moduleName, rDb: rdb, strategy: strategy}, nil
+}
+
+// 创建流程, 记录各因素的对象名称并设置流程的有效期
+func (f VerificationFlow) start(subjects map[string]string) (string, error) {
+	values := make([]interface{}, 0, 2*len(f.strategy.Factors))
+	for _, factor := range f.strategy.Factors {
+		subject, ok := subjects[factor.Name]
+		if !ok || subject == "" {
+			return "", errors.New("missing subject of factor: " + factor.Name)
+		}
+		values = append(values, flowFieldPrefixSubject+factor.Name, subject)
+	}
+
+	flowId, err := wow_random.GenerateSecureRandomHexString(flowIdByteLength)
+	if err != nil {
+		return "", err
+	}
+
+	ctx := context.TODO()
+	key := f.getRedisFieldNameVerificationFlow(flowId)
+	if _, err = f.rDb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, values...)
+		pipe.Expire(ctx, key, f.strategy.TTL)
+		return nil
+	}); err != nil {
+		return "", err
+	}
+	return flowId, nil
+}
+
+// 查询流程中因素对应的验证码服务及对象名称
+func (f VerificationFlow) factorOf(flowId string, factor string) (FlowFactorRdbInterface, string, error) {
+	rdb := f.factorRdb(factor)
+	if rdb == nil {
+		return nil, "", ErrUnknownFactor
+	}
+
+	subject, err := f.rDb.HGet(context.TODO(), f.getRedisFieldNameVerificationFlow(flowId), flowFieldPrefixSubject+factor).Result()
+	if err == redis.Nil {
+		return nil, "", ErrFlowNotFound
+	}
+	return rdb, subject, err
+}
+
+// 核销因素的验证码, 成功后原子地标记该因素并判断流程是否完成
+func (f VerificationFlow) verify(flowId string, factor string, verCode string) (exist bool, success bool, completed bool, err error) {
+	rdb, subject, err := f.factorOf(flowId, factor)
+	if err != nil {
+		return false, false, false, err
+	}
+
+	// 仅能核销本流程中该因素的验证码, 对象在流程外或其他流程中申请的验证码均视为核销失败
+	if exist, success, err = rdb.VerifyAndUseVerificationCodeWithPayload(subject, verCode, flowFactorPayload(flowId, factor)); err != nil || !success {
+		return exist, success, false, err
+	}
+
+	args := []interface{}{flowFieldPrefixDone + factor}
+	for _, field := range f.doneFields() {
+		args = append(args, field)
+	}
+	res, err := flowMarkDoneScript.Run(context.TODO(), f.rDb, []string{f.getRedisFieldNameVerificationFlow(flowId)}, args...).Int()
+	if err != nil {
+		return exist, success, false, err
+	}
+	if res == flowMarkDoneNotFound {
+		// 核销期间流程已过期
+		return exist, success, false, ErrFlowNotFound
+	}
+	return exist, success, res == 1, nil
+}
+
+// 查询流程的状态
+func (f VerificationFlow) queryFlowStatus(flowId string) (FlowStatus, error) {
+	res := FlowStatus{FlowId: flowId}
+
+	ctx := context.TODO()
+	key := f.getRedisFieldNameVerificationFlow(flowId)
+	var fieldsCmd *redis.StringStringMapCmd
+	var ttlCmd *redis.DurationCmd
+	if _, err := f.rDb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		fieldsCmd = pipe.HGetAll(ctx, key)
+		ttlCmd = pipe.PTTL(ctx, key)
+		return nil
+	}); err != nil {
+		return res, err
+	}
+
+	fields := fieldsCmd.Val()
+	if len(fields) == 0 {
+		return res, ErrFlowNotFound
+	}
+
+	res.Completed = true
+	for _, factor := range f.strategy.Factors {
+		st := FlowFactorStatus{
+			Name:     factor.Name,
+			Subject:  fields[flowFieldPrefixSubject+factor.Name],
+			Verified: fields[flowFieldPrefixDone+factor.Name] != "",
+		}
+		res.Completed = res.Completed && st.Verified
+		res.Factors = append(res.Factors, st)
+	}
+	res.TTL = ttlCmd.Val()
+	return res, nil
+}
+
+// 结束流程
+func (f VerificationFlow) finish(flowId string) (completed bool, subjects map[string]string, err error) {
+	args := make([]interface{}, 0, len(f.strategy.Factors))
+	for _, field := range f.doneFields() {
+		args = append(args, field)
+	}
+
+	ctx := context.TODO()
+	key := f.getRedisFieldNameVerificationFlow(flowId)
+	fields, err := flowFinishScript.Run(ctx, f.rDb, []string{key}, args...).StringSlice()
+	if err != nil {
+		return false, nil, err
+	}
+	if len(fields) == 0 {
+		// 区分流程不存在与尚未完成
+		if n, err := f.rDb.Exists(ctx, key).Result(); err != nil || n == 0 {
+			return false, nil, errOrFlowNotFound(err)
+		}
+		return false, nil, nil
+	}
+
+	subjects = map[string]string{}
+	for i := 0; i+1 < len(fields); i += 2 {
+		if name := strings.TrimPrefix(fields[i], flowFieldPrefixSubject); name != fields[i] {
+			subjects[name] = fields[i+1]
+		}
+	}
+	return true, subjects, nil
+}
+
+// 使用SCAN查找业务模块的全部流程, 删除涉及该对象的流程
+func (f VerificationFlow) forgetSubject(subject string) (deleted int, err error) {
+	ctx := context.TODO()
+	pattern := escapeScanPattern(f.getRedisFieldNameVerificationFlow("")) + "*"
+	var cursor uint64
+	for {
+		keys, next, err := f.rDb.Scan(ctx, cursor, pattern, defaultExportScanCount).Result()
+		if err != nil {
+			return deleted, err
+		}
+		n, err := f.forgetSubjectInFlows(ctx, keys, subject)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+		if cursor = next; cursor == 0 {
+			return deleted, nil
+		}
+	}
+}
+
+// 删除keys中涉及该对象的流程, 返回删除的数量
+func (f VerificationFlow) forgetSubjectInFlows(ctx context.Context, keys []string, subject string) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	cmds := make([]*redis.StringStringMapCmd, len(keys))
+	if _, err := f.rDb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.HGetAll(ctx, key)
+		}
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+
+	// Start时记录的是调用方传入的原始对象名称, 两侧均需经因素的验证码服务规范化后再比较
+	normalized := map[string]string{}
+	for _, factor := range f.strategy.Factors {
+		normalized[factor.Name] = normalizeFactorSubject(factor.Rdb, subject)
+	}
+	var matched []string
+	for i, cmd := range cmds {
+		for field, value := range cmd.Val() {
+			factor := strings.TrimPrefix(field, flowFieldPrefixSubject)
+			target, ok := normalized[factor]
+			if ok && field != factor && normalizeFactorSubject(f.factorRdb(factor), value) == target {
+				matched = append(matched, keys[i])
+				break
+			}
+		}
+	}
+	if len(matched) == 0 {
+		return 0, nil
+	}
+	n, err := f.rDb.Del(ctx, matched...).Result()
+	return int(n), err
+}
+
+// 根据因素名称查找其验证码服务
+func (f VerificationFlow) factorRdb(factor string) FlowFactorRdbInterface {
+	for _, ff := range f.strategy.Factors {
+		if ff.Name == factor {
+			return ff.Rdb
+		}
+	}
+	return nil
+}
+
+// 使用因素的验证码服务规范化对象名称. 验证码服务不支持规范化或规范化失败时原样返回
+func normalizeFactorSubject(rdb FlowFactorRdbInterface, subject string) string {
+	if n, ok := rdb.(VerificationCodeNormalizerInterface); ok {
+		if normalized, err := n.NormalizeSubject(subject); err == nil {
+			return normalized
+		}
+	}
+	return subject
+}
+
+// 全部因素的标记字段
+func (f VerificationFlow) doneFields() []string {
+	res := make([]string, len(f.strategy.Factors))
+	for i, factor := range f.strategy.Factors {
+		res[i] = flowFieldPrefixDone + factor.Name
+	}
+	return res
+}
+
+// 流程中因素的验证码所绑定的交易载荷
+func flowFactorPayload(flowId string, factor string) []byte {
+	return []byte(flowId + ":" + factor)
+}
+
+func errOrFlowNotFound(err error) error {
+	if err != nil {
+		return err
+	}
+	return ErrFlowNotFound
+}
+
+// 根据流程ID生成存储流程状态(哈希表)的字段名称
+func (f VerificationFlow) getRedisFieldNameVerificationFlow(flowId string) string {
+	return f.ModuleName + "VerificationCodeFlow" + flowId
+}
@@ -0,0 +1,232 @@
+package verification_code_rdb
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"sort"
+	"strings"
+)
+
+// VerificationCodeForgetInterface 删除与对象相关的全部数据, 用于响应个人信息删除请求
+// ForgetSubject仅清除本业务模块的数据, 完整清除一个对象需依次调用:
+//  1. VerificationCodeRdb.ForgetSubject, 多租户时改为VerificationCodeRdbRegistry.ForgetSubject(覆盖默认业务模块及全部租户)
+//  2. 使用了多因素流程时, 各VerificationFlow.ForgetSubject
+//  3. 使用了webhook时, 各业务模块的verification_code_webhook.Dispatcher.PurgeSubject
+//
+// 业务模块的统计数据(HyperLogLog)不在清除范围内, 见ForgetReport.StatisticsRetained
+type VerificationCodeForgetInterface interface {
+	ForgetSubject(objName string) (ForgetReport, error)
+}
+
+// 按日存储的对象字段的种类, 字段名称为 ModuleName + "VerificationCode" + 种类 + 对象名称 + 日期(20060102)
+var datedSubjectKeyKinds = []string{"Set", "ErrorCount", "LastErrorTime", "ResendCount"}
+
+// ForgetReport 清除对象全部数据的结果
+type ForgetReport struct {
+	ObjName        string   // 对象名称
+	DeletedKeys    []string // 删除的redis字段(验证码、各日的计数及封禁状态、策略覆盖项等), 不含受信任设备的令牌
+	TrustedDevices int      // 删除的受信任设备令牌数量
+	HistoryRecords int      // 删除的历史记录条数
+	LocalCodes     int      // 删除的降级模式下暂存于本地内存的验证码数量
+	// 是否保留了包含该对象的统计数据(开启按日统计时为true). 去重对象数量使用HyperLogLog估算, 其中不保存对象名称的原文, 无法按对象删除
+	StatisticsRetained bool
+}
+
+// 清除对象的全部数据: 验证码及其累计延长时长、各日的未核销验证码集合/失败次数/最后一次失败的时间/重发次数、
+// 策略覆盖项、受信任设备、历史记录, 以及降级模式下暂存于本地内存的验证码与限流计数
+// 各日的字段通过SCAN查找, 不会长时间阻塞redis
+func (r VerificationCodeRdb) forgetSubject(objName string) (report ForgetReport, err error) {
+	if r.isDegraded() {
+		return report, ErrRedisUnavailable
+	}
+	report.ObjName = objName
+	ctx := context.TODO()
+
+	keys := []string{
+		r.getRedisFieldNameVerificationCode(objName),
+		r.getRedisFieldNameVerificationCodeExtension(objName),
+		r.getRedisFieldNameVerificationCodeStrategyOverride(objName),
+	}
+	dated, err := r.scanDatedSubjectKeys(ctx, objName)
+	if err != nil {
+		return report, err
+	}
+	keys = append(keys, dated...)
+
+	deviceIndex := r.getRedisFieldNameVerificationCodeDeviceIndex(objName)
+	deviceIds, err := r.rDb.ZRange(ctx, deviceIndex, 0, -1).Result()
+	if err != nil {
+		return report, err
+	}
+	deviceKeys := make([]string, len(deviceIds))
+	for i, deviceId := range deviceIds {
+		deviceKeys[i] = r.getRedisFieldNameVerificationCodeTrustedDevice(deviceId)
+	}
+	keys = append(keys, deviceIndex)
+
+	keyCmds := make([]*redis.IntCmd, len(keys))
+	deviceCmds := make([]*redis.IntCmd, len(deviceKeys))
+	if _, err = r.rDb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			keyCmds[i] = pipe.Del(ctx, key)
+		}
+		for i, key := range deviceKeys {
+			deviceCmds[i] = pipe.Del(ctx, key)
+		}
+		pipe.ZRem(ctx, r.getRedisFieldNameVerificationCodeOverrideIndex(), objName)
+		return nil
+	}); err != nil {
+		return report, err
+	}
+	for i, cmd := range keyCmds {
+		if cmd.Val() > 0 {
+			report.DeletedKeys = append(report.DeletedKeys, keys[i])
+		}
+	}
+	for _, cmd := range deviceCmds {
+		report.TrustedDevices += int(cmd.Val())
+	}
+
+	if report.HistoryRecords, err = r.forgetHistory(ctx, objName); err != nil {
+		return report, err
+	}
+
+	report.StatisticsRetained = r.statistics != nil
+	if r.degradation != nil {
+		report.LocalCodes = r.degradation.localCodes.remove(r.ModuleName, objName)
+		r.degradation.limiter.remove(r.ModuleName + ":" + objName)
+	}
+	return report, nil
+}
+
+// 使用SCAN查找对象全部日期的按日存储的字段
+func (r VerificationCodeRdb) scanDatedSubjectKeys(ctx context.Context, objName string) ([]string, error) {
+	prefix := r.ModuleName + "VerificationCode"
+	pattern := escapeScanPattern(prefix) + "*" + escapeScanPattern(objName) + strings.Repeat("[0-9]", len(r.dateSuffix()))
+
+	seen := map[string]bool{}
+	var res []string
+	var cursor uint64
+	for {
+		keys, next, err := r.rDb.Scan(ctx, cursor, pattern, defaultExportScanCount).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if seen[key] || !isDatedSubjectKey(strings.TrimPrefix(key, prefix), objName) {
+				continue
+			}
+			seen[key] = true
+			res = append(res, key)
+		}
+		if cursor = next; cursor == 0 {
+			return res, nil
+		}
+	}
+}
+
+// 判断字段名称(去除业务模块名称及"VerificationCode"后)是否为该对象按日存储的字段. 需精确匹配, 避免误删名称互为前缀的其他对象的字段
+func isDatedSubjectKey(suffix string, objName string) bool {
+	for _, kind := range datedSubjectKeyKinds {
+		if !strings.HasPrefix(suffix, kind+objName) {
+			continue
+		}
+		date := suffix[len(kind+objName):]
+		if len(date) != len("20060102") {
+			continue
+		}
+		if strings.Trim(date, "0123456789") == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// 通过对象的历史记录索引删除其全部历史记录及索引, 返回删除的条数
+func (r VerificationCodeRdb) forgetHistory(ctx context.Context, objName string) (int, error) {
+	index := r.getRedisFieldNameVerificationCodeHistoryIndex(objName)
+	members, err := r.rDb.ZRange(ctx, index, 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	ids := make([]string, len(members))
+	for i, member := range members {
+		ids[i] = historyIdFromIndexMember(member)
+	}
+	var xDel *redis.IntCmd
+	if _, err = r.rDb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if len(ids) > 0 {
+			xDel = pipe.XDel(ctx, r.getRedisFieldNameVerificationCodeHistory(), ids...)
+		}
+		pipe.Del(ctx, index)
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	if xDel == nil {
+		return 0, nil
+	}
+	return int(xDel.Val()), nil
+}
+
+// 清除对象在默认业务模块及全部租户中的数据
+func (g *VerificationCodeRdbRegistry) forgetSubject(objName string) (map[string]ForgetReport, error) {
+	tenantIds, err := g.scanTenantIds(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+
+	res := map[string]ForgetReport{}
+	report, err := g.base.ForgetSubject(objName)
+	if err != nil {
+		return res, err
+	}
+	res[""] = report
+	for _, tenantId := range tenantIds {
+		r, err := g.Tenant(tenantId)
+		if err != nil {
+			return res, err
+		}
+		if report, err = r.ForgetSubject(objName); err != nil {
+			return res, err
+		}
+		res[tenantId] = report
+	}
+	return res, nil
+}
+
+// 查找全部租户: 单独设置了策略的租户, 以及redis中存在以租户的业务模块名称为前缀的字段的租户
+func (g *VerificationCodeRdbRegistry) scanTenantIds(ctx context.Context) ([]string, error) {
+	seen := map[string]bool{}
+	for _, tenantId := range g.ListTenantsWithStrategy() {
+		seen[tenantId] = true
+	}
+
+	// 租户ID不能包含"VerificationCode", 因此字段名称中首个"VerificationCode"之前的部分即为租户的业务模块名称
+	prefix := g.base.ModuleName + tenantModuleNameSeparator
+	pattern := escapeScanPattern(prefix) + "*VerificationCode*"
+	var cursor uint64
+	for {
+		keys, next, err := g.base.rDb.Scan(ctx, cursor, pattern, defaultExportScanCount).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			rest := strings.TrimPrefix(key, prefix)
+			if i := strings.Index(rest, "VerificationCode"); i > 0 && checkTenantId(rest[:i]) == nil {
+				seen[rest[:i]] = true
+			}
+		}
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+
+	res := make([]string, 0, len(seen))
+	for tenantId := range seen {
+		res = append(res, tenantId)
+	}
+	sort.Strings(res)
+	return res, nil
+}
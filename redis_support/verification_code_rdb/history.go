@@ -0,0 +1,429 @@
+package verification_code_rdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// VerificationCodeHistoryInterface 携带请求元数据的发送及核销, 以及历史记录的查询与写入
+type VerificationCodeHistoryInterface interface {
+	PreCheckBeforeSendVerificationCodeWithMetadata(objName string, meta RequestMetadata) (it InvalidType, err error)
+	SetAndRegisterVerificationCodeWithMetadata(objName string, verCode string, meta RequestMetadata) error
+	PreCheckBeforeVerifyAndUseVerificationCodeWithMetadata(objName string, meta RequestMetadata) (it InvalidType, err error)
+	VerifyAndUseVerificationCodeWithMetadata(objName string, verCode string, meta RequestMetadata) (exist bool, success bool, err error)
+	QueryHistory(objName string, from time.Time, to time.Time, cursor string, limit int) (records []HistoryRecord, nextCursor string, err error)
+	RecordAdminAction(objName string, operation string, detail map[string]string) error
+}
+
+const (
+	defaultHistoryMaxLen              = 100000              // 历史记录流默认的最大长度(近似值)
+	defaultHistoryMaxLenPerSubject    = 1000                // 单个对象默认保留的历史记录的最大条数
+	defaultHistorySubjectIndexTimeout = 90 * 24 * time.Hour // 对象的历史记录索引默认的有效期(自最后一次写入起算)
+	defaultHistoryPageSize            = 20                  // 查询历史记录时默认的每页条数
+	historyIndexIdWidth               = 20                  // 历史记录索引中流ID各部分补零后的宽度(uint64的最大位数)
+
+	historyFieldAction      = "action"
+	historyFieldObjName     = "obj"
+	historyFieldOutcome     = "outcome"
+	historyFieldInvalidType = "invalid_type"
+	historyFieldMaskedInput = "input"
+	historyFieldIP          = "ip"
+	historyFieldScene       = "scene"
+	historyFieldUserAgent   = "ua"
+	historyFieldMetaExtra   = "meta:"   // 请求元数据中其他附加信息的字段前缀, 后接附加信息的键
+	historyFieldDetail      = "detail:" // 附加信息的字段前缀, 后接附加信息的键
+)
+
+// HistoryAction 历史记录的操作类型
+type HistoryAction string
+
+const (
+	HistoryActionIssue  HistoryAction = "issue"  // 下发验证码
+	HistoryActionResend HistoryAction = "resend" // 重发已有的验证码
+	HistoryActionVerify HistoryAction = "verify" // 核销验证码
+	HistoryActionPeek   HistoryAction = "peek"   // 校验验证码但不核销
+	HistoryActionReject HistoryAction = "reject" // 校验未通过, 拒绝请求
+	HistoryActionBan    HistoryAction = "ban"    // 失败次数达到封禁阈值
+	HistoryActionAdmin  HistoryAction = "admin"  // 管理员操作
+)
+
+// 核销验证码的结果
+const (
+	HistoryOutcomeSuccess         = "success"          // 核销成功
+	HistoryOutcomeFail            = "fail"             // 验证码错误
+	HistoryOutcomeNotExist        = "not_exist"        // 验证码不存在或已过期
+	HistoryOutcomePayloadMismatch = "payload_mismatch" // 验证码正确, 但绑定的交易载荷不一致
+)
+
+// HistoryStrategy 历史记录的配置
+// 业务模块的历史记录写入同一个流, 另为每个对象维护一个索引(有序集合), 按对象查询及删除时仅需读取该对象的记录
+type HistoryStrategy struct {
+	MaxLen              int64         // 历史记录流的最大长度(近似值, 超出后淘汰最早的记录), 小于等于0时使用默认值(100000)
+	MaxLenPerSubject    int64         // 单个对象保留的历史记录的最大条数(超出后从流中删除该对象最早的记录), 小于等于0时使用默认值(1000)
+	SubjectIndexTimeout time.Duration // 对象的历史记录索引的有效期, 自最后一次写入起算. 小于等于0时使用默认值(90天). 应不短于记录在流中的留存时长, 否则留存的记录无法再按对象查询或删除
+	AppendOnly          bool          // 仅追加记录: 不淘汰流及对象的记录, 且仅在索引未设置有效期时设置有效期. 适用于运维工具等不掌握服务端历史记录配置的写入方, 以免按自身的配置淘汰服务端的记录
+}
+
+// RequestMetadata 请求的元数据, 随历史记录一并保存
+type RequestMetadata struct {
+	IP        string            // 请求方IP
+	Scene     string            // 业务场景, 例如 login/register/reset_password
+	UserAgent string            // 请求方UserAgent
+	Extra     map[string]string // 其他附加信息
+}
+
+// HistoryRecord 单条历史记录
+type HistoryRecord struct {
+	Id          string            // 记录在流中的ID, 可作为分页游标
+	Time        time.Time         // 记录时间
+	Action      HistoryAction     // 操作类型
+	ObjName     string            // 对象名称(规范化后)
+	Outcome     string            // 核销结果, 仅HistoryActionVerify及HistoryActionPeek有意义
+	InvalidType InvalidType       // 违规类型, 仅HistoryActionReject有意义
+	MaskedInput string            // 脱敏后的用户输入, 仅HistoryActionVerify及HistoryActionPeek有意义
+	Metadata    RequestMetadata   // 请求的元数据
+	Detail      map[string]string // 附加信息, 例如封禁时长、管理员操作的内容
+}
+
+// 历史记录流的最大长度
+func (s HistoryStrategy) maxLen() int64 {
+	if s.MaxLen <= 0 {
+		return defaultHistoryMaxLen
+	}
+	return s.MaxLen
+}
+
+// 单个对象的历史记录索引的最大长度
+func (s HistoryStrategy) maxLenPerSubject() int64 {
+	if s.MaxLenPerSubject <= 0 {
+		return defaultHistoryMaxLenPerSubject
+	}
+	return s.MaxLenPerSubject
+}
+
+// 对象的历史记录索引的有效期
+func (s HistoryStrategy) subjectIndexTimeout() time.Duration {
+	if s.SubjectIndexTimeout <= 0 {
+		return defaultHistorySubjectIndexTimeout
+	}
+	return s.SubjectIndexTimeout
+}
+
+// 追加历史记录并写入对象的索引, 对象的记录超出上限时从流中删除其最早的记录. 索引中的成员为补零后的流ID, 所有成员分值均为0, 按字典序即为时间顺序
+// KEYS[1]: 流	KEYS[2]: 对象的索引
+// ARGV[1]: 流的最大长度, 为0时不淘汰	ARGV[2]: 索引的最大长度, 为0时不淘汰	ARGV[3]: 索引的有效期(毫秒)	ARGV[4]: 为1时仅在索引未设置有效期时设置有效期	ARGV[5...]: 记录的字段及值
+var historyAppendScript = redis.NewScript(`
+local id
+if tonumber(ARGV[1]) > 0 then
+	id = redis.call('XADD', KEYS[1], 'MAXLEN', '~', ARGV[1], '*', unpack(ARGV, 5))
+else
+	id = redis.call('XADD', KEYS[1], '*', unpack(ARGV, 5))
+end
+local ms, seq = string.match(id, '^(%d+)%-(%d+)$')
+local width = ` + strconv.Itoa(historyIndexIdWidth) + `
+redis.call('ZADD', KEYS[2], 0, string.rep('0', width - #ms) .. ms .. '-' .. string.rep('0', width - #seq) .. seq)
+local overflow = redis.call('ZCARD', KEYS[2]) - tonumber(ARGV[2])
+if tonumber(ARGV[2]) > 0 and overflow > 0 then
+	local function unpad(part)
+		local trimmed = string.gsub(part, '^0+', '')
+		if trimmed == '' then
+			return '0'
+		end
+		return trimmed
+	end
+	for _, member in ipairs(redis.call('ZRANGE', KEYS[2], 0, overflow - 1)) do
+		local oms, oseq = string.match(member, '^(%d+)%-(%d+)$')
+		redis.call('XDEL', KEYS[1], unpad(oms) .. '-' .. unpad(oseq))
+	end
+	redis.call('ZREMRANGEBYRANK', KEYS[2], 0, overflow - 1)
+end
+if ARGV[4] ~= '1' or redis.call('PTTL', KEYS[2]) < 0 then
+	redis.call('PEXPIRE', KEYS[2], ARGV[3])
+end
+return id
+`)
+
+// 携带请求元数据的副本, 副本写入的历史记录均附带该元数据
+func (r VerificationCodeRdb) withMetadata(meta RequestMetadata) VerificationCodeRdb {
+	r.metadata = &meta
+	return r
+}
+
+// 追加一条历史记录. 未开启历史记录时不做任何处理
+func (r VerificationCodeRdb) appendHistory(rec HistoryRecord) {
+	if r.history == nil {
+		return
+	}
+	if r.metadata != nil {
+		rec.Metadata = *r.metadata
+	}
+
+	args := []interface{}{r.history.maxLen(), r.history.maxLenPerSubject(), r.history.subjectIndexTimeout().Milliseconds(), 0}
+	if r.history.AppendOnly {
+		args = []interface{}{0, 0, r.history.subjectIndexTimeout().Milliseconds(), 1}
+	}
+	for field, v := range formatHistoryRecord(rec) {
+		args = append(args, field, v)
+	}
+	historyAppendScript.Run(context.TODO(), r.rDb,
+		[]string{r.getRedisFieldNameVerificationCodeHistory(), r.getRedisFieldNameVerificationCodeHistoryIndex(rec.ObjName)}, args...)
+}
+
+// 记录下发验证码
+func (r VerificationCodeRdb) historyIssued(objName string) {
+	r.appendHistory(HistoryRecord{Action: HistoryActionIssue, ObjName: objName})
+}
+
+// 记录重发已有的验证码
+func (r VerificationCodeRdb) historyResent(objName string) {
+	r.appendHistory(HistoryRecord{Action: HistoryActionResend, ObjName: objName})
+}
+
+// 记录核销验证码, 用户输入经脱敏后保存
+func (r VerificationCodeRdb) historyVerified(objName string, input string, outcome string) {
+	r.appendHistory(HistoryRecord{Action: HistoryActionVerify, ObjName: objName, Outcome: outcome, MaskedInput: maskInput(input)})
+}
+
+// 记录校验验证码但不核销, 用户输入经脱敏后保存
+func (r VerificationCodeRdb) historyPeeked(objName string, input string, outcome string) {
+	r.appendHistory(HistoryRecord{Action: HistoryActionPeek, ObjName: objName, Outcome: outcome, MaskedInput: maskInput(input)})
+}
+
+// 记录因违规而拒绝的请求
+func (r VerificationCodeRdb) historyRejected(objName string, it InvalidType) {
+	r.appendHistory(HistoryRecord{Action: HistoryActionReject, ObjName: objName, InvalidType: it})
+}
+
+// 若当日失败次数恰好达到失败次数阈值或某一临时封禁阈值, 返回封禁的附加信息, 否则返回nil
+func (r VerificationCodeRdb) banDetailIfReached(cnt int) map[string]string {
+	if r.strategy.DenyThresholdOfFailedCount > 0 && cnt == r.strategy.DenyThresholdOfFailedCount {
+		return map[string]string{"until": "tomorrow"}
+	}
+
+	if r.strategy.TemporarilyBanStrategy == nil {
+		return nil
+	}
+	if d, ok := r.strategy.TemporarilyBanStrategy.Load(cnt); ok {
+		return map[string]string{"duration": strconv.FormatInt(d.(int64), 10)}
+	}
+	return nil
+}
+
+// 记录封禁
+func (r VerificationCodeRdb) historyBanned(objName string, detail map[string]string) {
+	r.appendHistory(HistoryRecord{Action: HistoryActionBan, ObjName: objName, Detail: detail})
+}
+
+// 记录管理员操作
+func (r VerificationCodeRdb) recordAdminAction(objName string, operation string, detail map[string]string) error {
+	if r.history == nil {
+		return errors.New("history is not enabled")
+	}
+	objName, err := r.normalizeSubject(objName)
+	if err != nil {
+		return err
+	}
+	r.historyAdmin(objName, operation, detail)
+	return nil
+}
+
+// 分页查询对象在[from, to]时间范围内的历史记录(按时间升序排列). 通过对象的索引定位记录, 耗时仅与该对象的记录数相关
+// cursor: 上一页返回的游标, 首页传空字符串. 返回的nextCursor为空时说明已无更多记录
+func (r VerificationCodeRdb) queryHistory(objName string, from time.Time, to time.Time, cursor string, limit int) (records []HistoryRecord, nextCursor string, err error) {
+	if r.history == nil {
+		return nil, "", errors.New("history is not enabled")
+	}
+	if objName, err = r.normalizeSubject(objName); err != nil {
+		return nil, "", err
+	}
+	if limit <= 0 {
+		limit = defaultHistoryPageSize
+	}
+
+	min := "[" + historyIndexMember(unixMilliOrZero(from), 0)
+	max := "[" + historyIndexMember(unixMilliOrZero(to), math.MaxUint64)
+	if cursor != "" {
+		ms, seq, err := parseStreamId(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		min = "(" + historyIndexMember(ms, seq)
+	}
+
+	ctx := context.TODO()
+	index := r.getRedisFieldNameVerificationCodeHistoryIndex(objName)
+	for {
+		count := limit - len(records)
+		members, err := r.rDb.ZRangeByLex(ctx, index, &redis.ZRangeBy{Min: min, Max: max, Count: int64(count)}).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		if len(members) == 0 {
+			return records, "", nil
+		}
+
+		cmds := make([]*redis.XMessageSliceCmd, len(members))
+		if _, err = r.rDb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, member := range members {
+				id := historyIdFromIndexMember(member)
+				cmds[i] = pipe.XRangeN(ctx, r.getRedisFieldNameVerificationCodeHistory(), id, id, 1)
+			}
+			return nil
+		}); err != nil && err != redis.Nil {
+			return nil, "", err
+		}
+
+		// 流中已被淘汰的记录, 顺带从索引中删除
+		var evicted []interface{}
+		for i, cmd := range cmds {
+			msgs := cmd.Val()
+			if len(msgs) == 0 {
+				evicted = append(evicted, members[i])
+				continue
+			}
+			records = append(records, parseHistoryRecord(msgs[0].ID, msgs[0].Values, r.location()))
+		}
+		if len(evicted) > 0 {
+			r.rDb.ZRem(ctx, index, evicted...)
+		}
+
+		if len(records) == limit {
+			return records, records[len(records)-1].Id, nil
+		}
+		// 已读取至时间范围末尾
+		if len(members) < count {
+			return records, "", nil
+		}
+		min = "(" + members[len(members)-1]
+	}
+}
+
+// 将历史记录转换为流的字段, 空值字段不写入
+func formatHistoryRecord(rec HistoryRecord) map[string]interface{} {
+	values := map[string]interface{}{
+		historyFieldAction:  string(rec.Action),
+		historyFieldObjName: rec.ObjName,
+	}
+	for field, v := range map[string]string{
+		historyFieldOutcome:     rec.Outcome,
+		historyFieldMaskedInput: rec.MaskedInput,
+		historyFieldIP:          rec.Metadata.IP,
+		historyFieldScene:       rec.Metadata.Scene,
+		historyFieldUserAgent:   rec.Metadata.UserAgent,
+	} {
+		if v != "" {
+			values[field] = v
+		}
+	}
+	if rec.InvalidType != 0 {
+		values[historyFieldInvalidType] = strconv.Itoa(int(rec.InvalidType))
+	}
+	for k, v := range rec.Metadata.Extra {
+		values[historyFieldMetaExtra+k] = v
+	}
+	for k, v := range rec.Detail {
+		values[historyFieldDetail+k] = v
+	}
+	return values
+}
+
+// 解析流中的历史记录
+func parseHistoryRecord(id string, values map[string]interface{}, loc *time.Location) HistoryRecord {
+	rec := HistoryRecord{Id: id}
+	if ms, err := strconv.ParseInt(strings.SplitN(id, "-", 2)[0], 10, 64); err == nil {
+		rec.Time = time.UnixMilli(ms).In(loc)
+	}
+
+	for field, raw := range values {
+		v, _ := raw.(string)
+		switch {
+		case field == historyFieldAction:
+			rec.Action = HistoryAction(v)
+		case field == historyFieldObjName:
+			rec.ObjName = v
+		case field == historyFieldOutcome:
+			rec.Outcome = v
+		case field == historyFieldMaskedInput:
+			rec.MaskedInput = v
+		case field == historyFieldIP:
+			rec.Metadata.IP = v
+		case field == historyFieldScene:
+			rec.Metadata.Scene = v
+		case field == historyFieldUserAgent:
+			rec.Metadata.UserAgent = v
+		case field == historyFieldInvalidType:
+			it, _ := strconv.Atoi(v)
+			rec.InvalidType = InvalidType(it)
+		case strings.HasPrefix(field, historyFieldMetaExtra):
+			if rec.Metadata.Extra == nil {
+				rec.Metadata.Extra = map[string]string{}
+			}
+			rec.Metadata.Extra[strings.TrimPrefix(field, historyFieldMetaExtra)] = v
+		case strings.HasPrefix(field, historyFieldDetail):
+			if rec.Detail == nil {
+				rec.Detail = map[string]string{}
+			}
+			rec.Detail[strings.TrimPrefix(field, historyFieldDetail)] = v
+		}
+	}
+	return rec
+}
+
+// 解析流ID
+func parseStreamId(id string) (ms uint64, seq uint64, err error) {
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, errors.New("invalid cursor")
+	}
+	if ms, err = strconv.ParseUint(parts[0], 10, 64); err != nil {
+		return 0, 0, errors.New("invalid cursor")
+	}
+	if seq, err = strconv.ParseUint(parts[1], 10, 64); err != nil {
+		return 0, 0, errors.New("invalid cursor")
+	}
+	return ms, seq, nil
+}
+
+// 流ID在对象索引中的成员(两部分分别补零, 使字典序与流ID的顺序一致)
+func historyIndexMember(ms uint64, seq uint64) string {
+	return fmt.Sprintf("%0*d-%0*d", historyIndexIdWidth, ms, historyIndexIdWidth, seq)
+}
+
+// 对象索引中的成员对应的流ID
+func historyIdFromIndexMember(member string) string {
+	ms, seq, err := parseStreamId(member)
+	if err != nil {
+		return member
+	}
+	return strconv.FormatUint(ms, 10) + "-" + strconv.FormatUint(seq, 10)
+}
+
+// 时间对应的毫秒时间戳, 早于1970年时返回0
+func unixMilliOrZero(t time.Time) uint64 {
+	if ms := t.UnixMilli(); ms > 0 {
+		return uint64(ms)
+	}
+	return 0
+}
+
+// 用户输入脱敏: 全部字符均以"*"代替, 仅保留长度
+func maskInput(input string) string {
+	return strings.Repeat("*", utf8.RuneCountInString(input))
+}
+
+// 生成存储业务模块历史记录的流的字段名称
+func (r VerificationCodeRdb) getRedisFieldNameVerificationCodeHistory() string {
+	return r.ModuleName + "VerificationCodeHistory"
+}
+
+// 生成对象的历史记录索引的字段名称
+func (r VerificationCodeRdb) getRedisFieldNameVerificationCodeHistoryIndex(objName string) string {
+	return r.ModuleName + "VerificationCodeHistoryIndex" + objName
+}
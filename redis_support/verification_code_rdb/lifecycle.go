@@ -0,0 +1,140 @@
+package verification_code_rdb
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+// VerificationCodeLifecycleInterface 不核销的比对及延长验证码有效期
+type VerificationCodeLifecycleInterface interface {
+	PeekVerificationCode(objName string, verCode string) (exist bool, success bool, err error)
+	ExtendVerificationCode(objName string, by time.Duration) (exist bool, extended time.Duration, ttl time.Duration, err error)
+	QueryExtendStrategy() ExtendStrategy
+	ModifyExtendStrategy(es ExtendStrategy)
+}
+
+// ErrExtendNotAllowed 策略不允许延长验证码的有效期(ExtendStrategy.MaxExtension为0)
+var ErrExtendNotAllowed = errors.New("extending verification code is not allowed")
+
+// ExtendStrategy 延长验证码有效期的策略
+// 注意: 请求间隔的判断及QueryVerificationCodeRegisteredPeriod均基于验证码的剩余有效期, 延长后二者按延长后的有效期重新计算
+type ExtendStrategy struct {
+	MaxExtension int64 `json:"max_extension"` // 单个验证码可累计延长的最长时长(秒), 即验证码最长存活ValidityDuration+MaxExtension秒. 为0时不允许延长
+}
+
+// 原子地延长验证码的有效期. 累计延长时长记录于KEYS[2](与验证码绑定, 验证码被替换后重新计算)
+// KEYS[1]: 验证码; KEYS[2]: 累计延长时长. ARGV[1]: 申请延长的时长(毫秒), ARGV[3]为1时为申请重置到的剩余有效期(毫秒); ARGV[2]: 累计延长的上限(毫秒)
+// 返回 {验证码是否存在, 实际延长的时长(毫秒), 延长后的剩余有效期(毫秒)}
+var extendScript = redis.NewScript(`
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl < 0 then
+	return {0, 0, 0}
+end
+
+local want = tonumber(ARGV[1])
+if ARGV[3] == '1' then
+	want = want - ttl
+end
+
+local code = redis.call('GET', KEYS[1])
+local stored = redis.call('HMGET', KEYS[2], 'code', 'used')
+local used = 0
+if stored[1] == code and stored[2] then
+	used = tonumber(stored[2])
+end
+
+local grant = math.min(want, tonumber(ARGV[2]) - used)
+if grant <= 0 then
+	return {1, 0, ttl}
+end
+
+local newTtl = ttl + grant
+redis.call('PEXPIRE', KEYS[1], newTtl)
+redis.call('HSET', KEYS[2], 'code', code, 'used', used + grant)
+redis.call('PEXPIRE', KEYS[2], newTtl)
+return {1, grant, newTtl}
+`)
+
+// 校验验证码是否正确但不核销. 校验失败时与核销失败一样计入失败次数
+func (r VerificationCodeRdb) peekVerificationCode(objName string, verCode string) (exist bool, success bool, err error) {
+	return r.peekVerificationCodeWithDigest(objName, verCode, "")
+}
+
+// 校验验证码及交易载荷的摘要是否正确但不核销. digest为空时仅能校验未绑定交易载荷的验证码
+func (r VerificationCodeRdb) peekVerificationCodeWithDigest(objName string, verCode string, digest string) (exist bool, success bool, err error) {
+	if r.isDegraded() {
+		return r.degradedPeek(objName, verCode, digest)
+	}
+
+	exist, code, storedDigest, err := r.getVerificationCodeWithDigest(objName)
+	if r.shouldDegrade(err) {
+		return r.degradedPeek(objName, verCode, digest)
+	}
+	if err != nil {
+		return exist, false, err
+	}
+	if !exist {
+		r.historyPeeked(objName, verCode, HistoryOutcomeNotExist)
+		return false, false, nil
+	}
+
+	codeMatched := r.strategy.CodeComparisonStrategy.Equal(code, verCode)
+	success = codeMatched && digestEqual(storedDigest, digest)
+	switch {
+	case success:
+		r.historyPeeked(objName, verCode, HistoryOutcomeSuccess)
+	case codeMatched:
+		r.historyPeeked(objName, verCode, HistoryOutcomePayloadMismatch)
+		r.verifyFail(objName)
+	default:
+		r.historyPeeked(objName, verCode, HistoryOutcomeFail)
+		r.verifyFail(objName)
+	}
+	return exist, success, nil
+}
+
+// 延长验证码的有效期, 累计延长时长不超过策略的上限
+// exist: 验证码是否存在; extended: 实际延长的时长; ttl: 延长后的剩余有效期
+func (r VerificationCodeRdb) extendVerificationCode(objName string, by time.Duration) (exist bool, extended time.Duration, ttl time.Duration, err error) {
+	if by <= 0 {
+		return false, 0, 0, errors.New("by <= 0")
+	}
+	if r.strategy.ExtendStrategy.MaxExtension <= 0 {
+		return false, 0, 0, ErrExtendNotAllowed
+	}
+
+	return r.runExtendScript(objName, by, false)
+}
+
+// 执行extendScript. reset: 为true时将剩余有效期重置为by(计入累计延长时长), 否则延长by
+func (r VerificationCodeRdb) runExtendScript(objName string, by time.Duration, reset bool) (exist bool, extended time.Duration, ttl time.Duration, err error) {
+	mode := 0
+	if reset {
+		mode = 1
+	}
+	res, err := extendScript.Run(context.TODO(), r.rDb,
+		[]string{r.getRedisFieldNameVerificationCode(objName), r.getRedisFieldNameVerificationCodeExtension(objName)},
+		by.Milliseconds(), r.strategy.ExtendStrategy.MaxExtension*1000, mode,
+	).Int64Slice()
+	if err != nil {
+		return false, 0, 0, err
+	}
+	return res[0] == 1, time.Duration(res[1]) * time.Millisecond, time.Duration(res[2]) * time.Millisecond, nil
+}
+
+// 降级模式下校验本地暂存的验证码但不核销. 本地暂存的验证码均未绑定交易载荷
+func (r VerificationCodeRdb) degradedPeek(objName string, verCode string, digest string) (exist bool, success bool, err error) {
+	if digest != "" || r.degradation.strategy.policyOf(OperationVerifyAndUse) != DegradationPolicyFailOpen {
+		return false, false, ErrRedisUnavailable
+	}
+
+	exist, success = r.degradation.localCodes.peek(r.ModuleName, objName, verCode, r.now(), r.strategy.CodeComparisonStrategy)
+	return exist, success, nil
+}
+
+// 根据对象名称生成存储验证码累计延长时长的字段名称
+func (r VerificationCodeRdb) getRedisFieldNameVerificationCodeExtension(objName string) string {
+	return r.ModuleName + "VerificationCodeExtension" + objName
+}
@@ -0,0 +1,127 @@
+package verification_code_rdb
+
+import (
+	"errors"
+	"github.com/DontBeProud/wow-easy-go/utils/wow_regexp"
+	"strings"
+	"unicode"
+)
+
+// VerificationCodeNormalizerInterface 对象名称的规范化
+type VerificationCodeNormalizerInterface interface {
+	NormalizeSubject(objName string) (string, error)
+	HasSubjectNormalizer() bool
+}
+
+// SubjectNormalizer 对象名称规范化接口. 在生成任何redis字段名称之前调用, 使同一对象的不同写法映射为同一对象名称, 防止借此绕过频率限制
+// 实现必须是幂等的, 即对规范化后的对象名称再次规范化, 结果不变
+type SubjectNormalizer interface {
+	Normalize(objName string) (string, error) // 规范化对象名称, 对象名称不合法时返回error
+}
+
+// SubjectNormalizerFunc 函数形式的SubjectNormalizer
+type SubjectNormalizerFunc func(objName string) (string, error)
+
+// Normalize 规范化对象名称
+func (f SubjectNormalizerFunc) Normalize(objName string) (string, error) {
+	return f(objName)
+}
+
+const (
+	chinaCountryCode = "86" // 中国的国际电话区号
+	e164MaxDigits    = 15   // E.164号码(含国家码)的最大位数
+)
+
+var (
+	// ErrInvalidPhoneNumber 手机号码不合法
+	ErrInvalidPhoneNumber = errors.New("invalid phone number")
+	// ErrInvalidEmail 邮箱地址不合法
+	ErrInvalidEmail = errors.New("invalid email")
+
+	chineseMobilePhoneRegExp, _ = wow_regexp.DefaultExprChineseMobilePhone.CreateRegExp()
+	emailRegExp, _              = wow_regexp.DefaultExprEmail.CreateRegExp()
+)
+
+// PhoneNumberNormalizer 手机号码规范化, 统一转换为E.164格式(例如 +8613800138000)
+// 支持去除空格、连字符、括号等分隔符, 支持"+"及"00"国际前缀. 中国大陆号码使用 wow_regexp.DefaultExprChineseMobilePhone 校验
+type PhoneNumberNormalizer struct {
+	DefaultCountryCode string // 号码未携带国际前缀时使用的国家码(不含"+"), 为空时默认为"86"
+}
+
+// Normalize 将手机号码规范化为E.164格式
+func (n PhoneNumberNormalizer) Normalize(objName string) (string, error) {
+	countryCode := n.DefaultCountryCode
+	if countryCode == "" {
+		countryCode = chinaCountryCode
+	}
+
+	raw := strings.TrimSpace(objName)
+	international := strings.HasPrefix(raw, "+")
+
+	var digits strings.Builder
+	for _, r := range raw {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r >= '０' && r <= '９': // 全角数字
+			digits.WriteRune(r - '０' + '0')
+		case unicode.IsSpace(r) || strings.ContainsRune("+-().", r):
+			continue
+		default:
+			return "", ErrInvalidPhoneNumber
+		}
+	}
+
+	number := digits.String()
+	if !international && strings.HasPrefix(number, "00") {
+		international, number = true, strings.TrimPrefix(number, "00")
+	}
+
+	// 未携带国际前缀: 中国大陆号码可能直接以"86"开头, 其余情况补全默认国家码
+	if !international {
+		if countryCode == chinaCountryCode && len(number) == 13 && strings.HasPrefix(number, chinaCountryCode) {
+			number = number[len(chinaCountryCode):]
+		}
+		number = countryCode + number
+	}
+
+	if len(number) > e164MaxDigits || len(number) <= len(countryCode) {
+		return "", ErrInvalidPhoneNumber
+	}
+
+	if strings.HasPrefix(number, chinaCountryCode) && !chineseMobilePhoneRegExp.MatchString(number[len(chinaCountryCode):]) {
+		return "", ErrInvalidPhoneNumber
+	}
+	return "+" + number, nil
+}
+
+// EmailNormalizer 邮箱地址规范化: 去除首尾空白并转换为小写, 使用 wow_regexp.DefaultExprEmail 校验
+type EmailNormalizer struct {
+	FoldGmail bool // 是否折叠Gmail地址: 忽略用户名中的"."以及"+"之后的后缀, googlemail.com统一为gmail.com
+}
+
+// Normalize 规范化邮箱地址
+func (n EmailNormalizer) Normalize(objName string) (string, error) {
+	email := strings.ToLower(strings.TrimSpace(objName))
+	if !emailRegExp.MatchString(email) {
+		return "", ErrInvalidEmail
+	}
+
+	at := strings.LastIndex(email, "@")
+	local, domain := email[:at], email[at+1:]
+	if n.FoldGmail && (domain == "gmail.com" || domain == "googlemail.com") {
+		if plus := strings.Index(local, "+"); plus >= 0 {
+			local = local[:plus]
+		}
+		local, domain = strings.ReplaceAll(local, ".", ""), "gmail.com"
+	}
+	return local + "@" + domain, nil
+}
+
+// 规范化对象名称, 未配置规范化接口时原样返回
+func (r VerificationCodeRdb) normalizeSubject(objName string) (string, error) {
+	if r.normalizer == nil {
+		return objName, nil
+	}
+	return r.normalizer.Normalize(objName)
+}
@@ -0,0 +1,235 @@
+package verification_code_rdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// VerificationCodeOverrideInterface 按对象覆盖策略
+type VerificationCodeOverrideInterface interface {
+	SetStrategyOverride(objName string, override StrategyOverride, ttl time.Duration) error
+	ClearStrategyOverride(objName string) (existed bool, err error)
+	QueryStrategyOverride(objName string) (exist bool, override SubjectStrategyOverride, err error)
+	ListStrategyOverrides() ([]SubjectStrategyOverride, error)
+	QueryEffectiveStrategy(objName string) (StrategySnapshot, error)
+}
+
+// 管理员操作的名称(按对象覆盖策略)
+const (
+	AdminOperationSetStrategyOverride   = "set_strategy_override"
+	AdminOperationClearStrategyOverride = "clear_strategy_override"
+)
+
+// StrategyOverride 按对象覆盖的策略项, 为nil的字段沿用业务模块的策略
+type StrategyOverride struct {
+	ValidityDuration             *int64                  `json:"validity_duration,omitempty"`
+	RequestTimeIntervalThreshold *int64                  `json:"request_time_interval_threshold,omitempty"`
+	DenyThresholdOfUnusedCode    *int                    `json:"deny_threshold_of_unused_code,omitempty"`
+	DenyThresholdOfFailedCount   *int                    `json:"deny_threshold_of_failed_count,omitempty"`
+	TemporarilyBanStrategy       *map[int]int64          `json:"temporarily_ban_strategy,omitempty"` // 整体替换短暂封禁策略, 空map表示不进行短暂封禁
+	CodeComparisonStrategy       *CodeComparisonStrategy `json:"code_comparison_strategy,omitempty"`
+	ResendStrategy               *ResendStrategy         `json:"resend_strategy,omitempty"`
+	ExtendStrategy               *ExtendStrategy         `json:"extend_strategy,omitempty"`
+}
+
+// SubjectStrategyOverride 对象的策略覆盖项
+type SubjectStrategyOverride struct {
+	ObjName  string           `json:"obj_name"`  // 对象名称(规范化后)
+	Override StrategyOverride `json:"override"`  // 覆盖的策略项
+	ExpireAt time.Time        `json:"expire_at"` // 覆盖项的过期时间
+}
+
+// Apply 将覆盖项合并至策略, 返回合并后的策略副本, 不修改原策略
+func (o StrategyOverride) Apply(s VerificationCodeServiceStrategy) VerificationCodeServiceStrategy {
+	if o.ValidityDuration != nil {
+		s.ValidityDuration = *o.ValidityDuration
+	}
+	if o.RequestTimeIntervalThreshold != nil {
+		s.RequestTimeIntervalThreshold = *o.RequestTimeIntervalThreshold
+	}
+	if o.DenyThresholdOfUnusedCode != nil {
+		s.DenyThresholdOfUnusedCode = *o.DenyThresholdOfUnusedCode
+	}
+	if o.DenyThresholdOfFailedCount != nil {
+		s.DenyThresholdOfFailedCount = *o.DenyThresholdOfFailedCount
+	}
+	if o.TemporarilyBanStrategy != nil {
+		s.TemporarilyBanStrategy = &sync.Map{}
+		for threshold, duration := range *o.TemporarilyBanStrategy {
+			s.TemporarilyBanStrategy.Store(threshold, duration)
+		}
+	}
+	if o.CodeComparisonStrategy != nil {
+		s.CodeComparisonStrategy = *o.CodeComparisonStrategy
+	}
+	if o.ResendStrategy != nil {
+		s.ResendStrategy = *o.ResendStrategy
+	}
+	if o.ExtendStrategy != nil {
+		s.ExtendStrategy = *o.ExtendStrategy
+	}
+	return s
+}
+
+// 校验覆盖项是否合法
+func (o StrategyOverride) validate() error {
+	if o.ValidityDuration != nil && *o.ValidityDuration <= 0 {
+		return errors.New("StrategyOverride ValidityDuration <= 0")
+	}
+	return nil
+}
+
+// 设置对象的策略覆盖项, ttl到期后自动失效
+func (r VerificationCodeRdb) setStrategyOverride(objName string, override StrategyOverride, ttl time.Duration) error {
+	if ttl <= 0 {
+		return errors.New("ttl <= 0")
+	}
+	if err := override.validate(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(override)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.TODO()
+	expireAt := r.now().Add(ttl)
+	if _, err = r.rDb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, r.getRedisFieldNameVerificationCodeStrategyOverride(objName), data, ttl)
+		pipe.ZAdd(ctx, r.getRedisFieldNameVerificationCodeOverrideIndex(), &redis.Z{Score: float64(expireAt.UnixMilli()), Member: objName})
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	r.historyAdmin(objName, AdminOperationSetStrategyOverride, map[string]string{
+		"override":  string(data),
+		"expire_at": strconv.FormatInt(expireAt.Unix(), 10),
+	})
+	return nil
+}
+
+// 清除对象的策略覆盖项. existed: 清除前是否存在
+func (r VerificationCodeRdb) clearStrategyOverride(objName string) (existed bool, err error) {
+	ctx := context.TODO()
+	var del *redis.IntCmd
+	if _, err = r.rDb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		del = pipe.Del(ctx, r.getRedisFieldNameVerificationCodeStrategyOverride(objName))
+		pipe.ZRem(ctx, r.getRedisFieldNameVerificationCodeOverrideIndex(), objName)
+		return nil
+	}); err != nil {
+		return false, err
+	}
+
+	existed = del.Val() > 0
+	if existed {
+		r.historyAdmin(objName, AdminOperationClearStrategyOverride, nil)
+	}
+	return existed, nil
+}
+
+// 查询对象的策略覆盖项
+func (r VerificationCodeRdb) queryStrategyOverride(objName string) (exist bool, res SubjectStrategyOverride, err error) {
+	list, err := r.queryStrategyOverrides([]string{objName})
+	if err != nil || len(list) == 0 {
+		return false, SubjectStrategyOverride{ObjName: objName}, err
+	}
+	return true, list[0], nil
+}
+
+// 列出业务模块全部未过期的策略覆盖项(按过期时间升序排列), 同时清理索引中已过期的对象
+func (r VerificationCodeRdb) listStrategyOverrides() ([]SubjectStrategyOverride, error) {
+	ctx := context.TODO()
+	index := r.getRedisFieldNameVerificationCodeOverrideIndex()
+	now := strconv.FormatInt(r.now().UnixMilli(), 10)
+
+	if err := r.rDb.ZRemRangeByScore(ctx, index, "-inf", "("+now).Err(); err != nil {
+		return nil, err
+	}
+	objNames, err := r.rDb.ZRangeByScore(ctx, index, &redis.ZRangeBy{Min: now, Max: "+inf"}).Result()
+	if err != nil {
+		return nil, err
+	}
+	return r.queryStrategyOverrides(objNames)
+}
+
+// 批量查询对象的策略覆盖项, 不存在覆盖项的对象不出现在结果中
+func (r VerificationCodeRdb) queryStrategyOverrides(objNames []string) ([]SubjectStrategyOverride, error) {
+	if len(objNames) == 0 {
+		return nil, nil
+	}
+
+	ctx := context.TODO()
+	dataCmds := make([]*redis.StringCmd, len(objNames))
+	ttlCmds := make([]*redis.DurationCmd, len(objNames))
+	if _, err := r.rDb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, objName := range objNames {
+			dataCmds[i] = pipe.Get(ctx, r.getRedisFieldNameVerificationCodeStrategyOverride(objName))
+			ttlCmds[i] = pipe.PTTL(ctx, r.getRedisFieldNameVerificationCodeStrategyOverride(objName))
+		}
+		return nil
+	}); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	var res []SubjectStrategyOverride
+	for i, objName := range objNames {
+		data, err := dataCmds[i].Bytes()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		item := SubjectStrategyOverride{ObjName: objName}
+		if err = json.Unmarshal(data, &item.Override); err != nil {
+			return nil, err
+		}
+		if ttl := ttlCmds[i].Val(); ttl > 0 {
+			item.ExpireAt = r.now().Add(ttl)
+		}
+		res = append(res, item)
+	}
+	return res, nil
+}
+
+// 返回应用了对象策略覆盖项的副本. 未开启按对象覆盖策略、处于降级模式、不存在覆盖项或查询失败时返回原对象
+// 查询失败时沿用业务模块的策略, 由后续的redis操作决定是否报错或降级
+func (r VerificationCodeRdb) withSubjectStrategy(objName string) VerificationCodeRdb {
+	if !r.strategyOverride || r.isDegraded() {
+		return r
+	}
+
+	exist, item, err := r.queryStrategyOverride(objName)
+	if err != nil || !exist {
+		return r
+	}
+	r.strategy = item.Override.Apply(r.strategy)
+	return r
+}
+
+// 规范化对象名称, 并返回应用了对象策略覆盖项的副本
+func (r VerificationCodeRdb) prepareSubject(objName string) (VerificationCodeRdb, string, error) {
+	objName, err := r.normalizeSubject(objName)
+	if err != nil {
+		return r, objName, err
+	}
+	return r.withSubjectStrategy(objName), objName, nil
+}
+
+// 根据对象名称生成存储策略覆盖项的字段名称
+func (r VerificationCodeRdb) getRedisFieldNameVerificationCodeStrategyOverride(objName string) string {
+	return r.ModuleName + "VerificationCodeStrategyOverride" + objName
+}
+
+// 生成存储业务模块全部策略覆盖项索引(有序集合, score为过期时间的unix毫秒数)的字段名称
+func (r VerificationCodeRdb) getRedisFieldNameVerificationCodeOverrideIndex() string {
+	return r.ModuleName + "VerificationCodeOverrideIndex"
+}
@@ -0,0 +1,77 @@
+package verification_code_rdb
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// VerificationCodePayloadInterface 绑定交易内容摘要的验证码
+type VerificationCodePayloadInterface interface {
+	SetAndRegisterVerificationCodeWithPayload(objName string, verCode string, payload []byte) error
+	VerifyAndUseVerificationCodeWithPayload(objName string, verCode string, payload []byte) (exist bool, success bool, err error)
+	PeekVerificationCodeWithPayload(objName string, verCode string, payload []byte) (exist bool, success bool, err error)
+}
+
+// 存储的验证码与交易载荷摘要之间的分隔符. 验证码本身不应包含该字符
+const payloadDigestSeparator = "\x1f"
+
+// ErrEmptyPayload 交易载荷为空
+var ErrEmptyPayload = errors.New("payload is empty")
+
+// 添加并记录绑定交易载荷的验证码. 降级模式下不支持绑定交易载荷, 直接返回ErrRedisUnavailable
+func (r VerificationCodeRdb) setAndRegisterVerificationCodeWithPayload(objName string, verCode string, payload []byte, expireNanoDuration time.Duration) error {
+	if len(payload) == 0 {
+		return ErrEmptyPayload
+	}
+	if r.isDegraded() {
+		return ErrRedisUnavailable
+	}
+	return r.registerVerificationCodeWithDigest(objName, verCode, payloadDigest(payload), expireNanoDuration)
+}
+
+// 核销绑定交易载荷的验证码
+func (r VerificationCodeRdb) verifyAndUseVerificationCodeWithPayload(objName string, verCode string, payload []byte) (exist bool, success bool, err error) {
+	if len(payload) == 0 {
+		return false, false, ErrEmptyPayload
+	}
+	return r.verifyAndUseVerificationCodeWithDigest(objName, verCode, payloadDigest(payload))
+}
+
+// 校验绑定交易载荷的验证码但不核销
+func (r VerificationCodeRdb) peekVerificationCodeWithPayload(objName string, verCode string, payload []byte) (exist bool, success bool, err error) {
+	if len(payload) == 0 {
+		return false, false, ErrEmptyPayload
+	}
+	return r.peekVerificationCodeWithDigest(objName, verCode, payloadDigest(payload))
+}
+
+// 计算交易载荷的摘要(SHA-256, 十六进制)
+func payloadDigest(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// 恒定时间比较两个摘要
+func digestEqual(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// 将验证码与交易载荷的摘要编码为存储的值. digest为空时仅存储验证码, 与未绑定交易载荷的验证码格式一致
+func encodeStoredCode(verCode string, digest string) string {
+	if digest == "" {
+		return verCode
+	}
+	return verCode + payloadDigestSeparator + digest
+}
+
+// 从存储的值中解析验证码与交易载荷的摘要
+func decodeStoredCode(stored string) (verCode string, digest string) {
+	if i := strings.LastIndex(stored, payloadDigestSeparator); i >= 0 {
+		return stored[:i], stored[i+len(payloadDigestSeparator):]
+	}
+	return stored, ""
+}
@@ -6,87 +6,137 @@ import (
 	"github.com/DontBeProud/wow-easy-go/redis_support/base"
 	"github.com/DontBeProud/wow-easy-go/utils/wow_time"
 	"github.com/go-redis/redis/v8"
+	"strconv"
 	"sync"
 	"time"
 )
 
-// 添加并记录验证码(添加该用户的验证码缓存，并且向该用户未核销的验证码集合中添加该验证码)
+// 原子地核销验证码: 验证码仍为比对时读取到的值时, 删除验证码并从未核销的验证码集合中移除
+// KEYS[1]: 验证码; KEYS[2]: 当日未核销的验证码集合. ARGV[1]: 比对时读取到的原始值; ARGV[2]: 验证码
+// 返回1表示核销成功, 0表示验证码已不存在或已被替换
+var verifyAndUseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+redis.call('DEL', KEYS[1])
+redis.call('SREM', KEYS[2], ARGV[2])
+return 1
+`)
+
+// 添加并记录验证码(redis不可用时按降级策略处理)
 func (r VerificationCodeRdb) setAndRegisterVerificationCode(objName string, verCode string, expireNanoDuration time.Duration) error {
-	if err := r.setVerificationCode(objName, verCode, expireNanoDuration); err != nil {
+	if r.isDegraded() {
+		return r.degradedSetAndRegister(objName, verCode, expireNanoDuration)
+	}
+
+	err := r.registerVerificationCode(objName, verCode, expireNanoDuration)
+	if r.shouldDegrade(err) {
+		return r.degradedSetAndRegister(objName, verCode, expireNanoDuration)
+	}
+	return err
+}
+
+// 添加并记录验证码(添加该用户的验证码缓存，并且向该用户未核销的验证码集合中添加该验证码)
+func (r VerificationCodeRdb) registerVerificationCode(objName string, verCode string, expireNanoDuration time.Duration) error {
+	return r.registerVerificationCodeWithDigest(objName, verCode, "", expireNanoDuration)
+}
+
+// 添加并记录验证码, 交易载荷的摘要与验证码一同保存. digest为空时验证码不绑定交易载荷
+func (r VerificationCodeRdb) registerVerificationCodeWithDigest(objName string, verCode string, digest string, expireNanoDuration time.Duration) error {
+	if err := r.setVerificationCode(objName, encodeStoredCode(verCode, digest), expireNanoDuration); err != nil {
 		return err
 	}
 	r.addUnusedVerificationCode(objName, verCode)
+	r.recordIssued(objName)
+	r.historyIssued(objName)
 	return nil
 }
 
 // 核销验证码
 func (r VerificationCodeRdb) verifyAndUseVerificationCode(objName string, verCode string) (exist bool, success bool, err error) {
-	exist, code, err := r.getVerificationCode(objName)
-	if err != nil || !exist {
-		// 执行出错或验证码不存在
+	return r.verifyAndUseVerificationCodeWithDigest(objName, verCode, "")
+}
+
+// 核销验证码, 验证码与交易载荷的摘要均一致时才核销成功. digest为空时仅能核销未绑定交易载荷的验证码
+func (r VerificationCodeRdb) verifyAndUseVerificationCodeWithDigest(objName string, verCode string, digest string) (exist bool, success bool, err error) {
+	if r.isDegraded() {
+		return r.degradedVerifyAndUseWithDigest(objName, verCode, digest)
+	}
+
+	exist, stored, err := r.getStoredVerificationCode(objName)
+	if r.shouldDegrade(err) {
+		return r.degradedVerifyAndUseWithDigest(objName, verCode, digest)
+	}
+	if err != nil {
 		return exist, false, err
 	}
+	if !exist {
+		r.historyVerified(objName, verCode, HistoryOutcomeNotExist)
+		return false, false, nil
+	}
 
-	success = code == verCode
-	if success {
-		r.verifySuccess(objName, verCode)
-	} else {
+	code, storedDigest := decodeStoredCode(stored)
+	codeMatched := r.strategy.CodeComparisonStrategy.Equal(code, verCode)
+	success = codeMatched && digestEqual(storedDigest, digest)
+	switch {
+	case success:
+		// 用户输入可能与存储的验证码存在格式差异, 需按存储的验证码进行核销
+		used, err := r.verifySuccess(objName, stored, code)
+		if err != nil {
+			return exist, false, err
+		}
+		if !used {
+			// 比对期间验证码已被并发的请求核销或被替换
+			r.historyVerified(objName, verCode, HistoryOutcomeNotExist)
+			return false, false, nil
+		}
+		r.historyVerified(objName, verCode, HistoryOutcomeSuccess)
+	case codeMatched:
+		r.historyVerified(objName, verCode, HistoryOutcomePayloadMismatch)
+		r.verifyFail(objName)
+	default:
+		r.historyVerified(objName, verCode, HistoryOutcomeFail)
 		r.verifyFail(objName)
 	}
 
 	return exist, success, nil
 }
 
-// 发送验证码前的校验(组合校验用户当前状态是否合法)
-// 校验请求是否过于频繁、验证错误次数是否过多、未核销的验证码是否过多(是否频繁请求验证码但不进行验证)
+// 发送验证码前的校验(按顺序执行发送前的规则链)
+// 默认校验请求是否过于频繁、验证错误次数是否过多、未核销的验证码是否过多(是否频繁请求验证码但不进行验证)
 func (r VerificationCodeRdb) preCheckBeforeSendVerificationCode(objName string) (it InvalidType, err error) {
-	return r.combineCheckIsUserValid(objName, map[InvalidType]func(string) (bool, error){
-		InvalidTypeRequestTooFrequently:    r.CheckIsRequestTooFrequently,
-		InvalidTypeVerifyFailTooFrequently: r.CheckIsVerifyFailTooFrequently,
-		InvalidTypeUnusedCodeTooMany:       r.CheckIsUnusedCodeTooMany,
-	})
+	return r.combineCheckIsUserValidWithDegradation(OperationPreCheckBeforeSend, objName, nil)
 }
 
-// 核销验证码前的校验(组合校验用户当前状态是否合法)
-// 校验验证错误次数是否过多、未核销的验证码是否过多(是否频繁请求验证码但不进行验证)
+// 核销验证码前的校验(按顺序执行核销前的规则链)
+// 默认校验验证错误次数是否过多、未核销的验证码是否过多(是否频繁请求验证码但不进行验证)
 func (r VerificationCodeRdb) preCheckBeforeVerifyAndUseVerificationCode(objName string) (it InvalidType, err error) {
-	return r.combineCheckIsUserValid(objName, map[InvalidType]func(string) (bool, error){
-		InvalidTypeVerifyFailTooFrequently: r.CheckIsVerifyFailTooFrequently,
-		InvalidTypeUnusedCodeTooMany:       r.CheckIsUnusedCodeTooMany,
-	})
+	return r.combineCheckIsUserValidWithDegradation(OperationPreCheckBeforeVerify, objName, nil)
 }
 
-// 组合校验用户当前状态是否合法
-// 支持传入	CheckIsRequestTooFrequently/CheckIsVerifyFailTooFrequently/CheckIsUnusedCodeTooMany
-func (r VerificationCodeRdb) combineCheckIsUserValid(objName string, fnList map[InvalidType]func(string) (bool, error)) (it InvalidType, err error) {
-
-	type fnRes struct {
-		invalid bool
-		err     error
-		it      InvalidType
+// 组合校验用户当前状态是否合法(redis不可用时按降级策略处理). skip: 需跳过的规则名称
+func (r VerificationCodeRdb) combineCheckIsUserValidWithDegradation(op DegradableOperation, objName string, skip map[string]bool) (it InvalidType, err error) {
+	if r.isDegraded() {
+		return r.degradedPreCheck(op, objName)
 	}
-	resChan := make(chan fnRes)
 
-	fnGo := func(it InvalidType, fn func(string) (bool, error)) {
-		iv, er := fn(objName)
-		resChan <- fnRes{
-			invalid: iv,
-			err:     er,
-			it:      it,
+	it, err = r.evaluateRules(op, objName, skip)
+	if err == nil && it == UserIsValid && op == OperationPreCheckBeforeSend {
+		// 对象状态合法后再占用发送预算, 避免被拒绝的请求消耗预算
+		var allowed bool
+		if allowed, err = r.reserveBudget(); err == nil && !allowed {
+			it = InvalidTypeBudgetExhausted
 		}
 	}
-
-	for it, fn := range fnList {
-		go fnGo(it, fn)
+	if r.shouldDegrade(err) {
+		return r.degradedPreCheck(op, objName)
 	}
-
-	for i, l := 0, len(fnList); i < l; i++ {
-		res := <-resChan
-		if res.err != nil || res.invalid {
-			return res.it, err
-		}
+	if err == nil && it != UserIsValid {
+		r.recordRejected(it)
+		r.historyRejected(objName, it)
+		r.emitEvent(EventTypeSubjectRejected, objName, map[string]string{"invalid_type": strconv.Itoa(int(it))})
 	}
-	return UserIsValid, nil
+	return it, err
 }
 
 // 设置验证码
@@ -97,49 +147,94 @@ func (r VerificationCodeRdb) setVerificationCode(objName string, verCode string,
 
 // 查询用户的验证码 exist: 验证码是否存在 code: 验证码内容
 func (r VerificationCodeRdb) getVerificationCode(objName string) (exist bool, code string, err error) {
-	result, err := r.rDb.Get(context.TODO(), r.getRedisFieldNameVerificationCode(objName)).Result()
+	exist, code, _, err = r.getVerificationCodeWithDigest(objName)
+	return exist, code, err
+}
+
+// 查询用户的验证码及其绑定的交易载荷的摘要(未绑定时为空)
+func (r VerificationCodeRdb) getVerificationCodeWithDigest(objName string) (exist bool, code string, digest string, err error) {
+	exist, stored, err := r.getStoredVerificationCode(objName)
+	code, digest = decodeStoredCode(stored)
+	return exist, code, digest, err
+}
+
+// 查询用户的验证码在redis中存储的原始值
+func (r VerificationCodeRdb) getStoredVerificationCode(objName string) (exist bool, stored string, err error) {
+	stored, err = r.rDb.Get(context.TODO(), r.getRedisFieldNameVerificationCode(objName)).Result()
 	if err == redis.Nil {
-		return false, result, nil
+		return false, stored, nil
 	}
-	return err == nil, result, err
+	return err == nil, stored, err
 }
 
 // 核销验证码失败(失败次数+1，更新最后一次失败的时间)
 func (r VerificationCodeRdb) verifyFail(objName string) {
-	r.increaseErrorCount(objName)
+	cnt := r.increaseErrorCount(objName)
 	r.updateLastErrorTime(objName)
+	r.recordFailed()
+	if detail := r.banDetailIfReached(cnt); detail != nil {
+		r.historyBanned(objName, detail)
+		r.emitEvent(EventTypeSubjectBanned, objName, detail)
+	}
+	if r.isRiskThreshold(cnt) {
+		r.emitEvent(EventTypeSubjectRiskThreshold, objName, map[string]string{"failed_count": strconv.Itoa(cnt)})
+	}
 }
 
 // 核销验证码成功(删除该用户的验证码缓存，并且从该用户未核销的验证码集合中删除该验证码)
-func (r VerificationCodeRdb) verifySuccess(objName string, verCode string) {
-	r.rDb.Del(context.TODO(), r.getRedisFieldNameVerificationCode(objName))
-	r.rDb.SRem(context.TODO(), r.getRedisFieldNameVerificationCodeSet(objName), verCode)
+// stored为比对时读取到的原始值, 仅当验证码仍为该值时才删除, 保证同一验证码只能被核销一次. used: 是否由本次调用完成核销
+func (r VerificationCodeRdb) verifySuccess(objName string, stored string, verCode string) (used bool, err error) {
+	script := verifyAndUseScript
+	keys := []string{
+		r.getRedisFieldNameVerificationCode(objName),
+		r.getRedisFieldNameVerificationCodeSet(objName),
+	}
+	args := []interface{}{stored, verCode}
+	if r.deviceGrant != nil {
+		// 在核销验证码的同一脚本中签发设备令牌
+		dKeys, dArgs := r.deviceTokenKeysAndArgs(objName, *r.deviceGrant)
+		script, keys, args = verifyAndIssueDeviceTokenScript, append(keys, dKeys...), append(args, dArgs...)
+	}
+
+	res, err := script.Run(context.TODO(), r.rDb, keys, args...).Int()
+	if err != nil || res == 0 {
+		return false, err
+	}
+	r.recordVerified()
+	return true, nil
 }
 
 // 将验证码加入到该用户当日待核销的验证码集合中
 func (r VerificationCodeRdb) addUnusedVerificationCode(objName string, verCode string) {
 	f := r.getRedisFieldNameVerificationCodeSet(objName)
 	r.rDb.SAdd(context.TODO(), f, verCode)
-	r.rDb.ExpireAt(context.TODO(), f, wow_time.GetTomorrowZeroTime()) // 设置有效期到第二天的零时
+	r.rDb.ExpireAt(context.TODO(), f, r.tomorrowZeroTime()) // 设置有效期到第二天的零时
 }
 
 // 更新该用户当日最后一次验证错误的时间
 func (r VerificationCodeRdb) updateLastErrorTime(objName string) {
 	fl := r.getRedisFieldNameVerificationCodeLastFailedTime(objName)
-	r.rDb.Set(context.TODO(), fl, time.Now().Unix(), time.Duration(wow_time.GetTomorrowZeroTime().UnixNano()-time.Now().UnixNano())) // 设置有效期到第二天的零时
+	now := r.now()
+	r.rDb.Set(context.TODO(), fl, now.Unix(), r.tomorrowZeroTime().Sub(now)) // 设置有效期到第二天的零时
 }
 
-// 该用户当日累计错误次数 +1
-func (r VerificationCodeRdb) increaseErrorCount(objName string) {
+// 该用户当日累计错误次数 +1, 返回累加后的次数
+func (r VerificationCodeRdb) increaseErrorCount(objName string) int {
 	fc := r.getRedisFieldNameVerificationCodeErrorCount(objName)
-	r.rDb.Incr(context.TODO(), fc)
-	r.rDb.ExpireAt(context.TODO(), fc, wow_time.GetTomorrowZeroTime()) // 设置有效期到第二天的零时
+	cnt := r.rDb.Incr(context.TODO(), fc).Val()
+	r.rDb.ExpireAt(context.TODO(), fc, r.tomorrowZeroTime()) // 设置有效期到第二天的零时
+	return int(cnt)
 }
 
 // 判断申请验证码是否过于频繁, 若上一次请求的验证码尚未被核销，且生命周期尚未结束，则返回true. threshold: 阈值(单位为秒)
 func (r VerificationCodeRdb) checkIsRequestTooFrequently(objName string, threshold int64) (bool, error) {
 	ttl, err := r.queryVerificationCodeTTL(objName)
-	return err == nil && ttl > 0 && (r.strategy.ValidityDuration-ttl) <= threshold, err
+	return err == nil && judgeIsRequestTooFrequently(ttl, r.strategy.ValidityDuration, threshold), err
+}
+
+// 根据验证码剩余有效时长判断申请验证码是否过于频繁
+func judgeIsRequestTooFrequently(ttl int64, validityDuration int64, threshold int64) bool {
+	return ttl > 0 && (validityDuration-ttl) <= threshold
 }
 
 // 判断当日未使用的验证码是否过多(用于防止恶意刷接口) threshold: 阈值
@@ -149,19 +244,30 @@ func (r VerificationCodeRdb) checkIsUnusedCodeTooMany(objName string, threshold
 		return false, err
 	}
 
-	// 低于阈值
-	if cnt < threshold {
-		return false, nil
+	// cnt == threshold 时需进一步判断是否仍有未使用的验证码
+	exist := false
+	if threshold > 0 && cnt == threshold {
+		if exist, _, err = r.getVerificationCode(objName); err != nil {
+			return false, err
+		}
+	}
+	return judgeIsUnusedCodeTooMany(cnt, threshold, exist), nil
+}
+
+// 根据当日未核销的验证码数量判断未使用的验证码是否过多. codeExist: 当前是否仍有未使用的验证码(仅在cnt == threshold时有意义)
+func judgeIsUnusedCodeTooMany(cnt int, threshold int, codeExist bool) bool {
+	// 无该项限制或低于阈值
+	if threshold <= 0 || cnt < threshold {
+		return false
 	}
 
 	// 高于阈值
-	if cnt > threshold && threshold > 0 {
-		return true, nil
+	if cnt > threshold {
+		return true
 	}
 
 	// cnt == threshold 判断是否仍有未使用的验证码
-	exist, _, err := r.getVerificationCode(objName)
-	return exist, err
+	return codeExist
 }
 
 // 获取验证码的剩余有效时长
@@ -197,28 +303,95 @@ func (r VerificationCodeRdb) checkIsVerifyFailTooFrequently(objName string, thre
 		return false, err
 	}
 
+	// 未达到失败次数阈值且存在暂时封禁策略时, 才需要查询最后一次失败的时间
+	exist, lastErrTime := false, time.Time{}
+	if (threshold <= 0 || cnt < threshold) && temporarilyBanStrategy != nil {
+		if exist, lastErrTime, err = r.queryLastErrorTime(objName); err != nil {
+			return false, err
+		}
+	}
+	return judgeIsVerifyFailTooFrequently(cnt, threshold, exist, lastErrTime, r.now(), temporarilyBanStrategy), nil
+}
+
+// 根据当日失败次数与最后一次失败的时间判断用户是否验证错误过于频繁
+func judgeIsVerifyFailTooFrequently(cnt int, threshold int, lastErrExist bool, lastErrTime time.Time, now time.Time, temporarilyBanStrategy *sync.Map) bool {
+	if cnt == 0 {
+		return false
+	}
+
 	// 高于失败次数阈值
 	if threshold > 0 && cnt >= threshold {
-		return true, nil
+		return true
 	}
 
 	// 无暂时封禁策略
-	if temporarilyBanStrategy == nil {
-		return false, nil
-	}
-
-	exist, lastErrTime, err := r.queryLastErrorTime(objName)
-	if err != nil || !exist {
-		return false, err
+	if temporarilyBanStrategy == nil || !lastErrExist {
+		return false
 	}
+	return judgeIsTemporarilyBanned(cnt, lastErrTime, now, temporarilyBanStrategy)
+}
 
+// 根据当日失败次数与最后一次失败的时间判断当前时刻是否仍处于临时封禁状态
+func judgeIsTemporarilyBanned(cnt int, lastErrTime time.Time, now time.Time, temporarilyBanStrategy *sync.Map) bool {
 	invalid := false
 	temporarilyBanStrategy.Range(func(t, banDuration interface{}) bool {
 		// 错误次数高于判定阈值，同时当前时间距离最后一次验证错误的时间差小于设定的时间范围，则判定当前时刻仍处于封禁状态
-		invalid = cnt >= t.(int) && (time.Now().Unix()-lastErrTime.Unix()) <= banDuration.(int64)
+		invalid = cnt >= t.(int) && (now.Unix()-lastErrTime.Unix()) <= banDuration.(int64)
 		return invalid == false
 	})
-	return invalid, nil
+	return invalid
+}
+
+// 查询对象因违规类型it被拒绝后, 距离可再次请求的剩余时长
+func (r VerificationCodeRdb) queryRetryAfter(objName string, it InvalidType) (time.Duration, error) {
+	now := r.now()
+	untilTomorrow := r.tomorrowZeroTime().Sub(now)
+
+	switch it {
+	case InvalidTypeRequestTooFrequently:
+		ttl, err := r.queryVerificationCodeTTL(objName)
+		if err != nil || ttl <= 0 {
+			return 0, err
+		}
+		remain := r.strategy.RequestTimeIntervalThreshold - (r.strategy.ValidityDuration - ttl) + 1
+		if remain <= 0 {
+			return 0, nil
+		}
+		return time.Duration(remain) * time.Second, nil
+	case InvalidTypeVerifyFailTooFrequently:
+		cnt, err := r.queryErrorsCountToday(objName)
+		if err != nil || cnt == 0 {
+			return 0, err
+		}
+		if r.strategy.DenyThresholdOfFailedCount > 0 && cnt >= r.strategy.DenyThresholdOfFailedCount {
+			return untilTomorrow, nil
+		}
+		exist, lastErrTime, err := r.queryLastErrorTime(objName)
+		if err != nil || !exist || r.strategy.TemporarilyBanStrategy == nil {
+			return 0, err
+		}
+		return judgeTemporarilyBanRemain(cnt, lastErrTime, now, r.strategy.TemporarilyBanStrategy), nil
+	case InvalidTypeUnusedCodeTooMany:
+		return untilTomorrow, nil
+	case InvalidTypeBudgetExhausted:
+		return r.budgetRetryAfter()
+	default:
+		return 0, nil
+	}
+}
+
+// 根据当日失败次数与最后一次失败的时间计算临时封禁的剩余时长(命中多条策略时取最长者)
+func judgeTemporarilyBanRemain(cnt int, lastErrTime time.Time, now time.Time, temporarilyBanStrategy *sync.Map) time.Duration {
+	var remain time.Duration
+	temporarilyBanStrategy.Range(func(t, banDuration interface{}) bool {
+		if cnt >= t.(int) {
+			if d := lastErrTime.Add(time.Duration(banDuration.(int64)+1) * time.Second).Sub(now); d > remain {
+				remain = d
+			}
+		}
+		return true
+	})
+	return remain
 }
 
 // 查询该用户当日未核销成功的验证码数量
@@ -250,20 +423,53 @@ func (r VerificationCodeRdb) getRedisFieldNameVerificationCode(objName string) s
 
 // 根据对象名称生成存储该手机当日待核销的验证码的集合的字段名称
 func (r VerificationCodeRdb) getRedisFieldNameVerificationCodeSet(objName string) string {
-	return r.ModuleName + "VerificationCodeSet" + objName + time.Now().Format("20060102")
+	return r.ModuleName + "VerificationCodeSet" + objName + r.dateSuffix()
 }
 
 // 根据对象名称生成存储该手机当日验证错误的次数
 func (r VerificationCodeRdb) getRedisFieldNameVerificationCodeErrorCount(objName string) string {
-	return r.ModuleName + "VerificationCodeErrorCount" + objName + time.Now().Format("20060102")
+	return r.ModuleName + "VerificationCodeErrorCount" + objName + r.dateSuffix()
 }
 
 // 根据对象名称生成存储该手机当日最后一次验证错误的时间
 func (r VerificationCodeRdb) getRedisFieldNameVerificationCodeLastFailedTime(objName string) string {
-	return r.ModuleName + "VerificationCodeLastErrorTime" + objName + time.Now().Format("20060102")
+	return r.ModuleName + "VerificationCodeLastErrorTime" + objName + r.dateSuffix()
+}
+
+// 当前时间(已转换至业务模块划分自然日所用的时区)
+func (r VerificationCodeRdb) now() time.Time {
+	return r.clock.Now().In(r.location())
+}
+
+// 业务模块划分自然日所用的时区. 未指定时使用wow_time的默认时区
+func (r VerificationCodeRdb) location() *time.Location {
+	if r.loc != nil {
+		return r.loc
+	}
+	return wow_time.GetDefaultLocation()
+}
+
+// 第二天的零时
+func (r VerificationCodeRdb) tomorrowZeroTime() time.Time {
+	return wow_time.GetZeroTimeByDateOffsetFrom(r.now(), 1)
+}
+
+// 按日存储的字段名称的日期后缀
+func (r VerificationCodeRdb) dateSuffix() string {
+	return r.now().Format("20060102")
 }
 
-func createVerificationCodeRdb(rdb *redis.Client, moduleName string, strategy VerificationCodeServiceStrategy) (*VerificationCodeRdb, error) {
+// 根据日期生成存储业务模块当日统计数据的字段名称
+func (r VerificationCodeRdb) getRedisFieldNameVerificationCodeStatistics(date time.Time) string {
+	return r.ModuleName + "VerificationCodeStatistics" + date.Format("20060102")
+}
+
+// 根据日期生成存储业务模块当日申请验证码的去重对象集合(HyperLogLog)的字段名称
+func (r VerificationCodeRdb) getRedisFieldNameVerificationCodeStatisticsSubjects(date time.Time) string {
+	return r.ModuleName + "VerificationCodeStatisticsSubjects" + date.Format("20060102")
+}
+
+func createVerificationCodeRdb(rdb *redis.Client, moduleName string, strategy VerificationCodeServiceStrategy, opt *VerificationCodeRdbOptionalConfig) (*VerificationCodeRdb, error) {
 	if rdb == nil {
 		return nil, errors.New("rdb == nil")
 	}
@@ -272,14 +478,55 @@ func createVerificationCodeRdb(rdb *redis.Client, moduleName string, strategy Ve
 		return nil, errors.New("ModuleName == \"\"")
 	}
 
-	// 测试redis是否可用
-	if _, err := base.VerifyConnection(rdb); err != nil {
-		return nil, err
-	}
-
-	return &VerificationCodeRdb{
+	res := VerificationCodeRdb{
 		ModuleName: moduleName,
 		rDb:        rdb,
 		strategy:   strategy,
-	}, nil
+		clock:      wow_time.RealClock{},
+	}
+
+	if opt != nil {
+		res.eventHandler = opt.EventHandler
+		res.statistics = opt.StatisticsStrategy
+		if opt.Clock != nil {
+			res.clock = opt.Clock
+		}
+		res.loc = opt.Location
+		res.normalizer = opt.SubjectNormalizer
+		res.history = opt.HistoryStrategy
+		res.budget = opt.BudgetStrategy
+		res.ticket = opt.TicketStrategy
+		res.strategyOverride = opt.StrategyOverride
+		res.deviceTrust = opt.DeviceTrustStrategy
+		res.riskThresholds = opt.RiskThresholds
+	}
+
+	var sendRules, verifyRules []Rule
+	if opt != nil {
+		sendRules, verifyRules = opt.SendRules, opt.VerifyRules
+	}
+	rules, err := createRuleChain(sendRules, verifyRules)
+	if err != nil {
+		return nil, err
+	}
+	res.rules = rules
+
+	if res.ticket != nil && len(res.ticket.Secret) == 0 {
+		return nil, errors.New("TicketStrategy.Secret is empty")
+	}
+
+	// 未配置降级策略时, 创建前测试redis是否可用; 配置了降级策略时, 由健康探针负责在redis恢复前进行降级处理
+	if opt == nil || opt.DegradationStrategy == nil {
+		if _, err := base.VerifyConnection(rdb); err != nil {
+			return nil, err
+		}
+		return &res, nil
+	}
+
+	dc, err := res.createDegradationController(*opt.DegradationStrategy)
+	if err != nil {
+		return nil, err
+	}
+	res.degradation = dc
+	return &res, nil
 }
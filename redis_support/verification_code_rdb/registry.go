@@ -0,0 +1,206 @@
+package verification_code_rdb
+
+import (
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"strings"
+	"sync"
+)
+
+const (
+	tenantModuleNameSeparator = "@" // 租户的业务模块名称分隔符, 租户的业务模块名称为 ModuleName + "@" + 租户ID
+	maxTenantIdLength         = 64  // 租户ID的最大长度
+)
+
+// ErrInvalidTenantId 租户ID不合法. 租户ID仅允许由英文字母、数字、"_"、"-"、"."组成, 且不能包含"VerificationCode", 以免不同租户的redis字段前缀相互重叠
+var ErrInvalidTenantId = errors.New("invalid tenantId")
+
+// VerificationCodeRdbRegistry 多租户验证码服务注册表
+// 多个租户(应用、商户等)共享同一redis及可选配置项(降级策略、事件回调等), 各租户的redis字段相互隔离, 且可在运行时单独设置策略. 未单独设置策略的租户使用默认策略
+type VerificationCodeRdbRegistry struct {
+	VerificationCodeRdbRegistryInterface
+	base            *VerificationCodeRdb // 共享可选配置项的基础Rdb, 用于派生各租户的Rdb. 其策略不随SetDefaultStrategy更新, 默认策略以defaultStrategy为准
+	mutex           *sync.RWMutex
+	tenantStrategy  map[string]VerificationCodeServiceStrategy // 单独设置了策略的租户
+	defaultStrategy VerificationCodeServiceStrategy
+}
+
+type VerificationCodeRdbRegistryInterface interface {
+	Tenant(tenantId string) (*VerificationCodeRdb, error)
+	SetTenantStrategy(tenantId string, strategy VerificationCodeServiceStrategy) error
+	DelTenantStrategy(tenantId string)
+	QueryTenantStrategy(tenantId string) (strategy VerificationCodeServiceStrategy, isDefault bool)
+	SetDefaultStrategy(strategy VerificationCodeServiceStrategy)
+	QueryDefaultStrategy() VerificationCodeServiceStrategy
+	ListTenantsWithStrategy() []string
+	PreCheckBeforeSendVerificationCode(tenantId string, objName string) (it InvalidType, err error)
+	SetAndRegisterVerificationCode(tenantId string, objName string, verCode string) error
+	PreCheckBeforeVerifyAndUseVerificationCode(tenantId string, objName string) (it InvalidType, err error)
+	VerifyAndUseVerificationCode(tenantId string, objName string, verCode string) (exist bool, success bool, err error)
+	ForgetSubject(objName string) (map[string]ForgetReport, error)
+	Close()
+}
+
+// CreateVerificationCodeRdbRegistry 创建多租户验证码服务注册表
+// moduleName: 业务模块名称, 各租户的redis字段以 moduleName + "@" + 租户ID 作为业务模块名称
+// defaultStrategy: 默认策略, 用于未单独设置策略的租户
+// optCfg: 可选配置项, 由全部租户共享
+func CreateVerificationCodeRdbRegistry(rdb *redis.Client, moduleName string, defaultStrategy VerificationCodeServiceStrategy, optCfg *VerificationCodeRdbOptionalConfig) (*VerificationCodeRdbRegistry, error) {
+	base, err := createVerificationCodeRdb(rdb, moduleName, copyStrategy(defaultStrategy), optCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VerificationCodeRdbRegistry{
+		base:            base,
+		mutex:           &sync.RWMutex{},
+		tenantStrategy:  map[string]VerificationCodeServiceStrategy{},
+		defaultStrategy: copyStrategy(defaultStrategy),
+	}, nil
+}
+
+// Tenant 获取租户的验证码服务Rdb, 其策略为调用时刻租户生效的策略的副本. 后续对租户策略的修改不影响已获取的Rdb, 反之亦然
+func (g *VerificationCodeRdbRegistry) Tenant(tenantId string) (*VerificationCodeRdb, error) {
+	if err := checkTenantId(tenantId); err != nil {
+		return nil, err
+	}
+
+	strategy, _ := g.QueryTenantStrategy(tenantId)
+	res := *g.base
+	res.ModuleName = g.base.ModuleName + tenantModuleNameSeparator + tenantId
+	res.strategy = strategy
+	return &res, nil
+}
+
+// SetTenantStrategy 单独设置租户的策略(运行时生效). 注册表保存策略的副本, 此后对strategy的修改不影响租户
+func (g *VerificationCodeRdbRegistry) SetTenantStrategy(tenantId string, strategy VerificationCodeServiceStrategy) error {
+	if err := checkTenantId(tenantId); err != nil {
+		return err
+	}
+	if strategy.ValidityDuration <= 0 {
+		return errors.New("SetTenantStrategy ValidityDuration == 0")
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.tenantStrategy[tenantId] = copyStrategy(strategy)
+	return nil
+}
+
+// DelTenantStrategy 删除租户单独设置的策略, 此后该租户使用默认策略
+func (g *VerificationCodeRdbRegistry) DelTenantStrategy(tenantId string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	delete(g.tenantStrategy, tenantId)
+}
+
+// QueryTenantStrategy 查询租户当前生效的策略. isDefault: 是否为默认策略
+func (g *VerificationCodeRdbRegistry) QueryTenantStrategy(tenantId string) (strategy VerificationCodeServiceStrategy, isDefault bool) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	if s, ok := g.tenantStrategy[tenantId]; ok {
+		return copyStrategy(s), false
+	}
+	return copyStrategy(g.defaultStrategy), true
+}
+
+// SetDefaultStrategy 设置默认策略(运行时生效). 注册表保存策略的副本
+func (g *VerificationCodeRdbRegistry) SetDefaultStrategy(strategy VerificationCodeServiceStrategy) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.defaultStrategy = copyStrategy(strategy)
+}
+
+// QueryDefaultStrategy 查询默认策略
+func (g *VerificationCodeRdbRegistry) QueryDefaultStrategy() VerificationCodeServiceStrategy {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	return copyStrategy(g.defaultStrategy)
+}
+
+// ListTenantsWithStrategy 列出单独设置了策略的租户
+func (g *VerificationCodeRdbRegistry) ListTenantsWithStrategy() []string {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	res := make([]string, 0, len(g.tenantStrategy))
+	for tenantId := range g.tenantStrategy {
+		res = append(res, tenantId)
+	}
+	return res
+}
+
+// PreCheckBeforeSendVerificationCode 按租户进行发送验证码前的校验
+func (g *VerificationCodeRdbRegistry) PreCheckBeforeSendVerificationCode(tenantId string, objName string) (it InvalidType, err error) {
+	r, err := g.Tenant(tenantId)
+	if err != nil {
+		return 0, err
+	}
+	return r.PreCheckBeforeSendVerificationCode(objName)
+}
+
+// SetAndRegisterVerificationCode 按租户添加并记录验证码
+func (g *VerificationCodeRdbRegistry) SetAndRegisterVerificationCode(tenantId string, objName string, verCode string) error {
+	r, err := g.Tenant(tenantId)
+	if err != nil {
+		return err
+	}
+	return r.SetAndRegisterVerificationCode(objName, verCode)
+}
+
+// PreCheckBeforeVerifyAndUseVerificationCode 按租户进行核销验证码前的校验
+func (g *VerificationCodeRdbRegistry) PreCheckBeforeVerifyAndUseVerificationCode(tenantId string, objName string) (it InvalidType, err error) {
+	r, err := g.Tenant(tenantId)
+	if err != nil {
+		return 0, err
+	}
+	return r.PreCheckBeforeVerifyAndUseVerificationCode(objName)
+}
+
+// VerifyAndUseVerificationCode 按租户核销验证码
+func (g *VerificationCodeRdbRegistry) VerifyAndUseVerificationCode(tenantId string, objName string, verCode string) (exist bool, success bool, err error) {
+	r, err := g.Tenant(tenantId)
+	if err != nil {
+		return false, false, err
+	}
+	return r.VerifyAndUseVerificationCode(objName, verCode)
+}
+
+// ForgetSubject 清除对象在默认业务模块及全部租户中的数据, 用于响应个人信息删除请求. 返回各租户的清除结果, 默认业务模块对应的租户ID为""
+// 除单独设置了策略的租户外, 其余租户通过SCAN以 moduleName + "@" 为前缀的redis字段发现
+// 注意: 多因素流程及webhook投递中的数据不在清除范围内, 需另行调用VerificationFlow.ForgetSubject及Dispatcher.PurgeSubject(见VerificationCodeForgetInterface)
+func (g *VerificationCodeRdbRegistry) ForgetSubject(objName string) (map[string]ForgetReport, error) {
+	return g.forgetSubject(objName)
+}
+
+// Close 释放后台资源(停止健康探针)
+func (g *VerificationCodeRdbRegistry) Close() {
+	g.base.Close()
+}
+
+// 校验租户ID
+func checkTenantId(tenantId string) error {
+	if tenantId == "" || len(tenantId) > maxTenantIdLength || strings.Contains(tenantId, "VerificationCode") {
+		return ErrInvalidTenantId
+	}
+	for _, ch := range tenantId {
+		if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '_' || ch == '-' || ch == '.') {
+			return ErrInvalidTenantId
+		}
+	}
+	return nil
+}
+
+// 复制策略. TemporarilyBanStrategy为指针, 直接复制结构体会使多个策略共享同一短期禁止策略
+func copyStrategy(s VerificationCodeServiceStrategy) VerificationCodeServiceStrategy {
+	res := s
+	if s.TemporarilyBanStrategy != nil {
+		res.TemporarilyBanStrategy = &sync.Map{}
+		s.TemporarilyBanStrategy.Range(func(key, value interface{}) bool {
+			res.TemporarilyBanStrategy.Store(key, value)
+			return true
+		})
+	}
+	return res
+}
@@ -0,0 +1,88 @@
+package verification_code_rdb
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+// VerificationCodeResendInterface 重发验证码
+type VerificationCodeResendInterface interface {
+	PreCheckBeforeResendVerificationCode(objName string) (it InvalidType, err error)
+	ResendVerificationCode(objName string, newCode string) (code string, reused bool, err error)
+	PreCheckBeforeResendVerificationCodeWithMetadata(objName string, meta RequestMetadata) (it InvalidType, err error)
+	ResendVerificationCodeWithMetadata(objName string, newCode string, meta RequestMetadata) (code string, reused bool, err error)
+	QueryResendCountToday(objName string) (int, error)
+	QueryResendStrategy() ResendStrategy
+	ModifyResendStrategy(rs ResendStrategy)
+}
+
+// ResendStrategy 重发验证码的策略
+type ResendStrategy struct {
+	ReuseValidCode bool `json:"reuse_valid_code"` // 重发时若仍存在有效的验证码, 则重发该验证码而非生成新的验证码(不计入未核销的验证码数量, 先前下发的短信依然可用)
+	ExtendTTL      bool `json:"extend_ttl"`       // 重发已有的验证码时, 将其有效期重置为ValidityDuration. 重置所延长的时长与ExtendVerificationCode共同计入ExtendStrategy.MaxExtension, 超出上限后不再重置(MaxExtension为0时不重置). 注意: 请求间隔的判断基于验证码的剩余有效期, 因此重置后重新计算请求间隔
+}
+
+// 重发验证码. 策略允许复用且仍存在有效的验证码时返回该验证码, 否则添加并记录新的验证码newCode
+// code: 需发送给对象的验证码; reused: 是否复用了已有的验证码
+func (r VerificationCodeRdb) resendVerificationCode(objName string, newCode string) (code string, reused bool, err error) {
+	if !r.strategy.ResendStrategy.ReuseValidCode || r.isDegraded() {
+		return newCode, false, r.setAndRegisterVerificationCode(objName, newCode, time.Duration(r.strategy.ValidityDuration)*time.Second)
+	}
+
+	exist, code, err := r.getVerificationCode(objName)
+	if r.shouldDegrade(err) || (err == nil && !exist) {
+		return newCode, false, r.setAndRegisterVerificationCode(objName, newCode, time.Duration(r.strategy.ValidityDuration)*time.Second)
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	// 重置有效期计入累计延长时长, 避免反复重发使同一验证码无限期存活
+	if r.strategy.ResendStrategy.ExtendTTL && r.strategy.ExtendStrategy.MaxExtension > 0 {
+		if _, _, _, err = r.runExtendScript(objName, time.Duration(r.strategy.ValidityDuration)*time.Second, true); err != nil {
+			return "", false, err
+		}
+	}
+	r.increaseResendCount(objName)
+	r.recordResent()
+	r.historyResent(objName)
+	return code, true, nil
+}
+
+// 重发验证码前的校验. 校验请求是否过于频繁、验证错误次数是否过多;
+// 仅当需要生成新的验证码时(策略不允许复用或不存在有效的验证码), 才执行规则 RuleNameUnusedCodeTooMany
+func (r VerificationCodeRdb) preCheckBeforeResendVerificationCode(objName string) (it InvalidType, err error) {
+	var skip map[string]bool
+	if r.strategy.ResendStrategy.ReuseValidCode && !r.isDegraded() {
+		exist, _, err := r.getVerificationCode(objName)
+		if err == nil && exist {
+			skip = map[string]bool{RuleNameUnusedCodeTooMany: true}
+		}
+	}
+	return r.combineCheckIsUserValidWithDegradation(OperationPreCheckBeforeSend, objName, skip)
+}
+
+// 该用户当日重发次数 +1
+func (r VerificationCodeRdb) increaseResendCount(objName string) {
+	f := r.getRedisFieldNameVerificationCodeResendCount(objName)
+	_, _ = r.rDb.Pipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+		pipe.Incr(context.TODO(), f)
+		pipe.ExpireAt(context.TODO(), f, r.tomorrowZeroTime()) // 设置有效期到第二天的零时
+		return nil
+	})
+}
+
+// 查询该用户当日重发的次数
+func (r VerificationCodeRdb) queryResendCountToday(objName string) (int, error) {
+	cnt, err := r.rDb.Get(context.TODO(), r.getRedisFieldNameVerificationCodeResendCount(objName)).Int()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return cnt, err
+}
+
+// 根据对象名称生成存储该对象当日重发次数的字段名称
+func (r VerificationCodeRdb) getRedisFieldNameVerificationCodeResendCount(objName string) string {
+	return r.ModuleName + "VerificationCodeResendCount" + objName + r.dateSuffix()
+}
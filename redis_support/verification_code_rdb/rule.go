@@ -0,0 +1,225 @@
+package verification_code_rdb
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+// VerificationCodeRuleInterface 规则链的查询
+type VerificationCodeRuleInterface interface {
+	QueryRules(op DegradableOperation) []Rule
+}
+
+// InvalidTypeCustomBase 自定义规则使用的违规类型应不小于该值, 避免与内置违规类型冲突
+const InvalidTypeCustomBase InvalidType = 1000
+
+// 内置规则的名称
+const (
+	RuleNameRequestTooFrequently    = "request_too_frequently"
+	RuleNameVerifyFailTooFrequently = "verify_fail_too_frequently"
+	RuleNameUnusedCodeTooMany       = "unused_code_too_many"
+)
+
+// RuleContext 规则的执行上下文
+type RuleContext struct {
+	Ctx          context.Context
+	Operation    DegradableOperation // 触发校验的操作, OperationPreCheckBeforeSend 或 OperationPreCheckBeforeVerify
+	ObjName      string              // 对象名称(规范化后)
+	Metadata     RequestMetadata     // 请求的元数据, 未通过...WithMetadata方法调用时为空
+	Now          time.Time           // 当前时间
+	Rdb          VerificationCodeRdb // 执行校验的Rdb, 可用于调用各Query*方法
+	ScriptResult interface{}         // 规则的redis脚本的执行结果, 规则未提供脚本时为nil
+}
+
+// RuleScript 规则的redis脚本片段. 脚本在Evaluate之前执行, 执行结果存放于RuleContext.ScriptResult中, 可用于原子地读取或更新多个字段
+type RuleScript struct {
+	Script *redis.Script
+	Keys   func(rc RuleContext) []string      // 脚本的KEYS, 字段名称应以业务模块名称为前缀
+	Args   func(rc RuleContext) []interface{} // 脚本的ARGV
+}
+
+// Rule 校验规则
+type Rule interface {
+	Name() string                                        // 规则名称, 用于日志及查找
+	Evaluate(rc RuleContext) (it InvalidType, err error) // 执行校验, 通过时返回UserIsValid
+	Script() *RuleScript                                 // 规则的redis脚本片段, 不需要时返回nil
+}
+
+// RuleFunc 基于函数创建不需要redis脚本的规则
+func RuleFunc(name string, evaluate func(rc RuleContext) (InvalidType, error)) Rule {
+	return funcRule{name: name, evaluate: evaluate}
+}
+
+type funcRule struct {
+	name     string
+	evaluate func(rc RuleContext) (InvalidType, error)
+}
+
+func (f funcRule) Name() string                                 { return f.name }
+func (f funcRule) Evaluate(rc RuleContext) (InvalidType, error) { return f.evaluate(rc) }
+func (f funcRule) Script() *RuleScript                          { return nil }
+
+// DefaultSendRules 发送验证码前的默认规则链: 请求是否过于频繁 -> 验证错误次数是否过多 -> 未核销的验证码是否过多
+func DefaultSendRules() []Rule {
+	return []Rule{RequestTooFrequentlyRule{}, VerifyFailTooFrequentlyRule{}, UnusedCodeTooManyRule{}}
+}
+
+// DefaultVerifyRules 核销验证码前的默认规则链: 验证错误次数是否过多 -> 未核销的验证码是否过多
+func DefaultVerifyRules() []Rule {
+	return []Rule{VerifyFailTooFrequentlyRule{}, UnusedCodeTooManyRule{}}
+}
+
+// RequestTooFrequentlyRule 内置规则: 申请验证码是否过于频繁(策略项RequestTimeIntervalThreshold)
+type RequestTooFrequentlyRule struct{}
+
+func (RequestTooFrequentlyRule) Name() string        { return RuleNameRequestTooFrequently }
+func (RequestTooFrequentlyRule) Script() *RuleScript { return nil }
+
+// Evaluate 执行校验
+func (RequestTooFrequentlyRule) Evaluate(rc RuleContext) (InvalidType, error) {
+	invalid, err := rc.Rdb.checkIsRequestTooFrequently(rc.ObjName, rc.Rdb.strategy.RequestTimeIntervalThreshold)
+	return invalidTypeIf(invalid, InvalidTypeRequestTooFrequently), err
+}
+
+// VerifyFailTooFrequentlyRule 内置规则: 验证错误是否过于频繁(策略项DenyThresholdOfFailedCount及TemporarilyBanStrategy)
+type VerifyFailTooFrequentlyRule struct{}
+
+func (VerifyFailTooFrequentlyRule) Name() string        { return RuleNameVerifyFailTooFrequently }
+func (VerifyFailTooFrequentlyRule) Script() *RuleScript { return nil }
+
+// Evaluate 执行校验
+func (VerifyFailTooFrequentlyRule) Evaluate(rc RuleContext) (InvalidType, error) {
+	invalid, err := rc.Rdb.checkIsVerifyFailTooFrequently(rc.ObjName, rc.Rdb.strategy.DenyThresholdOfFailedCount, rc.Rdb.strategy.TemporarilyBanStrategy)
+	return invalidTypeIf(invalid, InvalidTypeVerifyFailTooFrequently), err
+}
+
+// UnusedCodeTooManyRule 内置规则: 当日未核销的验证码是否过多(策略项DenyThresholdOfUnusedCode)
+type UnusedCodeTooManyRule struct{}
+
+func (UnusedCodeTooManyRule) Name() string        { return RuleNameUnusedCodeTooMany }
+func (UnusedCodeTooManyRule) Script() *RuleScript { return nil }
+
+// Evaluate 执行校验
+func (UnusedCodeTooManyRule) Evaluate(rc RuleContext) (InvalidType, error) {
+	invalid, err := rc.Rdb.checkIsUnusedCodeTooMany(rc.ObjName, rc.Rdb.strategy.DenyThresholdOfUnusedCode)
+	return invalidTypeIf(invalid, InvalidTypeUnusedCodeTooMany), err
+}
+
+// 规则链, 按顺序执行, 遇到首个未通过的规则即停止
+type ruleChain struct {
+	send   []Rule
+	verify []Rule
+}
+
+// 创建规则链, 未指定的规则链使用默认规则链
+func createRuleChain(send []Rule, verify []Rule) (*ruleChain, error) {
+	if send == nil {
+		send = DefaultSendRules()
+	}
+	if verify == nil {
+		verify = DefaultVerifyRules()
+	}
+	for _, rule := range append(append([]Rule{}, send...), verify...) {
+		if rule == nil {
+			return nil, errors.New("rule == nil")
+		}
+	}
+	return &ruleChain{send: send, verify: verify}, nil
+}
+
+// 操作对应的规则链, 规则链为nil时返回默认规则链
+func (c *ruleChain) rulesOf(op DegradableOperation) []Rule {
+	if c == nil {
+		if op == OperationPreCheckBeforeVerify {
+			return DefaultVerifyRules()
+		}
+		return DefaultSendRules()
+	}
+	if op == OperationPreCheckBeforeVerify {
+		return c.verify
+	}
+	return c.send
+}
+
+// 按顺序执行规则链. skip: 需跳过的规则名称
+func (r VerificationCodeRdb) evaluateRules(op DegradableOperation, objName string, skip map[string]bool) (InvalidType, error) {
+	rc := r.createRuleContext(op, objName)
+	for _, rule := range r.rules.rulesOf(op) {
+		if skip[rule.Name()] {
+			continue
+		}
+		if it, err := r.evaluateRule(rule, rc); err != nil || it != UserIsValid {
+			return it, err
+		}
+	}
+	return UserIsValid, nil
+}
+
+// 创建规则的执行上下文
+func (r VerificationCodeRdb) createRuleContext(op DegradableOperation, objName string) RuleContext {
+	rc := RuleContext{
+		Ctx:       context.TODO(),
+		Operation: op,
+		ObjName:   objName,
+		Now:       r.now(),
+		Rdb:       r,
+	}
+	if r.metadata != nil {
+		rc.Metadata = *r.metadata
+	}
+	return rc
+}
+
+// 执行单条规则, 规则提供redis脚本时先执行脚本
+func (r VerificationCodeRdb) evaluateRule(rule Rule, rc RuleContext) (InvalidType, error) {
+	if s := rule.Script(); s != nil {
+		var keys []string
+		var args []interface{}
+		if s.Keys != nil {
+			keys = s.Keys(rc)
+		}
+		if s.Args != nil {
+			args = s.Args(rc)
+		}
+		res, err := s.Script.Run(rc.Ctx, r.rDb, keys, args...).Result()
+		if err != nil && err != redis.Nil {
+			return 0, err
+		}
+		rc.ScriptResult = res
+	}
+	return rule.Evaluate(rc)
+}
+
+// 查询操作对应的规则链
+func (r VerificationCodeRdb) queryRules(op DegradableOperation) []Rule {
+	return append([]Rule{}, r.rules.rulesOf(op)...)
+}
+
+// 替换操作对应的规则链. rules为nil时恢复为默认规则链
+func (r *VerificationCodeRdb) modifyRules(op DegradableOperation, rules []Rule) error {
+	send, verify := r.rules.rulesOf(OperationPreCheckBeforeSend), r.rules.rulesOf(OperationPreCheckBeforeVerify)
+	switch op {
+	case OperationPreCheckBeforeSend:
+		send = rules
+	case OperationPreCheckBeforeVerify:
+		verify = rules
+	default:
+		return errors.New("unsupported operation")
+	}
+
+	chain, err := createRuleChain(send, verify)
+	if err != nil {
+		return err
+	}
+	r.rules = chain
+	return nil
+}
+
+func invalidTypeIf(invalid bool, it InvalidType) InvalidType {
+	if invalid {
+		return it
+	}
+	return UserIsValid
+}
@@ -0,0 +1,160 @@
+package verification_code_rdb
+
+import (
+	"context"
+	"errors"
+	"github.com/DontBeProud/wow-easy-go/utils/wow_time"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VerificationCodeStatisticsInterface 按日统计的查询
+type VerificationCodeStatisticsInterface interface {
+	QueryDailyStatistics(from time.Time, to time.Time) ([]DailyStatistics, error)
+}
+
+const (
+	defaultStatisticsRetentionDays = 30 // 统计数据默认保留的天数
+
+	statisticsFieldIssued         = "issued"    // 下发的验证码数量
+	statisticsFieldVerified       = "verified"  // 核销成功的次数
+	statisticsFieldFailed         = "failed"    // 核销失败的次数
+	statisticsFieldResent         = "resent"    // 复用已有验证码的重发次数
+	statisticsFieldRejectedPrefix = "rejected:" // 各违规类型导致的拒绝次数, 后接InvalidType的值
+)
+
+// StatisticsStrategy 按日统计的配置
+type StatisticsStrategy struct {
+	RetentionDays int // 统计数据保留的天数, 小于等于0时使用默认值(30天)
+}
+
+// DailyStatistics 业务模块的单日统计数据
+type DailyStatistics struct {
+	Date           string                // 日期, 格式为20060102
+	IssuedCount    int64                 // 下发的验证码数量
+	VerifiedCount  int64                 // 核销成功的次数
+	FailedCount    int64                 // 核销失败的次数
+	ResentCount    int64                 // 复用已有验证码的重发次数(不计入IssuedCount)
+	RejectedCount  map[InvalidType]int64 // 各违规类型导致的拒绝次数
+	UniqueSubjects int64                 // 申请验证码的去重对象数量(基于HyperLogLog的估算值)
+}
+
+// 统计数据保留的天数
+func (s StatisticsStrategy) retentionDays() int {
+	if s.RetentionDays <= 0 {
+		return defaultStatisticsRetentionDays
+	}
+	return s.RetentionDays
+}
+
+// 记录下发验证码(计数+1, 并将对象计入当日去重集合)
+func (r VerificationCodeRdb) recordIssued(objName string) {
+	r.increaseStatistics(statisticsFieldIssued, objName)
+}
+
+// 记录核销成功
+func (r VerificationCodeRdb) recordVerified() {
+	r.increaseStatistics(statisticsFieldVerified, "")
+}
+
+// 记录核销失败
+func (r VerificationCodeRdb) recordFailed() {
+	r.increaseStatistics(statisticsFieldFailed, "")
+}
+
+// 记录复用已有验证码的重发
+func (r VerificationCodeRdb) recordResent() {
+	r.increaseStatistics(statisticsFieldResent, "")
+}
+
+// 记录因违规而拒绝的请求
+func (r VerificationCodeRdb) recordRejected(it InvalidType) {
+	r.increaseStatistics(statisticsFieldRejectedPrefix+strconv.Itoa(int(it)), "")
+}
+
+// 当日统计数据的指定字段+1. objName不为空时, 将对象计入当日去重集合. 未开启统计时不做任何处理
+func (r VerificationCodeRdb) increaseStatistics(field string, objName string) {
+	if r.statistics == nil {
+		return
+	}
+
+	now := r.now()
+	expireAt := wow_time.GetZeroTimeByDateOffsetFrom(now, r.statistics.retentionDays())
+	_, _ = r.rDb.Pipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+		f := r.getRedisFieldNameVerificationCodeStatistics(now)
+		pipe.HIncrBy(context.TODO(), f, field, 1)
+		pipe.ExpireAt(context.TODO(), f, expireAt)
+		if objName != "" {
+			fs := r.getRedisFieldNameVerificationCodeStatisticsSubjects(now)
+			pipe.PFAdd(context.TODO(), fs, objName)
+			pipe.ExpireAt(context.TODO(), fs, expireAt)
+		}
+		return nil
+	})
+}
+
+// 查询[from, to]日期范围内每日的统计数据(按日期升序排列)
+func (r VerificationCodeRdb) queryDailyStatistics(from time.Time, to time.Time) ([]DailyStatistics, error) {
+	if r.statistics == nil {
+		return nil, errors.New("statistics is not enabled")
+	}
+
+	var days []time.Time
+	for d := truncateToDate(from.In(r.location())); !d.After(truncateToDate(to.In(r.location()))); d = d.AddDate(0, 0, 1) {
+		days = append(days, d)
+	}
+
+	hashCmds := make([]*redis.StringStringMapCmd, len(days))
+	countCmds := make([]*redis.IntCmd, len(days))
+	if _, err := r.rDb.Pipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+		for i, d := range days {
+			hashCmds[i] = pipe.HGetAll(context.TODO(), r.getRedisFieldNameVerificationCodeStatistics(d))
+			countCmds[i] = pipe.PFCount(context.TODO(), r.getRedisFieldNameVerificationCodeStatisticsSubjects(d))
+		}
+		return nil
+	}); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	res := make([]DailyStatistics, len(days))
+	for i, d := range days {
+		res[i] = parseDailyStatistics(d.Format("20060102"), hashCmds[i].Val(), countCmds[i].Val())
+	}
+	return res, nil
+}
+
+// 解析单日统计数据
+func parseDailyStatistics(date string, fields map[string]string, uniqueSubjects int64) DailyStatistics {
+	res := DailyStatistics{
+		Date:           date,
+		RejectedCount:  map[InvalidType]int64{},
+		UniqueSubjects: uniqueSubjects,
+	}
+
+	for field, value := range fields {
+		cnt, _ := strconv.ParseInt(value, 10, 64)
+		switch {
+		case field == statisticsFieldIssued:
+			res.IssuedCount = cnt
+		case field == statisticsFieldVerified:
+			res.VerifiedCount = cnt
+		case field == statisticsFieldFailed:
+			res.FailedCount = cnt
+		case field == statisticsFieldResent:
+			res.ResentCount = cnt
+		case strings.HasPrefix(field, statisticsFieldRejectedPrefix):
+			if it, err := strconv.Atoi(strings.TrimPrefix(field, statisticsFieldRejectedPrefix)); err == nil {
+				res.RejectedCount[InvalidType(it)] = cnt
+			}
+		}
+	}
+	return res
+}
+
+// 截取日期部分(当日零时)
+func truncateToDate(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
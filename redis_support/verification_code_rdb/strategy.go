@@ -8,11 +8,14 @@ import (
 // VerificationCodeServiceStrategy 验证码服务策略
 type VerificationCodeServiceStrategy struct {
 	vcsStrategyInterface
-	ValidityDuration             int64     // 验证码有效期时长(秒), 必须大于0
-	RequestTimeIntervalThreshold int64     // 验证码请求间隔时长限制(秒)，即两次获取验证码的时间差值下限. 不需要该项限制则填0
-	DenyThresholdOfUnusedCode    int       // 单日未核销的验证码数量阈值，超过该值后禁止手机号使用短信验证码业务. 不需要该项限制则填0
-	DenyThresholdOfFailedCount   int       // 单日验证错误次数阈值，高于该值后禁止手机号使用短信验证码业务. 不需要该项限制则填0
-	TemporarilyBanStrategy       *sync.Map // 短暂禁止手机号使用短信验证码业务的策略，key:失败次数的阈值;value:禁止时长(秒), 详见CheckIsVerifyFailTooFrequently
+	ValidityDuration             int64                  // 验证码有效期时长(秒), 必须大于0
+	RequestTimeIntervalThreshold int64                  // 验证码请求间隔时长限制(秒)，即两次获取验证码的时间差值下限. 不需要该项限制则填0
+	DenyThresholdOfUnusedCode    int                    // 单日未核销的验证码数量阈值，超过该值后禁止手机号使用短信验证码业务. 不需要该项限制则填0
+	DenyThresholdOfFailedCount   int                    // 单日验证错误次数阈值，高于该值后禁止手机号使用短信验证码业务. 不需要该项限制则填0
+	TemporarilyBanStrategy       *sync.Map              // 短暂禁止手机号使用短信验证码业务的策略，key:失败次数的阈值;value:禁止时长(秒), 详见CheckIsVerifyFailTooFrequently
+	CodeComparisonStrategy       CodeComparisonStrategy // 验证码比对策略(全角转半角、去除分隔符、忽略大小写). 默认不做规范化, 仅进行恒定时间比较
+	ResendStrategy               ResendStrategy         // 重发验证码的策略. 默认每次重发均生成新的验证码
+	ExtendStrategy               ExtendStrategy         // 延长验证码有效期的策略. 默认不允许延长
 }
 
 func CreateVerificationCodeServiceStrategy(duration int64, intervalThreshold int64, unusedThreshold int,
@@ -112,3 +115,33 @@ func (s *VerificationCodeServiceStrategy) ModifyValidityDuration(duration int64)
 func (s *VerificationCodeServiceStrategy) ModifyRequestTimeIntervalThreshold(intervalThreshold int64) {
 	s.RequestTimeIntervalThreshold = intervalThreshold
 }
+
+// QueryCodeComparisonStrategy 查询验证码比对策略
+func (s VerificationCodeServiceStrategy) QueryCodeComparisonStrategy() CodeComparisonStrategy {
+	return s.CodeComparisonStrategy
+}
+
+// ModifyCodeComparisonStrategy 修改验证码比对策略
+func (s *VerificationCodeServiceStrategy) ModifyCodeComparisonStrategy(cs CodeComparisonStrategy) {
+	s.CodeComparisonStrategy = cs
+}
+
+// QueryResendStrategy 查询重发验证码的策略
+func (s VerificationCodeServiceStrategy) QueryResendStrategy() ResendStrategy {
+	return s.ResendStrategy
+}
+
+// ModifyResendStrategy 修改重发验证码的策略
+func (s *VerificationCodeServiceStrategy) ModifyResendStrategy(rs ResendStrategy) {
+	s.ResendStrategy = rs
+}
+
+// QueryExtendStrategy 查询延长验证码有效期的策略
+func (s VerificationCodeServiceStrategy) QueryExtendStrategy() ExtendStrategy {
+	return s.ExtendStrategy
+}
+
+// ModifyExtendStrategy 修改延长验证码有效期的策略
+func (s *VerificationCodeServiceStrategy) ModifyExtendStrategy(es ExtendStrategy) {
+	s.ExtendStrategy = es
+}
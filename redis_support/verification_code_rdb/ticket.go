@@ -0,0 +1,164 @@
+package verification_code_rdb
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/DontBeProud/wow-easy-go/utils/wow_random"
+	"strings"
+	"time"
+)
+
+// VerificationCodeTicketInterface 核销成功后签发及兑换票据
+type VerificationCodeTicketInterface interface {
+	IssueTicket(objName string, scene string) (ticket string, err error)
+	VerifyAndUseVerificationCodeWithTicket(objName string, verCode string, scene string) (exist bool, success bool, ticket string, err error)
+	VerifyAndUseVerificationCodeWithTicketAndMetadata(objName string, verCode string, scene string, meta RequestMetadata) (exist bool, success bool, ticket string, err error)
+	IsTicketEnabled() bool
+	RedeemTicket(ticket string, scene string) (subject string, err error)
+}
+
+const (
+	defaultTicketTTL     = 5 * time.Minute // 票据默认的有效期
+	ticketIdByteLength   = 16              // 票据ID的随机字节数
+	ticketPartsSeparator = "."             // 票据载荷与签名的分隔符
+)
+
+var (
+	// ErrTicketDisabled 未配置票据策略
+	ErrTicketDisabled = errors.New("ticket is not enabled")
+	// ErrInvalidTicket 票据格式错误或签名不合法
+	ErrInvalidTicket = errors.New("invalid ticket")
+	// ErrTicketExpired 票据已过期
+	ErrTicketExpired = errors.New("ticket expired")
+	// ErrTicketSceneMismatch 票据绑定的业务场景与兑换时的业务场景不一致
+	ErrTicketSceneMismatch = errors.New("ticket scene mismatch")
+	// ErrTicketAlreadyUsed 票据已被兑换
+	ErrTicketAlreadyUsed = errors.New("ticket already used")
+)
+
+// TicketStrategy 核销成功后签发票据的配置. 票据为短期有效、一次性使用的HMAC签名凭证, 用于向后续接口(例如设置新密码)证明对象刚刚通过验证
+type TicketStrategy struct {
+	Secret []byte        // HMAC-SHA256签名密钥, 不能为空. 多实例部署时须保持一致
+	TTL    time.Duration // 票据的有效期, 小于等于0时使用默认值(5分钟)
+}
+
+// 票据载荷
+type ticketPayload struct {
+	Id      string `json:"id"`  // 票据ID, 用于保证一次性使用
+	Module  string `json:"mod"` // 业务模块名称
+	Subject string `json:"sub"` // 对象名称(规范化后)
+	Scene   string `json:"scn"` // 业务场景
+	Expire  int64  `json:"exp"` // 过期时间(unix秒)
+}
+
+// 票据的有效期
+func (s TicketStrategy) ttl() time.Duration {
+	if s.TTL <= 0 {
+		return defaultTicketTTL
+	}
+	return s.TTL
+}
+
+// 签发票据
+func (r VerificationCodeRdb) issueTicket(objName string, scene string) (string, error) {
+	if r.ticket == nil {
+		return "", ErrTicketDisabled
+	}
+
+	id, err := wow_random.GenerateSecureRandomHexString(ticketIdByteLength)
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(ticketPayload{
+		Id:      id,
+		Module:  r.ModuleName,
+		Subject: objName,
+		Scene:   scene,
+		Expire:  r.now().Add(r.ticket.ttl()).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + ticketPartsSeparator + base64.RawURLEncoding.EncodeToString(r.signTicket(encoded)), nil
+}
+
+// 核销验证码, 核销成功时签发票据
+func (r VerificationCodeRdb) verifyAndUseVerificationCodeWithTicket(objName string, verCode string, scene string) (exist bool, success bool, ticket string, err error) {
+	if r.ticket == nil {
+		return false, false, "", ErrTicketDisabled
+	}
+
+	if exist, success, err = r.verifyAndUseVerificationCode(objName, verCode); err != nil || !success {
+		return exist, success, "", err
+	}
+	ticket, err = r.issueTicket(objName, scene)
+	return exist, success, ticket, err
+}
+
+// 兑换票据: 校验签名、有效期及业务场景, 并通过redis保证一次性使用. 返回票据绑定的对象名称
+func (r VerificationCodeRdb) redeemTicket(ticket string, scene string) (subject string, err error) {
+	if r.ticket == nil {
+		return "", ErrTicketDisabled
+	}
+
+	p, err := r.parseTicket(ticket)
+	if err != nil {
+		return "", err
+	}
+	if p.Scene != scene {
+		return "", ErrTicketSceneMismatch
+	}
+
+	remain := time.Unix(p.Expire, 0).Sub(r.now())
+	if remain <= 0 {
+		return "", ErrTicketExpired
+	}
+
+	// 标记保留至票据过期, 过期后签名校验即可拒绝该票据
+	ok, err := r.rDb.SetNX(context.TODO(), r.getRedisFieldNameVerificationCodeTicketUsed(p.Id), 1, remain).Result()
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrTicketAlreadyUsed
+	}
+	return p.Subject, nil
+}
+
+// 解析票据并校验签名及所属业务模块
+func (r VerificationCodeRdb) parseTicket(ticket string) (ticketPayload, error) {
+	var p ticketPayload
+
+	parts := strings.Split(ticket, ticketPartsSeparator)
+	if len(parts) != 2 {
+		return p, ErrInvalidTicket
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, r.signTicket(parts[0])) {
+		return p, ErrInvalidTicket
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(payload, &p) != nil || p.Module != r.ModuleName || p.Id == "" {
+		return p, ErrInvalidTicket
+	}
+	return p, nil
+}
+
+// 计算票据载荷的签名
+func (r VerificationCodeRdb) signTicket(encodedPayload string) []byte {
+	mac := hmac.New(sha256.New, r.ticket.Secret)
+	mac.Write([]byte(encodedPayload))
+	return mac.Sum(nil)
+}
+
+// 根据票据ID生成标记票据已兑换的字段名称
+func (r VerificationCodeRdb) getRedisFieldNameVerificationCodeTicketUsed(ticketId string) string {
+	return r.ModuleName + "VerificationCodeTicketUsed" + ticketId
+}
@@ -2,7 +2,9 @@ package verification_code_rdb
 
 import (
 	"github.com/DontBeProud/wow-easy-go/redis_support/base"
+	"github.com/DontBeProud/wow-easy-go/utils/wow_time"
 	"github.com/go-redis/redis/v8"
+	"io"
 	"time"
 )
 
@@ -14,6 +16,9 @@ const (
 	InvalidTypeUnusedCodeTooMany       // 未核销的验证码过多(频繁请求验证码但不进行验证)
 	InvalidTypeRequestTooFrequently    // 请求验证码过于频繁(短时间内连续多次请求验证码)
 	InvalidTypeVerifyFailTooFrequently // 验证码核销失败过于频繁
+	InvalidTypeServiceUnavailable      // redis不可用且降级策略为拒绝请求
+	InvalidTypeInvalidSubject          // 对象名称不合法(未通过SubjectNormalizer的规范化校验)
+	InvalidTypeBudgetExhausted         // 业务模块的发送预算已耗尽(BudgetStrategy)
 )
 
 // VerificationCodeRdb 用于验证码相关服务的通用Rdb结构
@@ -22,11 +27,31 @@ type VerificationCodeRdb struct {
 	rDb        *redis.Client                   // redis对象
 	strategy   VerificationCodeServiceStrategy // 策略
 	VerificationCodeRdbInterface
+	eventHandler     EventHandler           // 事件回调
+	degradation      *degradationController // 降级控制器, 未配置降级策略时为nil
+	statistics       *StatisticsStrategy    // 按日统计的配置, 未开启统计时为nil
+	clock            wow_time.Clock         // 时钟
+	loc              *time.Location         // 划分自然日所用的时区, 为nil时使用wow_time的默认时区
+	normalizer       SubjectNormalizer      // 对象名称规范化接口, 为nil时不做规范化
+	history          *HistoryStrategy       // 历史记录的配置, 未开启历史记录时为nil
+	metadata         *RequestMetadata       // 当前请求的元数据, 仅存在于...WithMetadata方法内部创建的副本中
+	budget           *BudgetStrategy        // 业务模块的发送预算, 未配置时为nil
+	ticket           *TicketStrategy        // 核销成功后签发票据的配置, 未配置时为nil
+	rules            *ruleChain             // 发送及核销前的规则链
+	strategyOverride bool                   // 是否开启按对象覆盖策略
+	deviceTrust      *DeviceTrustStrategy   // 受信任设备的配置, 未配置时为nil
+	deviceGrant      *deviceTokenGrant      // 核销成功时一并签发的设备令牌, 仅存在于VerifyAndUseVerificationCodeWithDeviceToken内部创建的副本中
+	riskThresholds   []int                  // 触发风险事件的当日失败次数阈值, 未配置时为nil
 }
 
 // CreateVerificationCodeRdb 创建用于验证码服务的Rdb
 func CreateVerificationCodeRdb(rdb *redis.Client, moduleName string, strategy VerificationCodeServiceStrategy) (*VerificationCodeRdb, error) {
-	return createVerificationCodeRdb(rdb, moduleName, strategy)
+	return createVerificationCodeRdb(rdb, moduleName, strategy, nil)
+}
+
+// CreateVerificationCodeRdbWithOptionalConfig 基于可选配置项创建用于验证码服务的Rdb
+func CreateVerificationCodeRdbWithOptionalConfig(rdb *redis.Client, moduleName string, strategy VerificationCodeServiceStrategy, optCfg *VerificationCodeRdbOptionalConfig) (*VerificationCodeRdb, error) {
+	return createVerificationCodeRdb(rdb, moduleName, strategy, optCfg)
 }
 
 type VerificationCodeRdbInterface interface {
@@ -46,36 +71,273 @@ type VerificationCodeRdbInterface interface {
 	QueryVerificationCodeRegisteredPeriod(objName string) (invalid bool, period int64, err error)
 }
 
+// VerificationCodeRetryAfterInterface 查询被拒绝的对象需等待多久才能重试
+type VerificationCodeRetryAfterInterface interface {
+	QueryRetryAfter(objName string, it InvalidType) (time.Duration, error)
+}
+
 // VerifyConnection 判断redis是否成功连接并可用(在执行关键步骤前应先调用本函数验证redis是否可用，避免无谓的资源消耗，包括但不限于验证码发送费用、服务端资源等)
 func (r VerificationCodeRdb) VerifyConnection() (bool, error) {
 	return base.VerifyConnection(r.rDb)
 }
 
-// PreCheckBeforeSendVerificationCode 发送验证码前的校验(组合校验用户当前状态是否合法)
-// 校验请求是否过于频繁、验证错误次数是否过多、未核销的验证码是否过多(是否频繁请求验证码但不进行验证)
+// IsDegraded 当前是否因redis不可用而处于降级模式. 未配置降级策略时始终返回false
+func (r VerificationCodeRdb) IsDegraded() bool {
+	return r.isDegraded()
+}
+
+// Close 释放后台资源(停止健康探针). 未配置降级策略时无需调用
+func (r VerificationCodeRdb) Close() {
+	if r.degradation != nil {
+		r.degradation.probe.Stop()
+	}
+}
+
+// PreCheckBeforeSendVerificationCode 发送验证码前的校验(按顺序执行发送前的规则链, 遇到首个未通过的规则即返回)
+// 默认校验请求是否过于频繁、验证错误次数是否过多、未核销的验证码是否过多(是否频繁请求验证码但不进行验证)
+// 配置了发送预算时, 校验通过后占用一条短信的预算, 预算耗尽时返回InvalidTypeBudgetExhausted
 func (r VerificationCodeRdb) PreCheckBeforeSendVerificationCode(objName string) (it InvalidType, err error) {
+	if r, objName, err = r.prepareSubject(objName); err != nil {
+		return InvalidTypeInvalidSubject, err
+	}
 	return r.preCheckBeforeSendVerificationCode(objName)
 }
 
 // SetAndRegisterVerificationCode 添加并记录验证码(添加该用户的验证码缓存，并且向该用户未核销的验证码集合中添加该验证码)
 func (r VerificationCodeRdb) SetAndRegisterVerificationCode(objName string, verCode string) error {
+	r, objName, err := r.prepareSubject(objName)
+	if err != nil {
+		return err
+	}
 	return r.setAndRegisterVerificationCode(objName, verCode, time.Duration(r.strategy.ValidityDuration)*time.Second)
 }
 
-// PreCheckBeforeVerifyAndUseVerificationCode 核销验证码前的校验(组合校验用户当前状态是否合法)
-// 校验验证错误次数是否过多、未核销的验证码是否过多(是否频繁请求验证码但不进行验证)
+// PreCheckBeforeVerifyAndUseVerificationCode 核销验证码前的校验(按顺序执行核销前的规则链, 遇到首个未通过的规则即返回)
+// 默认校验验证错误次数是否过多、未核销的验证码是否过多(是否频繁请求验证码但不进行验证)
 func (r VerificationCodeRdb) PreCheckBeforeVerifyAndUseVerificationCode(objName string) (it InvalidType, err error) {
+	if r, objName, err = r.prepareSubject(objName); err != nil {
+		return InvalidTypeInvalidSubject, err
+	}
 	return r.preCheckBeforeVerifyAndUseVerificationCode(objName)
 }
 
-// VerifyAndUseVerificationCode 核销验证码
+// VerifyAndUseVerificationCode 核销验证码. 绑定交易载荷的验证码无法通过本方法核销, 需使用VerifyAndUseVerificationCodeWithPayload
 func (r VerificationCodeRdb) VerifyAndUseVerificationCode(objName string, verCode string) (exist bool, success bool, err error) {
+	if r, objName, err = r.prepareSubject(objName); err != nil {
+		return false, false, err
+	}
 	return r.verifyAndUseVerificationCode(objName, verCode)
 }
 
+// SetAndRegisterVerificationCodeWithPayload 添加并记录绑定交易载荷(例如序列化后的转账请求)的验证码, 仅保存载荷的摘要
+// 该验证码仅能通过VerifyAndUseVerificationCodeWithPayload以相同的载荷核销. 降级模式下返回ErrRedisUnavailable
+func (r VerificationCodeRdb) SetAndRegisterVerificationCodeWithPayload(objName string, verCode string, payload []byte) error {
+	r, objName, err := r.prepareSubject(objName)
+	if err != nil {
+		return err
+	}
+	return r.setAndRegisterVerificationCodeWithPayload(objName, verCode, payload, time.Duration(r.strategy.ValidityDuration)*time.Second)
+}
+
+// VerifyAndUseVerificationCodeWithPayload 核销绑定交易载荷的验证码. 验证码正确但载荷不一致时同样视为核销失败并计入失败次数
+func (r VerificationCodeRdb) VerifyAndUseVerificationCodeWithPayload(objName string, verCode string, payload []byte) (exist bool, success bool, err error) {
+	if r, objName, err = r.prepareSubject(objName); err != nil {
+		return false, false, err
+	}
+	return r.verifyAndUseVerificationCodeWithPayload(objName, verCode, payload)
+}
+
+// PeekVerificationCodeWithPayload 校验绑定交易载荷的验证码但不核销
+func (r VerificationCodeRdb) PeekVerificationCodeWithPayload(objName string, verCode string, payload []byte) (exist bool, success bool, err error) {
+	if r, objName, err = r.prepareSubject(objName); err != nil {
+		return false, false, err
+	}
+	return r.peekVerificationCodeWithPayload(objName, verCode, payload)
+}
+
+// PeekVerificationCode 校验验证码是否正确但不核销, 适用于多步骤表单(第一步校验, 最终提交时再核销). 校验失败时计入失败次数
+// 调用前同样应先调用PreCheckBeforeVerifyAndUseVerificationCode进行校验
+func (r VerificationCodeRdb) PeekVerificationCode(objName string, verCode string) (exist bool, success bool, err error) {
+	if r, objName, err = r.prepareSubject(objName); err != nil {
+		return false, false, err
+	}
+	return r.peekVerificationCode(objName, verCode)
+}
+
+// ExtendVerificationCode 延长验证码的有效期, 单个验证码累计延长的时长不超过策略(ExtendStrategy.MaxExtension)的上限
+// exist: 验证码是否存在; extended: 实际延长的时长(达到上限时为0); ttl: 延长后的剩余有效期. 策略不允许延长时返回ErrExtendNotAllowed
+func (r VerificationCodeRdb) ExtendVerificationCode(objName string, by time.Duration) (exist bool, extended time.Duration, ttl time.Duration, err error) {
+	if r, objName, err = r.prepareSubject(objName); err != nil {
+		return false, 0, 0, err
+	}
+	return r.extendVerificationCode(objName, by)
+}
+
+// PreCheckBeforeResendVerificationCode 重发验证码前的校验(组合校验用户当前状态是否合法)
+// 校验请求是否过于频繁、验证错误次数是否过多; 若重发时将复用已有的验证码, 则不校验未核销的验证码是否过多
+func (r VerificationCodeRdb) PreCheckBeforeResendVerificationCode(objName string) (it InvalidType, err error) {
+	if r, objName, err = r.prepareSubject(objName); err != nil {
+		return InvalidTypeInvalidSubject, err
+	}
+	return r.preCheckBeforeResendVerificationCode(objName)
+}
+
+// ResendVerificationCode 重发验证码. 策略(ResendStrategy)允许复用且仍存在有效的验证码时, 返回该验证码并计入重发次数;
+// 否则等同于SetAndRegisterVerificationCode(objName, newCode)
+// code: 需发送给对象的验证码; reused: 是否复用了已有的验证码
+func (r VerificationCodeRdb) ResendVerificationCode(objName string, newCode string) (code string, reused bool, err error) {
+	if r, objName, err = r.prepareSubject(objName); err != nil {
+		return "", false, err
+	}
+	return r.resendVerificationCode(objName, newCode)
+}
+
+// QueryResendCountToday 查询用户当日复用已有验证码的重发次数
+func (r VerificationCodeRdb) QueryResendCountToday(objName string) (int, error) {
+	objName, err := r.normalizeSubject(objName)
+	if err != nil {
+		return 0, err
+	}
+	return r.queryResendCountToday(objName)
+}
+
+// PreCheckBeforeSendVerificationCodeWithMetadata 发送验证码前的校验, 请求元数据(IP、业务场景等)随历史记录一并保存
+func (r VerificationCodeRdb) PreCheckBeforeSendVerificationCodeWithMetadata(objName string, meta RequestMetadata) (it InvalidType, err error) {
+	return r.withMetadata(meta).PreCheckBeforeSendVerificationCode(objName)
+}
+
+// SetAndRegisterVerificationCodeWithMetadata 添加并记录验证码, 请求元数据(IP、业务场景等)随历史记录一并保存
+func (r VerificationCodeRdb) SetAndRegisterVerificationCodeWithMetadata(objName string, verCode string, meta RequestMetadata) error {
+	return r.withMetadata(meta).SetAndRegisterVerificationCode(objName, verCode)
+}
+
+// PreCheckBeforeVerifyAndUseVerificationCodeWithMetadata 核销验证码前的校验, 请求元数据(IP、业务场景等)随历史记录一并保存
+func (r VerificationCodeRdb) PreCheckBeforeVerifyAndUseVerificationCodeWithMetadata(objName string, meta RequestMetadata) (it InvalidType, err error) {
+	return r.withMetadata(meta).PreCheckBeforeVerifyAndUseVerificationCode(objName)
+}
+
+// VerifyAndUseVerificationCodeWithMetadata 核销验证码, 请求元数据(IP、业务场景等)随历史记录一并保存
+func (r VerificationCodeRdb) VerifyAndUseVerificationCodeWithMetadata(objName string, verCode string, meta RequestMetadata) (exist bool, success bool, err error) {
+	return r.withMetadata(meta).VerifyAndUseVerificationCode(objName, verCode)
+}
+
+// PreCheckBeforeResendVerificationCodeWithMetadata 重发验证码前的校验, 请求元数据(IP、业务场景等)随历史记录一并保存
+func (r VerificationCodeRdb) PreCheckBeforeResendVerificationCodeWithMetadata(objName string, meta RequestMetadata) (it InvalidType, err error) {
+	return r.withMetadata(meta).PreCheckBeforeResendVerificationCode(objName)
+}
+
+// ResendVerificationCodeWithMetadata 重发验证码, 请求元数据(IP、业务场景等)随历史记录一并保存
+func (r VerificationCodeRdb) ResendVerificationCodeWithMetadata(objName string, newCode string, meta RequestMetadata) (code string, reused bool, err error) {
+	return r.withMetadata(meta).ResendVerificationCode(objName, newCode)
+}
+
+// IssueTicket 为已通过验证的对象签发票据(短期有效、一次性使用的HMAC签名凭证), 需在可选配置项中配置票据策略
+// 一般无需直接调用, 使用VerifyAndUseVerificationCodeWithTicket在核销成功时签发即可
+func (r VerificationCodeRdb) IssueTicket(objName string, scene string) (ticket string, err error) {
+	if objName, err = r.normalizeSubject(objName); err != nil {
+		return "", err
+	}
+	return r.issueTicket(objName, scene)
+}
+
+// VerifyAndUseVerificationCodeWithTicket 核销验证码, 核销成功时签发绑定对象与业务场景的票据, 需在可选配置项中配置票据策略
+func (r VerificationCodeRdb) VerifyAndUseVerificationCodeWithTicket(objName string, verCode string, scene string) (exist bool, success bool, ticket string, err error) {
+	if r, objName, err = r.prepareSubject(objName); err != nil {
+		return false, false, "", err
+	}
+	return r.verifyAndUseVerificationCodeWithTicket(objName, verCode, scene)
+}
+
+// VerifyAndUseVerificationCodeWithTicketAndMetadata 核销验证码并在成功时签发票据, 请求元数据(IP、业务场景等)随历史记录一并保存
+func (r VerificationCodeRdb) VerifyAndUseVerificationCodeWithTicketAndMetadata(objName string, verCode string, scene string, meta RequestMetadata) (exist bool, success bool, ticket string, err error) {
+	return r.withMetadata(meta).VerifyAndUseVerificationCodeWithTicket(objName, verCode, scene)
+}
+
+// IsTicketEnabled 是否配置了票据策略. 未配置时签发及兑换票据均返回ErrTicketDisabled
+func (r VerificationCodeRdb) IsTicketEnabled() bool {
+	return r.ticket != nil
+}
+
+// RedeemTicket 兑换票据, 返回票据绑定的对象名称(规范化后). 每张票据仅能兑换一次
+// 签名不合法返回ErrInvalidTicket, 已过期返回ErrTicketExpired, 业务场景不一致返回ErrTicketSceneMismatch, 已兑换返回ErrTicketAlreadyUsed
+func (r VerificationCodeRdb) RedeemTicket(ticket string, scene string) (subject string, err error) {
+	return r.redeemTicket(ticket, scene)
+}
+
+// VerifyAndUseVerificationCodeWithDeviceToken 核销验证码, 核销成功时为设备签发令牌("记住此设备"), 需在可选配置项中配置受信任设备策略
+// fingerprint: 设备指纹(由调用方根据设备信息生成), 令牌仅在同一对象及同一设备指纹下有效
+func (r VerificationCodeRdb) VerifyAndUseVerificationCodeWithDeviceToken(objName string, verCode string, fingerprint string) (exist bool, success bool, token string, err error) {
+	if r, objName, err = r.prepareSubject(objName); err != nil {
+		return false, false, "", err
+	}
+	return r.verifyAndUseVerificationCodeWithDeviceToken(objName, verCode, fingerprint)
+}
+
+// IssueDeviceToken 为已通过验证的对象的设备签发令牌. 一般无需直接调用, 使用VerifyAndUseVerificationCodeWithDeviceToken即可
+func (r VerificationCodeRdb) IssueDeviceToken(objName string, fingerprint string) (token string, err error) {
+	if objName, err = r.normalizeSubject(objName); err != nil {
+		return "", err
+	}
+	return r.issueDeviceToken(objName, fingerprint)
+}
+
+// CheckDeviceToken 校验设备令牌, 令牌有效且与对象及设备指纹一致时返回true, 此时可跳过短信验证
+func (r VerificationCodeRdb) CheckDeviceToken(objName string, fingerprint string, token string) (trusted bool, err error) {
+	if objName, err = r.normalizeSubject(objName); err != nil {
+		return false, err
+	}
+	return r.checkDeviceToken(objName, fingerprint, token)
+}
+
+// RevokeDeviceToken 吊销单个设备令牌(例如用户在该设备上退出登录). existed: 吊销前是否存在
+func (r VerificationCodeRdb) RevokeDeviceToken(objName string, token string) (existed bool, err error) {
+	return r.RevokeTrustedDevice(objName, deviceDigest(token))
+}
+
+// RevokeTrustedDevice 根据设备ID(ListTrustedDevices返回)吊销对象的单个受信任设备. existed: 吊销前是否存在
+func (r VerificationCodeRdb) RevokeTrustedDevice(objName string, deviceId string) (existed bool, err error) {
+	if objName, err = r.normalizeSubject(objName); err != nil {
+		return false, err
+	}
+	return r.revokeTrustedDevice(objName, deviceId)
+}
+
+// RevokeAllTrustedDevices 吊销对象的全部受信任设备(例如修改密码或手机号码后), 返回吊销的数量
+func (r VerificationCodeRdb) RevokeAllTrustedDevices(objName string) (int, error) {
+	objName, err := r.normalizeSubject(objName)
+	if err != nil {
+		return 0, err
+	}
+	return r.revokeAllTrustedDevices(objName)
+}
+
+// ListTrustedDevices 列出对象全部未过期的受信任设备(按签发时间升序排列)
+func (r VerificationCodeRdb) ListTrustedDevices(objName string) ([]TrustedDevice, error) {
+	objName, err := r.normalizeSubject(objName)
+	if err != nil {
+		return nil, err
+	}
+	return r.listTrustedDevices(objName)
+}
+
+// QueryHistory 分页查询对象在[from, to]时间范围内的历史记录(按时间升序排列), 需在可选配置项中开启历史记录
+// cursor: 上一页返回的游标, 首页传空字符串. limit: 每页条数, 小于等于0时默认为20. 返回的nextCursor为空时说明已无更多记录
+func (r VerificationCodeRdb) QueryHistory(objName string, from time.Time, to time.Time, cursor string, limit int) (records []HistoryRecord, nextCursor string, err error) {
+	return r.queryHistory(objName, from, to, cursor, limit)
+}
+
+// RecordAdminAction 记录针对对象的管理员操作(例如解封、重置计数), 需在可选配置项中开启历史记录
+func (r VerificationCodeRdb) RecordAdminAction(objName string, operation string, detail map[string]string) error {
+	return r.recordAdminAction(objName, operation, detail)
+}
+
 // CheckIsUnusedCodeTooMany 判断当日未使用的验证码是否过多(用于防止恶意刷接口) threshold: 阈值
 // 一般在请求验证码和核销验证码前调用判断
 func (r VerificationCodeRdb) CheckIsUnusedCodeTooMany(objName string) (bool, error) {
+	r, objName, err := r.prepareSubject(objName)
+	if err != nil {
+		return false, err
+	}
 	return r.checkIsUnusedCodeTooMany(objName, r.strategy.DenyThresholdOfUnusedCode)
 }
 
@@ -83,31 +345,54 @@ func (r VerificationCodeRdb) CheckIsUnusedCodeTooMany(objName string) (bool, err
 // 若上一次请求的验证码尚未被核销，且当前时间距离上次请求的时间差小于等于阈值，则返回true.
 // 一般在请求验证码前调用判断
 func (r VerificationCodeRdb) CheckIsRequestTooFrequently(objName string) (bool, error) {
+	r, objName, err := r.prepareSubject(objName)
+	if err != nil {
+		return false, err
+	}
 	return r.checkIsRequestTooFrequently(objName, r.strategy.RequestTimeIntervalThreshold)
 }
 
 // CheckIsVerifyFailTooFrequently 判断用户是否验证错误过于频繁
 func (r VerificationCodeRdb) CheckIsVerifyFailTooFrequently(objName string) (bool, error) {
+	r, objName, err := r.prepareSubject(objName)
+	if err != nil {
+		return false, err
+	}
 	return r.checkIsVerifyFailTooFrequently(objName, r.strategy.DenyThresholdOfFailedCount, r.strategy.TemporarilyBanStrategy)
 }
 
 // QueryErrorsCountToday 查询用户当日失败的次数
 func (r VerificationCodeRdb) QueryErrorsCountToday(objName string) (int, error) {
+	objName, err := r.normalizeSubject(objName)
+	if err != nil {
+		return 0, err
+	}
 	return r.queryErrorsCountToday(objName)
 }
 
 // QueryLastErrorTime 查询用户最后一次验证失败的时间
 func (r VerificationCodeRdb) QueryLastErrorTime(objName string) (exist bool, lastTime time.Time, err error) {
+	if objName, err = r.normalizeSubject(objName); err != nil {
+		return false, time.Time{}, err
+	}
 	return r.queryLastErrorTime(objName)
 }
 
 // QueryCountOfUnusedVerificationCode 查询用户当日未核销的验证码数量
 func (r VerificationCodeRdb) QueryCountOfUnusedVerificationCode(objName string) (int, error) {
+	objName, err := r.normalizeSubject(objName)
+	if err != nil {
+		return 0, err
+	}
 	return r.queryCountOfUnusedVerificationCode(objName)
 }
 
 // QueryVerificationCodeTTL 查询验证码剩余的有效时长(单位为秒)
 func (r VerificationCodeRdb) QueryVerificationCodeTTL(objName string) (int64, error) {
+	objName, err := r.normalizeSubject(objName)
+	if err != nil {
+		return 0, err
+	}
 	return r.queryVerificationCodeTTL(objName)
 }
 
@@ -115,9 +400,178 @@ func (r VerificationCodeRdb) QueryVerificationCodeTTL(objName string) (int64, er
 // invalid: 验证码是否已失效. 若invalid为true，则说明验证码已失效, period的大小无意义
 // period: 验证码已等待核销的时长, 单位为秒
 func (r VerificationCodeRdb) QueryVerificationCodeRegisteredPeriod(objName string) (invalid bool, period int64, err error) {
+	if objName, err = r.normalizeSubject(objName); err != nil {
+		return true, 0, err
+	}
 	return r.queryVerificationCodeRegisteredPeriod(objName)
 }
 
+// QueryRetryAfter 查询对象因违规类型it被拒绝后, 距离可再次请求的剩余时长. 无法确定时返回0
+// 请求过于频繁: 距离请求间隔阈值届满的时长; 核销失败过于频繁: 距离封禁结束的时长; 未核销的验证码过多: 距离第二天零时的时长;
+// 发送预算耗尽: 距离预算恢复(下一小时或第二天零时)的时长
+func (r VerificationCodeRdb) QueryRetryAfter(objName string, it InvalidType) (time.Duration, error) {
+	r, objName, err := r.prepareSubject(objName)
+	if err != nil {
+		return 0, err
+	}
+	return r.queryRetryAfter(objName, it)
+}
+
+// BatchPreCheckBeforeSendVerificationCode 批量进行发送验证码前的校验, 返回结果与objNames一一对应(ObjName为规范化前的对象名称)
+// 通过管道在一次往返中完成全部查询. 单个对象查询失败时错误记录于对应结果的Err中, 不影响其他对象. 批量校验不计入统计数据
+func (r VerificationCodeRdb) BatchPreCheckBeforeSendVerificationCode(objNames []string) []BatchPreCheckResult {
+	return r.batchPreCheck(OperationPreCheckBeforeSend, objNames)
+}
+
+// BatchPreCheckBeforeVerifyAndUseVerificationCode 批量进行核销验证码前的校验, 返回结果与objNames一一对应(ObjName为规范化前的对象名称)
+// 通过管道在一次往返中完成全部查询. 单个对象查询失败时错误记录于对应结果的Err中, 不影响其他对象. 批量校验不计入统计数据
+func (r VerificationCodeRdb) BatchPreCheckBeforeVerifyAndUseVerificationCode(objNames []string) []BatchPreCheckResult {
+	return r.batchPreCheck(OperationPreCheckBeforeVerify, objNames)
+}
+
+// BatchQueryVerificationStatus 批量查询对象的验证码状态(即各Query*方法的查询结果), 返回结果与objNames一一对应(ObjName为规范化前的对象名称)
+// 通过管道在一次往返中完成全部查询. 单个对象查询失败时错误记录于对应结果的Err中; 整体执行失败(例如redis不可用)时返回error
+func (r VerificationCodeRdb) BatchQueryVerificationStatus(objNames []string) ([]VerificationStatus, error) {
+	return r.batchQueryVerificationStatus(objNames)
+}
+
+// QueryDailyStatistics 查询业务模块在[from, to]日期范围内每日的统计数据(按日期升序排列)
+// 包括下发数量、核销成功/失败次数、各违规类型的拒绝次数以及去重对象数量. 需在可选配置项中开启统计
+func (r VerificationCodeRdb) QueryDailyStatistics(from time.Time, to time.Time) ([]DailyStatistics, error) {
+	return r.queryDailyStatistics(from, to)
+}
+
+// QueryVerificationStatus 查询对象的验证码状态(即各Query*方法的查询结果)
+func (r VerificationCodeRdb) QueryVerificationStatus(objName string) (VerificationStatus, error) {
+	return r.queryVerificationStatus(objName)
+}
+
+// Unban 解除对象当日因核销失败导致的封禁(删除当日失败次数与最后一次失败的时间)
+func (r VerificationCodeRdb) Unban(objName string) error {
+	objName, err := r.normalizeSubject(objName)
+	if err != nil {
+		return err
+	}
+	return r.unban(objName)
+}
+
+// ResetCounters 重置对象当日的全部计数(未核销的验证码集合、失败次数、最后一次失败的时间). 当前有效的验证码不受影响
+func (r VerificationCodeRdb) ResetCounters(objName string) error {
+	objName, err := r.normalizeSubject(objName)
+	if err != nil {
+		return err
+	}
+	return r.resetCounters(objName)
+}
+
+// Revoke 吊销对象当前有效的验证码. existed: 吊销前是否存在有效的验证码
+func (r VerificationCodeRdb) Revoke(objName string) (existed bool, err error) {
+	if objName, err = r.normalizeSubject(objName); err != nil {
+		return false, err
+	}
+	return r.revoke(objName)
+}
+
+// ForgetSubject 清除对象的全部数据(验证码、各日的计数与封禁状态、策略覆盖项、受信任设备、历史记录等), 用于响应个人信息删除请求
+// 仅清除本业务模块的数据, 完整清除一个对象所需的全部调用见VerificationCodeForgetInterface; 降级模式下返回ErrRedisUnavailable
+func (r VerificationCodeRdb) ForgetSubject(objName string) (ForgetReport, error) {
+	objName, err := r.normalizeSubject(objName)
+	if err != nil {
+		return ForgetReport{}, err
+	}
+	return r.forgetSubject(objName)
+}
+
+// SetStrategyOverride 设置对象的策略覆盖项(为nil的字段沿用业务模块的策略), ttl到期后自动失效. 重复设置时整体替换
+// 需在可选配置项中开启StrategyOverride后才会在校验时生效
+func (r VerificationCodeRdb) SetStrategyOverride(objName string, override StrategyOverride, ttl time.Duration) error {
+	objName, err := r.normalizeSubject(objName)
+	if err != nil {
+		return err
+	}
+	return r.setStrategyOverride(objName, override, ttl)
+}
+
+// ClearStrategyOverride 清除对象的策略覆盖项. existed: 清除前是否存在
+func (r VerificationCodeRdb) ClearStrategyOverride(objName string) (existed bool, err error) {
+	if objName, err = r.normalizeSubject(objName); err != nil {
+		return false, err
+	}
+	return r.clearStrategyOverride(objName)
+}
+
+// QueryStrategyOverride 查询对象的策略覆盖项
+func (r VerificationCodeRdb) QueryStrategyOverride(objName string) (exist bool, override SubjectStrategyOverride, err error) {
+	if objName, err = r.normalizeSubject(objName); err != nil {
+		return false, SubjectStrategyOverride{}, err
+	}
+	return r.queryStrategyOverride(objName)
+}
+
+// ListStrategyOverrides 列出业务模块全部未过期的策略覆盖项(按过期时间升序排列)
+func (r VerificationCodeRdb) ListStrategyOverrides() ([]SubjectStrategyOverride, error) {
+	return r.listStrategyOverrides()
+}
+
+// QueryEffectiveStrategy 查询对象实际生效的策略(业务模块的策略合并对象的策略覆盖项)
+func (r VerificationCodeRdb) QueryEffectiveStrategy(objName string) (StrategySnapshot, error) {
+	objName, err := r.normalizeSubject(objName)
+	if err != nil {
+		return StrategySnapshot{}, err
+	}
+	exist, item, err := r.queryStrategyOverride(objName)
+	if err != nil || !exist {
+		return r.strategy.Snapshot(), err
+	}
+	return item.Override.Apply(r.strategy).Snapshot(), nil
+}
+
+// SaveStrategy 将当前策略持久化至redis, 供其他实例通过ReloadStrategy加载
+func (r VerificationCodeRdb) SaveStrategy() error {
+	return r.saveStrategy()
+}
+
+// QueryPersistedStrategy 查询持久化于redis中的策略
+func (r VerificationCodeRdb) QueryPersistedStrategy() (exist bool, snapshot StrategySnapshot, err error) {
+	return r.queryPersistedStrategy()
+}
+
+// ExportState 以JSON lines格式导出业务模块的全部状态(验证码、计数、封禁、策略覆盖项、历史记录等)及其剩余有效期, 用于迁移redis或离线排查
+// 使用SCAN遍历字段, 不会长时间阻塞redis
+func (r VerificationCodeRdb) ExportState(w io.Writer, opt ExportOptions) (ExportReport, error) {
+	return r.exportState(w, opt)
+}
+
+// ImportState 恢复ExportState导出的状态(可恢复至其他业务模块), 剩余有效期扣减导出至今的时长
+func (r VerificationCodeRdb) ImportState(rd io.Reader, opt ImportOptions) (ImportReport, error) {
+	return r.importState(rd, opt)
+}
+
+// ReloadStrategy 使用持久化于redis中的策略替换当前策略, 不存在持久化的策略时保持不变. 与其他方法并发调用时需由调用方加锁
+func (r *VerificationCodeRdb) ReloadStrategy() (loaded bool, err error) {
+	return r.reloadStrategy()
+}
+
+// QueryBudgetUsage 查询业务模块当日及当前小时的发送预算用量
+func (r VerificationCodeRdb) QueryBudgetUsage() (BudgetUsage, error) {
+	return r.queryBudgetUsage()
+}
+
+// QueryLocation 查询业务模块划分自然日所用的时区
+func (r VerificationCodeRdb) QueryLocation() *time.Location {
+	return r.location()
+}
+
+// NormalizeSubject 使用业务模块配置的SubjectNormalizer规范化对象名称, 未配置时原样返回
+func (r VerificationCodeRdb) NormalizeSubject(objName string) (string, error) {
+	return r.normalizeSubject(objName)
+}
+
+// HasSubjectNormalizer 是否配置了SubjectNormalizer
+func (r VerificationCodeRdb) HasSubjectNormalizer() bool {
+	return r.normalizer != nil
+}
+
 // QueryValidityDuration 查询验证码的默认有效期
 func (r VerificationCodeRdb) QueryValidityDuration() int64 {
 	return r.strategy.QueryValidityDuration()
@@ -172,3 +626,43 @@ func (r *VerificationCodeRdb) ModifyDenyThresholdOfFailedCount(threshold int) {
 func (r *VerificationCodeRdb) ModifyDenyThresholdOfUnusedCode(threshold int) {
 	r.strategy.ModifyDenyThresholdOfUnusedCode(threshold)
 }
+
+// QueryCodeComparisonStrategy 查询验证码比对策略
+func (r VerificationCodeRdb) QueryCodeComparisonStrategy() CodeComparisonStrategy {
+	return r.strategy.QueryCodeComparisonStrategy()
+}
+
+// ModifyCodeComparisonStrategy 修改验证码比对策略
+func (r *VerificationCodeRdb) ModifyCodeComparisonStrategy(cs CodeComparisonStrategy) {
+	r.strategy.ModifyCodeComparisonStrategy(cs)
+}
+
+// QueryResendStrategy 查询重发验证码的策略
+func (r VerificationCodeRdb) QueryResendStrategy() ResendStrategy {
+	return r.strategy.QueryResendStrategy()
+}
+
+// ModifyResendStrategy 修改重发验证码的策略
+func (r *VerificationCodeRdb) ModifyResendStrategy(rs ResendStrategy) {
+	r.strategy.ModifyResendStrategy(rs)
+}
+
+// QueryExtendStrategy 查询延长验证码有效期的策略
+func (r VerificationCodeRdb) QueryExtendStrategy() ExtendStrategy {
+	return r.strategy.QueryExtendStrategy()
+}
+
+// ModifyExtendStrategy 修改延长验证码有效期的策略
+func (r *VerificationCodeRdb) ModifyExtendStrategy(es ExtendStrategy) {
+	r.strategy.ModifyExtendStrategy(es)
+}
+
+// QueryRules 查询操作(OperationPreCheckBeforeSend/OperationPreCheckBeforeVerify)对应的规则链
+func (r VerificationCodeRdb) QueryRules(op DegradableOperation) []Rule {
+	return r.queryRules(op)
+}
+
+// ModifyRules 替换操作(OperationPreCheckBeforeSend/OperationPreCheckBeforeVerify)对应的规则链, rules为nil时恢复为默认规则链. 与其他方法并发调用时需由调用方加锁
+func (r *VerificationCodeRdb) ModifyRules(op DegradableOperation, rules []Rule) error {
+	return r.modifyRules(op, rules)
+}
@@ -1,9 +1,16 @@
 package verification_code_rdb
 
 import (
+	"bytes"
 	"context"
+	"github.com/DontBeProud/wow-easy-go/utils/wow_time"
 	"github.com/go-redis/redis/v8"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 const (
@@ -102,6 +109,33 @@ func TestUseVerificationCode(t *testing.T) {
 	clear(rdb)
 }
 
+func TestConcurrentVerifyAndUse(t *testing.T) {
+	if err := rdb.SetAndRegisterVerificationCode(testPhoneNum, testVerCode); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// 并发核销同一验证码, 仅有一个请求可核销成功
+	var wg sync.WaitGroup
+	var succeeded int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, success, _ := rdb.VerifyAndUseVerificationCode(testPhoneNum, testVerCode); success {
+				atomic.AddInt32(&succeeded, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if succeeded != 1 {
+		t.Error("并发核销有bug")
+	}
+	if cnt, _ := rdb.queryCountOfUnusedVerificationCode(testPhoneNum); cnt != 0 {
+		t.Error("并发核销有bug")
+	}
+	clear(rdb)
+}
+
 func TestVerifyError(t *testing.T) {
 	err := rdb.SetAndRegisterVerificationCode(testPhoneNum, testVerCode)
 	if err != nil {
@@ -152,9 +186,1293 @@ func TestUncheckedVerCode(t *testing.T) {
 	clear(rdb)
 }
 
+func TestCodeComparisonStrategy(t *testing.T) {
+	cs := CodeComparisonStrategy{FoldWidth: true, StripSeparators: true, CaseInsensitive: true}
+	if !cs.Equal("12ab56", " １２ＡＢ-56 ") {
+		t.Error("验证码比对模块有bug")
+	}
+	if cs.Equal("12ab56", "12ab57") {
+		t.Error("验证码比对模块有bug")
+	}
+	if (CodeComparisonStrategy{}).Equal("123456", " 123456") {
+		t.Error("验证码比对模块有bug")
+	}
+}
+
+func TestPhoneNumberNormalizer(t *testing.T) {
+	n := PhoneNumberNormalizer{}
+	for _, raw := range []string{"+86 138-0013-8000", "8613800138000", "13800138000", "0086 13800138000", "+86 (138) 0013 8000"} {
+		if res, err := n.Normalize(raw); err != nil || res != "+8613800138000" {
+			t.Error("手机号规范化模块有bug: " + raw)
+		}
+	}
+	for _, raw := range []string{"666", "+86 10086", "1380013800a"} {
+		if _, err := n.Normalize(raw); err == nil {
+			t.Error("手机号规范化模块有bug: " + raw)
+		}
+	}
+}
+
+func TestEmailNormalizer(t *testing.T) {
+	if res, err := (EmailNormalizer{}).Normalize(" Foo.Bar+x@GMail.com "); err != nil || res != "foo.bar+x@gmail.com" {
+		t.Error("邮箱规范化模块有bug")
+	}
+	if res, err := (EmailNormalizer{FoldGmail: true}).Normalize("Foo.Bar+x@googlemail.com"); err != nil || res != "foobar@gmail.com" {
+		t.Error("邮箱规范化模块有bug")
+	}
+	if _, err := (EmailNormalizer{}).Normalize("12#163.com"); err == nil {
+		t.Error("邮箱规范化模块有bug")
+	}
+}
+
+func TestBatchPreCheck(t *testing.T) {
+	_ = rdb.SetAndRegisterVerificationCode(testPhoneNum, testVerCode)
+	results := rdb.BatchPreCheckBeforeSendVerificationCode([]string{testPhoneNum, testPhoneNum + "Other"})
+	if results[0].Err != nil || results[0].InvalidType != InvalidTypeRequestTooFrequently {
+		t.Error("批量校验模块有bug")
+	}
+	if results[1].Err != nil || results[1].InvalidType != UserIsValid {
+		t.Error("批量校验模块有bug")
+	}
+
+	statusList, err := rdb.BatchQueryVerificationStatus([]string{testPhoneNum})
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if !statusList[0].CodeExist || statusList[0].UnusedCodeCount != 1 {
+		t.Error("批量查询模块有bug")
+	}
+	clear(rdb)
+}
+
+func TestDegradationPolicy(t *testing.T) {
+	events := make(chan VerificationEvent, 1)
+	unavailable := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})
+	dRdb, err := CreateVerificationCodeRdbWithOptionalConfig(unavailable, "SMS", *strategy, &VerificationCodeRdbOptionalConfig{
+		DegradationStrategy: &DegradationStrategy{
+			Policies: map[DegradableOperation]DegradationPolicy{
+				OperationPreCheckBeforeSend: DegradationPolicyFailOpen,
+				OperationSetAndRegister:     DegradationPolicyFailOpen,
+				OperationVerifyAndUse:       DegradationPolicyFailOpen,
+			},
+			LocalLimiterThreshold: 1,
+		},
+		EventHandler: func(event VerificationEvent) {
+			events <- event
+		},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer dRdb.Close()
+
+	if !dRdb.IsDegraded() || (<-events).Type != EventTypeRedisUnavailable {
+		t.Error("降级模块有bug")
+	}
+
+	if it, _ := dRdb.PreCheckBeforeSendVerificationCode(testPhoneNum); it != UserIsValid {
+		t.Error("降级模块有bug")
+	}
+	if it, _ := dRdb.PreCheckBeforeSendVerificationCode(testPhoneNum); it != InvalidTypeRequestTooFrequently {
+		t.Error("本地限流器有bug")
+	}
+	if it, err := dRdb.PreCheckBeforeVerifyAndUseVerificationCode(testPhoneNum); it != InvalidTypeServiceUnavailable || err != ErrRedisUnavailable {
+		t.Error("降级模块有bug")
+	}
+
+	_ = dRdb.SetAndRegisterVerificationCode(testPhoneNum, testVerCode)
+	if _, success, _ := dRdb.VerifyAndUseVerificationCode(testPhoneNum, testVerCode); !success {
+		t.Error("降级模块有bug")
+	}
+
+	// 本地暂存的验证码失败次数达到阈值后作废
+	_ = dRdb.SetAndRegisterVerificationCode(testPhoneNum, testVerCode)
+	for i := 0; i < strategy.DenyThresholdOfFailedCount; i++ {
+		if exist, success, _ := dRdb.VerifyAndUseVerificationCode(testPhoneNum, testVerCode+"x"); !exist || success {
+			t.Error("降级模块有bug")
+		}
+	}
+	if exist, success, _ := dRdb.VerifyAndUseVerificationCode(testPhoneNum, testVerCode); exist || success {
+		t.Error("本地暂存的验证码失败次数限制有bug")
+	}
+}
+
+func TestDegradedBatchPreCheck(t *testing.T) {
+	unavailable := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})
+	dRdb, err := CreateVerificationCodeRdbWithOptionalConfig(unavailable, "SMS", *strategy, &VerificationCodeRdbOptionalConfig{
+		DegradationStrategy: &DegradationStrategy{
+			Policies:              map[DegradableOperation]DegradationPolicy{OperationPreCheckBeforeSend: DegradationPolicyFailOpen},
+			LocalLimiterThreshold: 1,
+		},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer dRdb.Close()
+
+	// 批量校验仅查询状态, 不占用本地限流器的计数
+	for i := 0; i < 2; i++ {
+		if res := dRdb.BatchPreCheckBeforeSendVerificationCode([]string{testPhoneNum}); res[0].InvalidType != UserIsValid {
+			t.Error("降级模式下的批量校验有bug")
+		}
+	}
+	if it, _ := dRdb.PreCheckBeforeSendVerificationCode(testPhoneNum); it != UserIsValid {
+		t.Error("批量校验占用了本地限流器的计数")
+	}
+	if res := dRdb.BatchPreCheckBeforeSendVerificationCode([]string{testPhoneNum}); res[0].InvalidType != InvalidTypeRequestTooFrequently {
+		t.Error("降级模式下的批量校验有bug")
+	}
+}
+
+func TestParseDailyStatistics(t *testing.T) {
+	st := parseDailyStatistics("20211201", map[string]string{
+		statisticsFieldIssued:   "10",
+		statisticsFieldVerified: "6",
+		statisticsFieldFailed:   "3",
+		statisticsFieldRejectedPrefix + strconv.Itoa(InvalidTypeRequestTooFrequently): "2",
+	}, 8)
+	if st.IssuedCount != 10 || st.VerifiedCount != 6 || st.FailedCount != 3 || st.UniqueSubjects != 8 {
+		t.Error("统计模块有bug")
+	}
+	if st.RejectedCount[InvalidTypeRequestTooFrequently] != 2 {
+		t.Error("统计模块有bug")
+	}
+}
+
+func TestLocation(t *testing.T) {
+	// UTC 2021-12-31 16:30 即北京时间 2022-01-01 00:30, 按北京时间划分自然日
+	clock := wow_time.CreateFakeClock(time.Date(2021, 12, 31, 16, 30, 0, 0, time.UTC))
+	chinaRdb := VerificationCodeRdb{ModuleName: "SMS", clock: clock, loc: wow_time.LocationChina}
+	if !strings.HasSuffix(chinaRdb.getRedisFieldNameVerificationCodeSet(testPhoneNum), "20220101") {
+		t.Error("时区模块有bug")
+	}
+	if !chinaRdb.tomorrowZeroTime().Equal(time.Date(2022, 1, 1, 16, 0, 0, 0, time.UTC)) {
+		t.Error("时区模块有bug")
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	clock := wow_time.CreateFakeClock(time.Date(2021, 12, 31, 23, 59, 59, 0, time.Local))
+	fakeRdb := VerificationCodeRdb{ModuleName: "SMS", clock: clock}
+
+	// 跨日后按日存储的字段名称随之切换
+	before := fakeRdb.getRedisFieldNameVerificationCodeErrorCount(testPhoneNum)
+	clock.Advance(time.Second)
+	if after := fakeRdb.getRedisFieldNameVerificationCodeErrorCount(testPhoneNum); after == before || !strings.HasSuffix(after, "20220101") {
+		t.Error("跨日模块有bug")
+	}
+
+	// 临时封禁到期
+	lastErrTime := clock.Now()
+	clock.Advance(40 * time.Second)
+	if !judgeIsTemporarilyBanned(3, lastErrTime, fakeRdb.now(), strategy.TemporarilyBanStrategy) {
+		t.Error("临时封禁模块有bug")
+	}
+	clock.Advance(time.Second)
+	if judgeIsTemporarilyBanned(3, lastErrTime, fakeRdb.now(), strategy.TemporarilyBanStrategy) {
+		t.Error("临时封禁模块有bug")
+	}
+}
+
+func TestBanDetailIfReached(t *testing.T) {
+	fakeRdb := VerificationCodeRdb{ModuleName: "SMS", strategy: *strategy}
+	if d := fakeRdb.banDetailIfReached(3); d["duration"] != "40" {
+		t.Error("封禁事件有bug")
+	}
+	if d := fakeRdb.banDetailIfReached(4); d != nil {
+		t.Error("封禁事件有bug")
+	}
+	if d := fakeRdb.banDetailIfReached(strategy.DenyThresholdOfFailedCount); d["until"] != "tomorrow" {
+		t.Error("封禁事件有bug")
+	}
+}
+
+func TestIsRiskThreshold(t *testing.T) {
+	fakeRdb := VerificationCodeRdb{ModuleName: "SMS", strategy: *strategy, riskThresholds: []int{2, 4}}
+	if !fakeRdb.isRiskThreshold(2) || !fakeRdb.isRiskThreshold(4) {
+		t.Error("风险阈值事件有bug")
+	}
+	if fakeRdb.isRiskThreshold(1) || fakeRdb.isRiskThreshold(3) || fakeRdb.isRiskThreshold(5) {
+		t.Error("风险阈值事件有bug")
+	}
+	if (VerificationCodeRdb{}).isRiskThreshold(2) {
+		t.Error("风险阈值事件有bug")
+	}
+}
+
 func clear(r *VerificationCodeRdb) {
 	r.rDb.Del(context.TODO(), r.getRedisFieldNameVerificationCodeErrorCount(testPhoneNum))
 	r.rDb.Del(context.TODO(), r.getRedisFieldNameVerificationCodeLastFailedTime(testPhoneNum))
 	r.rDb.Del(context.TODO(), r.getRedisFieldNameVerificationCodeSet(testPhoneNum))
 	r.rDb.Del(context.TODO(), r.getRedisFieldNameVerificationCode(testPhoneNum))
 }
+
+func TestRegistry(t *testing.T) {
+	registry, err := CreateVerificationCodeRdbRegistry(r, "Registry", *strategy, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer registry.Close()
+
+	tenantStrategy := copyStrategy(*strategy)
+	tenantStrategy.ValidityDuration = 60
+	if err = registry.SetTenantStrategy("tenantA", tenantStrategy); err != nil {
+		t.Fatal(err.Error())
+	}
+	if s, isDefault := registry.QueryTenantStrategy("tenantA"); isDefault || s.ValidityDuration != 60 {
+		t.Error("租户策略有bug")
+	}
+	if _, isDefault := registry.QueryTenantStrategy("tenantB"); !isDefault {
+		t.Error("默认策略有bug")
+	}
+
+	a, _ := registry.Tenant("tenantA")
+	b, _ := registry.Tenant("tenantB")
+	if a.ModuleName == b.ModuleName || a.QueryValidityDuration() != 60 {
+		t.Error("租户隔离有bug")
+	}
+
+	if err = registry.SetAndRegisterVerificationCode("tenantA", testPhoneNum, testVerCode); err != nil {
+		t.Fatal(err.Error())
+	}
+	if exist, _, _ := registry.VerifyAndUseVerificationCode("tenantB", testPhoneNum, testVerCode); exist {
+		t.Error("租户隔离有bug")
+	}
+	if _, success, _ := registry.VerifyAndUseVerificationCode("tenantA", testPhoneNum, testVerCode); !success {
+		t.Error("租户路由有bug")
+	}
+
+	// 修改已获取的Rdb或传入的策略的短期禁止策略, 不影响其他租户及注册表保存的策略
+	a.AddTemporarilyBanStrategy(1, 3600)
+	tenantStrategy.AddTemporarilyBanStrategy(2, 3600)
+	for _, tenantId := range []string{"tenantA", "tenantB"} {
+		s, _ := registry.QueryTenantStrategy(tenantId)
+		if _, ok := (*s.QueryTemporarilyBanStrategy())[1]; ok {
+			t.Error("租户策略共享了短期禁止策略")
+		}
+		if _, ok := (*s.QueryTemporarilyBanStrategy())[2]; ok {
+			t.Error("租户策略共享了短期禁止策略")
+		}
+	}
+	if _, ok := (*strategy.QueryTemporarilyBanStrategy())[1]; ok {
+		t.Error("租户策略共享了短期禁止策略")
+	}
+
+	for _, tenantId := range []string{"", "a@b", "aVerificationCode", "租户", strings.Repeat("a", 65)} {
+		if _, err = registry.Tenant(tenantId); err != ErrInvalidTenantId {
+			t.Error("租户ID校验有bug: " + tenantId)
+		}
+		if err = registry.SetTenantStrategy(tenantId, *strategy); err != ErrInvalidTenantId {
+			t.Error("租户ID校验有bug: " + tenantId)
+		}
+	}
+
+	registry.DelTenantStrategy("tenantA")
+	if _, isDefault := registry.QueryTenantStrategy("tenantA"); !isDefault {
+		t.Error("租户策略有bug")
+	}
+}
+
+// 需以 -race 运行
+func TestRegistryConcurrentDefaultStrategy(t *testing.T) {
+	unavailable := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})
+	registry, err := CreateVerificationCodeRdbRegistry(unavailable, "SMS", *strategy, &VerificationCodeRdbOptionalConfig{
+		DegradationStrategy: &DegradationStrategy{},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer registry.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			s := copyStrategy(*strategy)
+			s.ValidityDuration = int64(60 + i)
+			registry.SetDefaultStrategy(s)
+		}(i)
+		go func() {
+			defer wg.Done()
+			if tRdb, err := registry.Tenant("tenantA"); err != nil || tRdb.QueryValidityDuration() <= 0 {
+				t.Error("并发修改默认策略有bug")
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 修改默认策略后获取的Rdb使用新的默认策略
+	s := copyStrategy(*strategy)
+	s.ValidityDuration = 30
+	registry.SetDefaultStrategy(s)
+	if tRdb, _ := registry.Tenant("tenantA"); tRdb.QueryValidityDuration() != 30 || registry.QueryDefaultStrategy().ValidityDuration != 30 {
+		t.Error("修改默认策略有bug")
+	}
+}
+
+func TestHistoryRecord(t *testing.T) {
+	rec := HistoryRecord{
+		Action:      HistoryActionVerify,
+		ObjName:     testPhoneNum,
+		Outcome:     HistoryOutcomeFail,
+		MaskedInput: maskInput("123456"),
+		Metadata:    RequestMetadata{IP: "10.0.0.1", Scene: "login", Extra: map[string]string{"channel": "app"}},
+		Detail:      map[string]string{"operation": "unban"},
+	}
+	values := formatHistoryRecord(rec)
+	if _, ok := values[historyFieldUserAgent]; ok {
+		t.Error("历史记录格式化有bug")
+	}
+
+	raw := map[string]interface{}{}
+	for k, v := range values {
+		raw[k] = v
+	}
+	parsed := parseHistoryRecord("1700000000000-3", raw, time.UTC)
+	if parsed.MaskedInput != "******" || parsed.Outcome != HistoryOutcomeFail || parsed.Metadata.Scene != "login" ||
+		parsed.Metadata.Extra["channel"] != "app" || parsed.Detail["operation"] != "unban" || parsed.Time.UnixMilli() != 1700000000000 {
+		t.Error("历史记录解析有bug")
+	}
+
+	if ms, seq, err := parseStreamId("1700000000000-3"); err != nil || ms != 1700000000000 || seq != 3 {
+		t.Error("历史记录分页游标有bug")
+	}
+	if _, _, err := parseStreamId("bad"); err == nil {
+		t.Error("历史记录分页游标有bug")
+	}
+	if maskInput("12") != "**" || maskInput("１２3") != "***" {
+		t.Error("脱敏有bug")
+	}
+
+	member := historyIndexMember(1700000000000, 3)
+	if member >= historyIndexMember(1700000000000, 10) || member >= historyIndexMember(1700000000001, 0) ||
+		historyIdFromIndexMember(member) != "1700000000000-3" {
+		t.Error("历史记录索引有bug")
+	}
+}
+
+func TestQueryHistory(t *testing.T) {
+	hRdb, err := CreateVerificationCodeRdbWithOptionalConfig(r, "History", *strategy, &VerificationCodeRdbOptionalConfig{
+		HistoryStrategy: &HistoryStrategy{MaxLenPerSubject: 3},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	ctx := context.TODO()
+	other := testPhoneNum + "1"
+	r.Del(ctx, hRdb.getRedisFieldNameVerificationCodeHistory(), hRdb.getRedisFieldNameVerificationCodeHistoryIndex(testPhoneNum),
+		hRdb.getRedisFieldNameVerificationCodeHistoryIndex(other))
+
+	for i := 0; i < 4; i++ {
+		_ = hRdb.SetAndRegisterVerificationCode(testPhoneNum, testVerCode+strconv.Itoa(i))
+	}
+	_ = hRdb.SetAndRegisterVerificationCode(other, testVerCode)
+
+	from, to := time.Time{}, time.Now().Add(time.Minute)
+	page, cursor, err := hRdb.QueryHistory(testPhoneNum, from, to, "", 2)
+	if err != nil || len(page) != 2 || cursor == "" || page[0].ObjName != testPhoneNum || page[0].Action != HistoryActionIssue {
+		t.Fatal("按对象查询历史记录有bug")
+	}
+	next, cursor, err := hRdb.QueryHistory(testPhoneNum, from, to, cursor, 2)
+	if err != nil || len(next) != 1 || cursor != "" || next[0].Id == page[1].Id {
+		t.Error("历史记录分页有bug")
+	}
+
+	// 超出单个对象的上限后, 最早的记录已从流中删除
+	if r.XLen(ctx, hRdb.getRedisFieldNameVerificationCodeHistory()).Val() != 4 {
+		t.Error("单个对象的历史记录上限有bug")
+	}
+	// 索引中残留的已被淘汰的记录在查询时被跳过并清理
+	r.XDel(ctx, hRdb.getRedisFieldNameVerificationCodeHistory(), page[0].Id)
+	if records, _, _ := hRdb.QueryHistory(testPhoneNum, from, to, "", 10); len(records) != 2 ||
+		r.ZCard(ctx, hRdb.getRedisFieldNameVerificationCodeHistoryIndex(testPhoneNum)).Val() != 2 {
+		t.Error("历史记录索引的清理有bug")
+	}
+
+	if n, _ := hRdb.forgetHistory(ctx, testPhoneNum); n != 2 {
+		t.Error("删除对象的历史记录有bug")
+	}
+	if records, _, _ := hRdb.QueryHistory(other, from, to, "", 10); len(records) != 1 {
+		t.Error("删除对象的历史记录误删了其他对象的记录")
+	}
+}
+
+func TestStrategySnapshot(t *testing.T) {
+	s, _ := CreateVerificationCodeServiceStrategy(300, 60, 5, 10, &map[int]int64{3: 60})
+	s.CodeComparisonStrategy.FoldWidth = true
+
+	restored, err := s.Snapshot().ToStrategy()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if restored.ValidityDuration != 300 || restored.DenyThresholdOfFailedCount != 10 || !restored.CodeComparisonStrategy.FoldWidth ||
+		(*restored.QueryTemporarilyBanStrategy())[3] != 60 {
+		t.Error("策略快照有bug")
+	}
+
+	if _, err = (StrategySnapshot{}).ToStrategy(); err == nil {
+		t.Error("策略快照有bug")
+	}
+}
+
+func TestResendStrategy(t *testing.T) {
+	unavailable := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})
+	s := *strategy
+	s.ResendStrategy = ResendStrategy{ReuseValidCode: true, ExtendTTL: true}
+	dRdb, err := CreateVerificationCodeRdbWithOptionalConfig(unavailable, "SMS", s, &VerificationCodeRdbOptionalConfig{
+		DegradationStrategy: &DegradationStrategy{
+			Policies: map[DegradableOperation]DegradationPolicy{OperationSetAndRegister: DegradationPolicyFailOpen},
+		},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer dRdb.Close()
+
+	// 降级模式下无法查询已有的验证码, 按新验证码处理
+	if code, reused, err := dRdb.ResendVerificationCode(testPhoneNum, testVerCode); err != nil || reused || code != testVerCode {
+		t.Error("重发模块有bug")
+	}
+	if !dRdb.QueryResendStrategy().ReuseValidCode {
+		t.Error("重发策略有bug")
+	}
+
+	if st := parseDailyStatistics("20211201", map[string]string{statisticsFieldResent: "4"}, 0); st.ResentCount != 4 {
+		t.Error("统计模块有bug")
+	}
+}
+
+func TestResendReuseValidCode(t *testing.T) {
+	s, _ := strategy.Snapshot().ToStrategy()
+	s.ResendStrategy = ResendStrategy{ReuseValidCode: true, ExtendTTL: true}
+	s.ExtendStrategy = ExtendStrategy{MaxExtension: 60}
+	rRdb, err := CreateVerificationCodeRdb(r, "Resend", *s)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	ctx := context.TODO()
+	_ = rRdb.ResetCounters(testPhoneNum)
+	_, _ = rRdb.Revoke(testPhoneNum)
+
+	_ = rRdb.SetAndRegisterVerificationCode(testPhoneNum, testVerCode)
+	codeKey := rRdb.getRedisFieldNameVerificationCode(testPhoneNum)
+	r.Expire(ctx, codeKey, 200*time.Second)
+	if code, reused, err := rRdb.ResendVerificationCode(testPhoneNum, testVerCode+"1"); err != nil || !reused || code != testVerCode {
+		t.Fatal("重发时复用验证码有bug")
+	}
+	// 重置有效期需延长100秒, 超出累计延长的上限60秒, 仅延长60秒
+	if ttl := r.TTL(ctx, codeKey).Val(); ttl > 260*time.Second || ttl < 255*time.Second {
+		t.Error("重发时重置有效期的上限有bug")
+	}
+	// 上限耗尽后不再重置有效期
+	r.Expire(ctx, codeKey, 100*time.Second)
+	if _, reused, _ := rRdb.ResendVerificationCode(testPhoneNum, testVerCode+"2"); !reused || r.TTL(ctx, codeKey).Val() > 100*time.Second {
+		t.Error("重发时重置有效期的上限有bug")
+	}
+
+	if cnt, _ := rRdb.QueryCountOfUnusedVerificationCode(testPhoneNum); cnt != 1 {
+		t.Error("复用的验证码不应计入未核销的验证码数量")
+	}
+	if cnt, _ := rRdb.queryResendCountToday(testPhoneNum); cnt != 2 {
+		t.Error("重发次数有bug")
+	}
+	if _, success, _ := rRdb.VerifyAndUseVerificationCode(testPhoneNum, testVerCode); !success {
+		t.Error("复用的验证码核销有bug")
+	}
+	_ = rRdb.ResetCounters(testPhoneNum)
+}
+
+func TestBudgetStrategy(t *testing.T) {
+	b := BudgetStrategy{DailyMaxMessages: 10, HourlyMaxCost: 500, CostPerMessage: 45}
+
+	if !b.crossedSoftLimit(BudgetUsage{DailyMessages: 8}) || b.crossedSoftLimit(BudgetUsage{DailyMessages: 9}) {
+		t.Error("预算软限制有bug")
+	}
+	if !b.crossedSoftLimit(BudgetUsage{HourlyCost: 405}) || b.crossedSoftLimit(BudgetUsage{HourlyCost: 450}) {
+		t.Error("预算软限制有bug")
+	}
+
+	if daily, hourly := b.exhausted(BudgetUsage{DailyMessages: 10}); !daily || hourly {
+		t.Error("预算上限有bug")
+	}
+	if daily, hourly := b.exhausted(BudgetUsage{DailyMessages: 3, HourlyCost: 460}); daily || !hourly {
+		t.Error("预算上限有bug")
+	}
+	if daily, hourly := (BudgetStrategy{}).exhausted(BudgetUsage{DailyMessages: 1 << 40}); daily || hourly {
+		t.Error("未配置上限时不应限制")
+	}
+
+	// 配置了发送预算时, 降级模式下发送前的校验不受FailOpen影响
+	unavailable := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})
+	dRdb, err := CreateVerificationCodeRdbWithOptionalConfig(unavailable, "SMS", *strategy, &VerificationCodeRdbOptionalConfig{
+		DegradationStrategy: &DegradationStrategy{
+			Policies: map[DegradableOperation]DegradationPolicy{
+				OperationPreCheckBeforeSend:   DegradationPolicyFailOpen,
+				OperationPreCheckBeforeVerify: DegradationPolicyFailOpen,
+			},
+		},
+		BudgetStrategy: &b,
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer dRdb.Close()
+	if it, err := dRdb.PreCheckBeforeSendVerificationCode(testPhoneNum); it != InvalidTypeServiceUnavailable || err != ErrRedisUnavailable {
+		t.Error("降级模式下发送预算有bug")
+	}
+	if it, err := dRdb.PreCheckBeforeVerifyAndUseVerificationCode(testPhoneNum); it != UserIsValid || err != nil {
+		t.Error("发送预算不应影响核销前的校验")
+	}
+}
+
+func TestReserveBudget(t *testing.T) {
+	events := make(chan VerificationEvent, 10)
+	s, _ := CreateVerificationCodeServiceStrategy(300, 0, 0, 0, nil)
+	bRdb, err := CreateVerificationCodeRdbWithOptionalConfig(r, "Budget", *s, &VerificationCodeRdbOptionalConfig{
+		BudgetStrategy: &BudgetStrategy{DailyMaxMessages: 5, CostPerMessage: 45, SoftLimitRatio: 0.6},
+		EventHandler: func(event VerificationEvent) {
+			if event.Type == EventTypeBudgetSoftLimit || event.Type == EventTypeBudgetExhausted {
+				events <- event
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	now := bRdb.now()
+	r.Del(context.TODO(), bRdb.getRedisFieldNameVerificationCodeBudgetDaily(now), bRdb.getRedisFieldNameVerificationCodeBudgetHourly(now))
+
+	for i := 1; i <= 5; i++ {
+		if it, err := bRdb.PreCheckBeforeSendVerificationCode(testPhoneNum + strconv.Itoa(i)); err != nil || it != UserIsValid {
+			t.Fatal("发送预算有bug")
+		}
+		// 软限制为5*0.6=3条, 仅在首次达到时触发事件
+		if i == 3 {
+			if e := <-events; e.Type != EventTypeBudgetSoftLimit || e.Detail["daily_messages"] != "3" {
+				t.Error("预算软限制事件有bug")
+			}
+		}
+	}
+	if len(events) != 0 {
+		t.Error("预算软限制事件重复触发")
+	}
+
+	// 达到上限后拒绝发送且不再占用预算
+	if it, err := bRdb.PreCheckBeforeSendVerificationCode(testPhoneNum + "6"); err != nil || it != InvalidTypeBudgetExhausted {
+		t.Error("预算上限有bug")
+	}
+	if e := <-events; e.Type != EventTypeBudgetExhausted || e.Detail["daily_messages"] != "5" {
+		t.Error("预算耗尽事件有bug")
+	}
+	if usage, _ := bRdb.QueryBudgetUsage(); usage.DailyMessages != 5 || usage.DailyCost != 225 || usage.HourlyMessages != 5 {
+		t.Error("预算用量有bug")
+	}
+	if retryAfter, _ := bRdb.QueryRetryAfter(testPhoneNum+"6", InvalidTypeBudgetExhausted); retryAfter <= 0 {
+		t.Error("预算耗尽后的重试时间有bug")
+	}
+	r.Del(context.TODO(), bRdb.getRedisFieldNameVerificationCodeBudgetDaily(now), bRdb.getRedisFieldNameVerificationCodeBudgetHourly(now))
+}
+
+func TestTicket(t *testing.T) {
+	clock := wow_time.CreateFakeClock(time.Date(2021, 12, 1, 10, 0, 0, 0, wow_time.LocationChina))
+	tRdb, err := CreateVerificationCodeRdbWithOptionalConfig(r, "SMS", *strategy, &VerificationCodeRdbOptionalConfig{
+		Clock:          clock,
+		TicketStrategy: &TicketStrategy{Secret: []byte("secret"), TTL: time.Minute},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ticket, err := tRdb.IssueTicket(testPhoneNum, "reset_password")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if p, err := tRdb.parseTicket(ticket); err != nil || p.Subject != testPhoneNum || p.Scene != "reset_password" {
+		t.Error("票据签发有bug")
+	}
+
+	if _, err = tRdb.RedeemTicket(ticket+"x", "reset_password"); err != ErrInvalidTicket {
+		t.Error("票据签名校验有bug")
+	}
+	if _, err = tRdb.RedeemTicket(ticket, "login"); err != ErrTicketSceneMismatch {
+		t.Error("票据业务场景校验有bug")
+	}
+
+	// 其他业务模块签发的票据不可兑换
+	other := *tRdb
+	other.ModuleName = "EMAIL"
+	if _, err = other.RedeemTicket(ticket, "reset_password"); err != ErrInvalidTicket {
+		t.Error("票据业务模块校验有bug")
+	}
+
+	// 票据仅可兑换一次
+	if subject, err := tRdb.RedeemTicket(ticket, "reset_password"); err != nil || subject != testPhoneNum {
+		t.Fatal("票据兑换有bug")
+	}
+	if _, err = tRdb.RedeemTicket(ticket, "reset_password"); err != ErrTicketAlreadyUsed {
+		t.Error("票据一次性使用有bug")
+	}
+
+	another, _ := tRdb.IssueTicket(testPhoneNum, "reset_password")
+	clock.Advance(2 * time.Minute)
+	if _, err = tRdb.RedeemTicket(another, "reset_password"); err != ErrTicketExpired {
+		t.Error("票据有效期有bug")
+	}
+
+	if _, err = CreateVerificationCodeRdbWithOptionalConfig(r, "SMS", *strategy, &VerificationCodeRdbOptionalConfig{
+		TicketStrategy: &TicketStrategy{},
+	}); err == nil {
+		t.Error("未配置票据密钥时应返回错误")
+	}
+}
+
+func TestRuleChain(t *testing.T) {
+	tRdb, err := CreateVerificationCodeRdb(r, "Rule", *strategy)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(tRdb.QueryRules(OperationPreCheckBeforeSend)) != 3 || len(tRdb.QueryRules(OperationPreCheckBeforeVerify)) != 2 {
+		t.Error("默认规则链有bug")
+	}
+
+	const invalidTypeBlockedSegment = InvalidTypeCustomBase + 1
+	var evaluated []string
+	blocked := RuleFunc("blocked_segment", func(rc RuleContext) (InvalidType, error) {
+		evaluated = append(evaluated, "blocked_segment")
+		if rc.Metadata.Scene == "register" && strings.HasPrefix(rc.ObjName, "170") {
+			return invalidTypeBlockedSegment, nil
+		}
+		return UserIsValid, nil
+	})
+	tail := RuleFunc("tail", func(rc RuleContext) (InvalidType, error) {
+		evaluated = append(evaluated, "tail")
+		return UserIsValid, nil
+	})
+	if err = tRdb.ModifyRules(OperationPreCheckBeforeSend, []Rule{blocked, tail}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// 遇到首个未通过的规则即停止
+	meta := RequestMetadata{Scene: "register"}
+	if it, err := tRdb.withMetadata(meta).evaluateRules(OperationPreCheckBeforeSend, "17000000000", nil); err != nil || it != invalidTypeBlockedSegment || len(evaluated) != 1 {
+		t.Error("规则链执行顺序有bug")
+	}
+
+	evaluated = nil
+	if it, err := tRdb.withMetadata(meta).evaluateRules(OperationPreCheckBeforeSend, "17000000000", map[string]bool{"blocked_segment": true}); err != nil || it != UserIsValid || len(evaluated) != 1 || evaluated[0] != "tail" {
+		t.Error("规则跳过有bug")
+	}
+
+	// 通过公开接口执行自定义规则链
+	evaluated = nil
+	if it, err := tRdb.PreCheckBeforeSendVerificationCodeWithMetadata("17000000000", meta); err != nil || it != invalidTypeBlockedSegment {
+		t.Error("自定义规则有bug")
+	}
+	if it, err := tRdb.PreCheckBeforeSendVerificationCodeWithMetadata("13800000000", meta); err != nil || it != UserIsValid || len(evaluated) != 3 {
+		t.Error("自定义规则有bug")
+	}
+
+	if err = tRdb.ModifyRules(OperationPreCheckBeforeSend, []Rule{nil}); err == nil {
+		t.Error("规则校验有bug")
+	}
+	if err = tRdb.ModifyRules(OperationPreCheckBeforeSend, nil); err != nil || len(tRdb.QueryRules(OperationPreCheckBeforeSend)) != 3 {
+		t.Error("恢复默认规则链有bug")
+	}
+}
+
+func TestStrategyOverrideApply(t *testing.T) {
+	validity, failed := int64(60), 100
+	o := StrategyOverride{
+		ValidityDuration:           &validity,
+		DenyThresholdOfFailedCount: &failed,
+		TemporarilyBanStrategy:     &map[int]int64{},
+	}
+
+	merged := o.Apply(*strategy)
+	if merged.ValidityDuration != 60 || merged.DenyThresholdOfFailedCount != 100 || merged.RequestTimeIntervalThreshold != strategy.RequestTimeIntervalThreshold {
+		t.Error("策略覆盖项合并有bug")
+	}
+	if len(*merged.QueryTemporarilyBanStrategy()) != 0 || len(*strategy.QueryTemporarilyBanStrategy()) != 2 {
+		t.Error("策略覆盖项合并短暂封禁策略有bug")
+	}
+
+	unavailable := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})
+	oRdb, err := CreateVerificationCodeRdbWithOptionalConfig(unavailable, "SMS", *strategy, &VerificationCodeRdbOptionalConfig{
+		DegradationStrategy: &DegradationStrategy{},
+		StrategyOverride:    true,
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer oRdb.Close()
+
+	invalid := int64(0)
+	if err = oRdb.SetStrategyOverride(testPhoneNum, StrategyOverride{ValidityDuration: &invalid}, time.Minute); err == nil {
+		t.Error("策略覆盖项校验有bug")
+	}
+	if err = oRdb.SetStrategyOverride(testPhoneNum, o, 0); err == nil {
+		t.Error("策略覆盖项有效期校验有bug")
+	}
+}
+
+func TestStrategyOverride(t *testing.T) {
+	oRdb, err := CreateVerificationCodeRdbWithOptionalConfig(r, "SMS", *strategy, &VerificationCodeRdbOptionalConfig{StrategyOverride: true})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_ = oRdb.ResetCounters(testPhoneNum)
+	_, _ = oRdb.Revoke(testPhoneNum)
+	_, _ = oRdb.ClearStrategyOverride(testPhoneNum)
+
+	// 放宽请求间隔限制
+	interval := int64(0)
+	if err = oRdb.SetStrategyOverride(testPhoneNum, StrategyOverride{RequestTimeIntervalThreshold: &interval}, time.Minute); err != nil {
+		t.Fatal(err.Error())
+	}
+	_ = oRdb.SetAndRegisterVerificationCode(testPhoneNum, testVerCode)
+	if it, err := oRdb.PreCheckBeforeSendVerificationCode(testPhoneNum); err != nil || it != UserIsValid {
+		t.Error("策略覆盖项未生效")
+	}
+
+	list, err := oRdb.ListStrategyOverrides()
+	if err != nil || len(list) != 1 || list[0].ObjName != testPhoneNum || list[0].ExpireAt.IsZero() {
+		t.Error("列出策略覆盖项有bug")
+	}
+	if s, err := oRdb.QueryEffectiveStrategy(testPhoneNum); err != nil || s.RequestTimeIntervalThreshold != 0 || s.ValidityDuration != strategy.ValidityDuration {
+		t.Error("查询实际生效的策略有bug")
+	}
+
+	if existed, err := oRdb.ClearStrategyOverride(testPhoneNum); err != nil || !existed {
+		t.Error("清除策略覆盖项有bug")
+	}
+	if it, err := oRdb.PreCheckBeforeSendVerificationCode(testPhoneNum); err != nil || it != InvalidTypeRequestTooFrequently {
+		t.Error("清除策略覆盖项后仍生效")
+	}
+	_ = oRdb.ResetCounters(testPhoneNum)
+	_, _ = oRdb.Revoke(testPhoneNum)
+}
+
+func TestPeekVerificationCode(t *testing.T) {
+	pRdb, err := CreateVerificationCodeRdb(r, "Peek", *strategy)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_ = pRdb.ResetCounters(testPhoneNum)
+	_, _ = pRdb.Revoke(testPhoneNum)
+
+	_ = pRdb.SetAndRegisterVerificationCode(testPhoneNum, testVerCode)
+	if exist, success, err := pRdb.PeekVerificationCode(testPhoneNum, testVerCode+"x"); err != nil || !exist || success {
+		t.Error("校验验证码有bug")
+	}
+	if _, success, _ := pRdb.PeekVerificationCode(testPhoneNum, testVerCode); !success {
+		t.Error("校验验证码有bug")
+	}
+	// 校验成功后验证码仍可核销
+	if _, success, _ := pRdb.VerifyAndUseVerificationCode(testPhoneNum, testVerCode); !success {
+		t.Error("校验验证码后核销有bug")
+	}
+	if exist, _, _ := pRdb.PeekVerificationCode(testPhoneNum, testVerCode); exist {
+		t.Error("核销后校验验证码有bug")
+	}
+
+	if _, _, _, err = pRdb.ExtendVerificationCode(testPhoneNum, time.Minute); err != ErrExtendNotAllowed {
+		t.Error("延长验证码有效期的策略校验有bug")
+	}
+}
+
+func TestExtendVerificationCode(t *testing.T) {
+	eRdb := *rdb
+	eRdb.ModifyExtendStrategy(ExtendStrategy{MaxExtension: 90})
+	_ = eRdb.ResetCounters(testPhoneNum)
+	_, _ = eRdb.Revoke(testPhoneNum)
+
+	if exist, _, _, err := eRdb.ExtendVerificationCode(testPhoneNum, time.Minute); err != nil || exist {
+		t.Error("延长不存在的验证码有bug")
+	}
+
+	_ = eRdb.SetAndRegisterVerificationCode(testPhoneNum, testVerCode)
+	if _, extended, ttl, err := eRdb.ExtendVerificationCode(testPhoneNum, time.Minute); err != nil || extended != time.Minute || ttl <= time.Duration(strategy.ValidityDuration)*time.Second {
+		t.Error("延长验证码有效期有bug")
+	}
+	// 累计延长时长不超过上限
+	if _, extended, _, err := eRdb.ExtendVerificationCode(testPhoneNum, time.Minute); err != nil || extended != 30*time.Second {
+		t.Error("延长验证码有效期的上限有bug")
+	}
+	if _, extended, _, err := eRdb.ExtendVerificationCode(testPhoneNum, time.Minute); err != nil || extended != 0 {
+		t.Error("延长验证码有效期的上限有bug")
+	}
+
+	if _, success, _ := eRdb.PeekVerificationCode(testPhoneNum, testVerCode); !success {
+		t.Error("校验验证码有bug")
+	}
+	if cnt, _ := eRdb.QueryCountOfUnusedVerificationCode(testPhoneNum); cnt != 1 {
+		t.Error("校验验证码不应核销")
+	}
+	_ = eRdb.ResetCounters(testPhoneNum)
+	_, _ = eRdb.Revoke(testPhoneNum)
+}
+
+func TestStoredCodeWithDigest(t *testing.T) {
+	digest := payloadDigest([]byte(`{"to":"X","amount":"500CNY"}`))
+	if digest == payloadDigest([]byte(`{"to":"Y","amount":"500CNY"}`)) || len(digest) != 64 {
+		t.Error("交易载荷摘要有bug")
+	}
+
+	if code, d := decodeStoredCode(encodeStoredCode(testVerCode, digest)); code != testVerCode || d != digest {
+		t.Error("验证码编码有bug")
+	}
+	if encodeStoredCode(testVerCode, "") != testVerCode {
+		t.Error("未绑定交易载荷的验证码编码有bug")
+	}
+	if code, d := decodeStoredCode(testVerCode); code != testVerCode || d != "" {
+		t.Error("未绑定交易载荷的验证码解码有bug")
+	}
+
+	unavailable := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})
+	pRdb, err := CreateVerificationCodeRdbWithOptionalConfig(unavailable, "SMS", *strategy, &VerificationCodeRdbOptionalConfig{
+		DegradationStrategy: &DegradationStrategy{
+			Policies: map[DegradableOperation]DegradationPolicy{
+				OperationSetAndRegister: DegradationPolicyFailOpen,
+				OperationVerifyAndUse:   DegradationPolicyFailOpen,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer pRdb.Close()
+
+	// 降级模式下不支持绑定交易载荷
+	if err = pRdb.SetAndRegisterVerificationCodeWithPayload(testPhoneNum, testVerCode, []byte("payload")); err != ErrRedisUnavailable {
+		t.Error("降级模式下绑定交易载荷有bug")
+	}
+	if _, _, err = pRdb.VerifyAndUseVerificationCodeWithPayload(testPhoneNum, testVerCode, nil); err != ErrEmptyPayload {
+		t.Error("交易载荷校验有bug")
+	}
+}
+
+func TestVerificationCodeWithPayload(t *testing.T) {
+	_ = rdb.ResetCounters(testPhoneNum)
+	_, _ = rdb.Revoke(testPhoneNum)
+
+	payload := []byte(`{"to":"X","amount":"500CNY"}`)
+	if err := rdb.SetAndRegisterVerificationCodeWithPayload(testPhoneNum, testVerCode, payload); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// 验证码正确但载荷不一致, 或未携带载荷时均核销失败
+	if exist, success, err := rdb.VerifyAndUseVerificationCodeWithPayload(testPhoneNum, testVerCode, []byte(`{"to":"Y","amount":"500CNY"}`)); err != nil || !exist || success {
+		t.Error("交易载荷不一致时核销有bug")
+	}
+	if _, success, _ := rdb.VerifyAndUseVerificationCode(testPhoneNum, testVerCode); success {
+		t.Error("未携带交易载荷时核销有bug")
+	}
+	if cnt, _ := rdb.QueryErrorsCountToday(testPhoneNum); cnt != 2 {
+		t.Error("交易载荷不一致时未计入失败次数")
+	}
+
+	if _, success, _ := rdb.PeekVerificationCodeWithPayload(testPhoneNum, testVerCode, payload); !success {
+		t.Error("校验绑定交易载荷的验证码有bug")
+	}
+	if _, success, _ := rdb.VerifyAndUseVerificationCodeWithPayload(testPhoneNum, testVerCode, payload); !success {
+		t.Error("核销绑定交易载荷的验证码有bug")
+	}
+	_ = rdb.ResetCounters(testPhoneNum)
+}
+
+func TestCreateVerificationFlow(t *testing.T) {
+	unavailable := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})
+	smsRdb, err := CreateVerificationCodeRdbWithOptionalConfig(unavailable, "SMS", *strategy, &VerificationCodeRdbOptionalConfig{DegradationStrategy: &DegradationStrategy{}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer smsRdb.Close()
+
+	if _, err = CreateVerificationFlow(unavailable, "Recovery", VerificationFlowStrategy{}); err == nil {
+		t.Error("流程因素校验有bug")
+	}
+	if _, err = CreateVerificationFlow(unavailable, "Recovery", VerificationFlowStrategy{Factors: []FlowFactor{{"sms", smsRdb}, {"sms", smsRdb}}}); err == nil {
+		t.Error("流程因素重复校验有bug")
+	}
+
+	flow, err := CreateVerificationFlow(unavailable, "Recovery", VerificationFlowStrategy{Factors: []FlowFactor{{"sms", smsRdb}}})
+	if err != nil || flow.strategy.TTL != defaultFlowTTL {
+		t.Fatal("创建流程有bug")
+	}
+	if _, err = flow.Start(map[string]string{"email": "a@b.c"}); err == nil {
+		t.Error("流程因素对象校验有bug")
+	}
+	if _, _, _, err = flow.Verify("flow", "email", testVerCode); err != ErrUnknownFactor {
+		t.Error("未声明的流程因素校验有bug")
+	}
+}
+
+func TestVerificationFlow(t *testing.T) {
+	emailRdb, err := CreateVerificationCodeRdb(r, "EMAIL", *strategy)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	flow, err := CreateVerificationFlow(r, "Recovery", VerificationFlowStrategy{
+		Factors: []FlowFactor{{"sms", rdb}, {"email", emailRdb}},
+		TTL:     time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	const email = "test@example.com"
+	for _, v := range []*VerificationCodeRdb{rdb, emailRdb} {
+		_ = v.ResetCounters(testPhoneNum)
+		_ = v.ResetCounters(email)
+	}
+
+	flowId, err := flow.Start(map[string]string{"sms": testPhoneNum, "email": email})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// 对象在流程外申请的验证码不能通过流程的核销
+	_ = emailRdb.SetAndRegisterVerificationCode(email, "333333")
+	if _, success, _, err := flow.Verify(flowId, "email", "333333"); err != nil || success {
+		t.Error("流程验证码绑定有bug")
+	}
+	// 其他流程的验证码不能通过本流程的核销
+	otherId, _ := flow.Start(map[string]string{"sms": testPhoneNum, "email": email})
+	_ = flow.SetAndRegisterVerificationCode(otherId, "email", "444444")
+	if _, success, _, err := flow.Verify(flowId, "email", "444444"); err != nil || success {
+		t.Error("流程验证码绑定有bug")
+	}
+	_ = emailRdb.ResetCounters(email)
+
+	_ = flow.SetAndRegisterVerificationCode(flowId, "sms", "111111")
+	_ = flow.SetAndRegisterVerificationCode(flowId, "email", "222222")
+
+	// 顺序不限, 先核销邮箱
+	if _, success, completed, err := flow.Verify(flowId, "email", "222222"); err != nil || !success || completed {
+		t.Error("流程核销有bug")
+	}
+	if completed, _, err := flow.Finish(flowId); err != nil || completed {
+		t.Error("流程未完成时结束流程有bug")
+	}
+	if _, success, completed, err := flow.Verify(flowId, "sms", "111111"); err != nil || !success || !completed {
+		t.Error("流程完成判断有bug")
+	}
+
+	if st, err := flow.QueryFlowStatus(flowId); err != nil || !st.Completed || len(st.Factors) != 2 || st.TTL <= 0 {
+		t.Error("查询流程状态有bug")
+	}
+	if completed, subjects, err := flow.Finish(flowId); err != nil || !completed || subjects["sms"] != testPhoneNum || subjects["email"] != email {
+		t.Error("结束流程有bug")
+	}
+	if _, _, err := flow.Finish(flowId); err != ErrFlowNotFound {
+		t.Error("流程仅能结束一次")
+	}
+}
+
+func TestDeviceTrustStrategy(t *testing.T) {
+	if (DeviceTrustStrategy{}).lifetime() != defaultDeviceTokenLifetime {
+		t.Error("设备令牌默认有效期有bug")
+	}
+
+	unavailable := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})
+	dRdb, err := CreateVerificationCodeRdbWithOptionalConfig(unavailable, "SMS", *strategy, &VerificationCodeRdbOptionalConfig{
+		DegradationStrategy: &DegradationStrategy{
+			Policies: map[DegradableOperation]DegradationPolicy{
+				OperationSetAndRegister: DegradationPolicyFailOpen,
+				OperationVerifyAndUse:   DegradationPolicyFailOpen,
+			},
+		},
+		DeviceTrustStrategy: &DeviceTrustStrategy{},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer dRdb.Close()
+
+	if _, err = dRdb.IssueDeviceToken(testPhoneNum, ""); err != ErrEmptyFingerprint {
+		t.Error("设备指纹校验有bug")
+	}
+	if _, err = dRdb.IssueDeviceToken(testPhoneNum, "fingerprint"); err != ErrRedisUnavailable {
+		t.Error("降级模式下签发设备令牌有bug")
+	}
+	if trusted, _ := dRdb.CheckDeviceToken(testPhoneNum, "fingerprint", "token"); trusted {
+		t.Error("降级模式下校验设备令牌有bug")
+	}
+
+	// 降级模式下无法签发令牌, 不核销本地暂存的验证码
+	_ = dRdb.SetAndRegisterVerificationCode(testPhoneNum, testVerCode)
+	if _, success, _, err := dRdb.VerifyAndUseVerificationCodeWithDeviceToken(testPhoneNum, testVerCode, "fingerprint"); err != ErrRedisUnavailable || success {
+		t.Error("降级模式下核销并签发设备令牌有bug")
+	}
+	if _, success, _ := dRdb.VerifyAndUseVerificationCode(testPhoneNum, testVerCode); !success {
+		t.Error("签发设备令牌失败时不应核销验证码")
+	}
+
+	disabled := *dRdb
+	disabled.deviceTrust = nil
+	if _, err = disabled.ListTrustedDevices(testPhoneNum); err != ErrDeviceTrustDisabled {
+		t.Error("未配置受信任设备策略时有bug")
+	}
+}
+
+func TestTrustedDevice(t *testing.T) {
+	clock := wow_time.CreateFakeClock(time.Now())
+	dRdb, err := CreateVerificationCodeRdbWithOptionalConfig(r, "SMS", *strategy, &VerificationCodeRdbOptionalConfig{
+		Clock:               clock,
+		DeviceTrustStrategy: &DeviceTrustStrategy{Lifetime: time.Hour, MaxDevicesPerSubject: 2},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, _ = dRdb.RevokeAllTrustedDevices(testPhoneNum)
+	_ = dRdb.ResetCounters(testPhoneNum)
+
+	_ = dRdb.SetAndRegisterVerificationCode(testPhoneNum, testVerCode)
+	_, success, token, err := dRdb.VerifyAndUseVerificationCodeWithDeviceToken(testPhoneNum, testVerCode, "device-a")
+	if err != nil || !success || token == "" {
+		t.Fatal("签发设备令牌有bug")
+	}
+
+	if trusted, err := dRdb.CheckDeviceToken(testPhoneNum, "device-a", token); err != nil || !trusted {
+		t.Error("校验设备令牌有bug")
+	}
+	if trusted, _ := dRdb.CheckDeviceToken(testPhoneNum, "device-b", token); trusted {
+		t.Error("设备指纹不一致时校验有bug")
+	}
+	if trusted, _ := dRdb.CheckDeviceToken("TestPhoneNumber002", "device-a", token); trusted {
+		t.Error("对象不一致时校验有bug")
+	}
+
+	// 超出上限时淘汰最早签发的令牌
+	clock.Advance(time.Second)
+	tokenB, _ := dRdb.IssueDeviceToken(testPhoneNum, "device-b")
+	clock.Advance(time.Second)
+	tokenC, _ := dRdb.IssueDeviceToken(testPhoneNum, "device-c")
+	if list, err := dRdb.ListTrustedDevices(testPhoneNum); err != nil || len(list) != 2 {
+		t.Error("受信任设备数量上限有bug")
+	}
+	if trusted, _ := dRdb.CheckDeviceToken(testPhoneNum, "device-a", token); trusted {
+		t.Error("淘汰设备令牌有bug")
+	}
+
+	if existed, err := dRdb.RevokeDeviceToken(testPhoneNum, tokenB); err != nil || !existed {
+		t.Error("吊销设备令牌有bug")
+	}
+	if trusted, _ := dRdb.CheckDeviceToken(testPhoneNum, "device-b", tokenB); trusted {
+		t.Error("吊销后设备令牌仍有效")
+	}
+	if n, err := dRdb.RevokeAllTrustedDevices(testPhoneNum); err != nil || n != 1 {
+		t.Error("吊销全部设备令牌有bug")
+	}
+	if trusted, _ := dRdb.CheckDeviceToken(testPhoneNum, "device-c", tokenC); trusted {
+		t.Error("吊销全部后设备令牌仍有效")
+	}
+	_ = dRdb.ResetCounters(testPhoneNum)
+}
+
+func TestStateKeyKind(t *testing.T) {
+	if stateKeyKind(testPhoneNum, "string") != "" || stateKeyKind("Set"+testPhoneNum+"20220101", "set") != "Set" ||
+		stateKeyKind("Settings", "string") != "" || stateKeyKind("StatisticsSubjects20220101", "string") != "StatisticsSubjects" ||
+		stateKeyKind("Extension"+testPhoneNum, "hash") != "Extension" || stateKeyKind("StrategyOverride"+testPhoneNum, "string") != "StrategyOverride" {
+		t.Error("stateKeyKind有bug")
+	}
+	if h := hashStoredCode(testVerCode); h == testVerCode || hashStoredCode(h) != h || hashStoredCode("") != "" {
+		t.Error("hashStoredCode有bug")
+	}
+	if escapeScanPattern(`a*b?[c]\`) != `a\*b\?\[c\]\\` {
+		t.Error("escapeScanPattern有bug")
+	}
+}
+
+func TestExportImportState(t *testing.T) {
+	optCfg := &VerificationCodeRdbOptionalConfig{StatisticsStrategy: &StatisticsStrategy{}}
+	src, err := CreateVerificationCodeRdbWithOptionalConfig(r, "Backup", *strategy, optCfg)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	dst, _ := CreateVerificationCodeRdbWithOptionalConfig(r, "Restore", *strategy, optCfg)
+	for _, m := range []*VerificationCodeRdb{src, dst} {
+		_ = m.ResetCounters(testPhoneNum)
+		_, _ = m.Revoke(testPhoneNum)
+	}
+
+	_ = src.SetAndRegisterVerificationCode(testPhoneNum, testVerCode)
+	_, _, _ = src.VerifyAndUseVerificationCode(testPhoneNum, testVerCode+"x")
+
+	var buf bytes.Buffer
+	if report, err := src.ExportState(&buf, ExportOptions{ScanCount: 10}); err != nil || report.Keys == 0 {
+		t.Fatal("导出有bug")
+	}
+	if report, err := dst.ImportState(bytes.NewReader(buf.Bytes()), ImportOptions{Overwrite: true}); err != nil || report.Restored == 0 {
+		t.Fatal("导入有bug")
+	}
+	st, err := dst.QueryVerificationStatus(testPhoneNum)
+	if err != nil || !st.CodeExist || st.CodeTTL <= 0 || st.UnusedCodeCount != 1 || st.ErrorsCountToday != 1 {
+		t.Error("导入有bug")
+	}
+	if report, _ := dst.ImportState(bytes.NewReader(buf.Bytes()), ImportOptions{}); report.Restored != 0 || report.Skipped == 0 {
+		t.Error("跳过已存在的字段有bug")
+	}
+	if _, success, _ := dst.VerifyAndUseVerificationCode(testPhoneNum, testVerCode); !success {
+		t.Error("导入的验证码有bug")
+	}
+
+	// 以摘要代替验证码或不导出验证码
+	for _, mode := range []CodeExportMode{CodeExportModeHash, CodeExportModeExclude} {
+		buf.Reset()
+		report, err := src.ExportState(&buf, ExportOptions{CodeMode: mode})
+		if err != nil || strings.Contains(buf.String(), testVerCode) || (mode == CodeExportModeExclude) != (report.Excluded > 0) {
+			t.Error("导出验证码的处理方式有bug")
+		}
+	}
+	if _, err = src.ExportState(&buf, ExportOptions{CodeMode: "plain"}); err == nil {
+		t.Error("导出验证码的处理方式有bug")
+	}
+}
+
+func TestIsDatedSubjectKey(t *testing.T) {
+	if !isDatedSubjectKey("Set"+testPhoneNum+"20220101", testPhoneNum) || !isDatedSubjectKey("LastErrorTime"+testPhoneNum+"20211231", testPhoneNum) {
+		t.Error("isDatedSubjectKey有bug")
+	}
+	// 名称以该对象名称开头的其他对象
+	if isDatedSubjectKey("ErrorCount"+testPhoneNum+"120220101", testPhoneNum) || isDatedSubjectKey("Statistics20220101", "") ||
+		isDatedSubjectKey(testPhoneNum, testPhoneNum) {
+		t.Error("isDatedSubjectKey有bug")
+	}
+
+	store := createLocalCodeStore()
+	store.set("SMS", testPhoneNum, testVerCode, time.Now().Add(time.Minute))
+	if store.remove("SMS", testPhoneNum) != 1 || store.remove("SMS", testPhoneNum) != 0 {
+		t.Error("localCodeStore.remove有bug")
+	}
+	limiter := createLocalLimiter(1, time.Minute)
+	limiter.allow("1:SMS:"+testPhoneNum, time.Now())
+	limiter.remove("SMS:" + testPhoneNum)
+	if !limiter.allow("1:SMS:"+testPhoneNum, time.Now()) {
+		t.Error("localLimiter.remove有bug")
+	}
+}
+
+func TestForgetSubject(t *testing.T) {
+	fRdb, err := CreateVerificationCodeRdbWithOptionalConfig(r, "Forget", *strategy, &VerificationCodeRdbOptionalConfig{
+		HistoryStrategy:     &HistoryStrategy{},
+		StrategyOverride:    true,
+		DeviceTrustStrategy: &DeviceTrustStrategy{},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	other := testPhoneNum + "1"
+	_, _ = fRdb.ForgetSubject(testPhoneNum)
+	_, _ = fRdb.ForgetSubject(other)
+
+	// 历史日期的数据, 以及名称以该对象名称开头的另一对象的数据
+	ctx := context.TODO()
+	r.SAdd(ctx, "ForgetVerificationCodeSet"+testPhoneNum+"20200101", testVerCode)
+	r.Expire(ctx, "ForgetVerificationCodeSet"+testPhoneNum+"20200101", time.Minute)
+	r.Set(ctx, "ForgetVerificationCodeErrorCount"+testPhoneNum+"20200101", 3, time.Minute)
+	r.Set(ctx, "ForgetVerificationCodeErrorCount"+other+"20200101", 3, time.Minute)
+
+	_ = fRdb.SetAndRegisterVerificationCode(testPhoneNum, testVerCode)
+	_, _, _ = fRdb.VerifyAndUseVerificationCode(testPhoneNum, testVerCode+"x")
+	_ = fRdb.SetAndRegisterVerificationCode(other, testVerCode)
+	_ = fRdb.SetStrategyOverride(testPhoneNum, StrategyOverride{}, time.Minute)
+	if _, err = fRdb.IssueDeviceToken(testPhoneNum, "fingerprint"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	report, err := fRdb.ForgetSubject(testPhoneNum)
+	if err != nil || report.TrustedDevices != 1 || report.HistoryRecords == 0 {
+		t.Error("ForgetSubject有bug")
+	}
+	// 验证码、策略覆盖项、设备索引、当日的未核销验证码集合/失败次数/最后一次失败的时间, 以及历史日期的两个字段
+	if len(report.DeletedKeys) != 8 {
+		t.Error("ForgetSubject有bug: " + strings.Join(report.DeletedKeys, ","))
+	}
+
+	if st, _ := fRdb.QueryVerificationStatus(testPhoneNum); st.CodeExist || st.UnusedCodeCount != 0 || st.ErrorsCountToday != 0 {
+		t.Error("ForgetSubject有bug")
+	}
+	if exist, _, _ := fRdb.QueryStrategyOverride(testPhoneNum); exist {
+		t.Error("ForgetSubject有bug")
+	}
+	if records, _, _ := fRdb.QueryHistory(testPhoneNum, time.Time{}, time.Now().Add(time.Minute), "", 10); len(records) != 0 {
+		t.Error("ForgetSubject有bug")
+	}
+	if st, _ := fRdb.QueryVerificationStatus(other); !st.CodeExist || st.UnusedCodeCount != 1 || r.Exists(ctx, "ForgetVerificationCodeErrorCount"+other+"20200101").Val() != 1 {
+		t.Error("ForgetSubject误删了其他对象的数据")
+	}
+	_, _ = fRdb.ForgetSubject(other)
+}
+
+func TestForgetSubjectInTenantsAndFlows(t *testing.T) {
+	registry, err := CreateVerificationCodeRdbRegistry(r, "ForgetTenant", *strategy, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer registry.Close()
+
+	// tenantA单独设置了策略, tenantB仅在redis中存在数据
+	_ = registry.SetTenantStrategy("tenantA", *strategy)
+	for _, tenantId := range []string{"tenantA", "tenantB"} {
+		if err = registry.SetAndRegisterVerificationCode(tenantId, testPhoneNum, testVerCode); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	registry.DelTenantStrategy("tenantA")
+	_ = registry.SetAndRegisterVerificationCode("tenantA", testPhoneNum+"1", testVerCode)
+
+	reports, err := registry.ForgetSubject(testPhoneNum)
+	if err != nil || len(reports) != 3 || len(reports["tenantA"].DeletedKeys) == 0 || len(reports["tenantB"].DeletedKeys) == 0 {
+		t.Error("注册表ForgetSubject有bug")
+	}
+	for _, tenantId := range []string{"tenantA", "tenantB"} {
+		tRdb, _ := registry.Tenant(tenantId)
+		if exist, _, _ := tRdb.getVerificationCode(testPhoneNum); exist {
+			t.Error("注册表ForgetSubject有bug")
+		}
+	}
+	a, _ := registry.Tenant("tenantA")
+	if exist, _, _ := a.getVerificationCode(testPhoneNum + "1"); !exist {
+		t.Error("注册表ForgetSubject误删了其他对象的数据")
+	}
+	_, _ = a.ForgetSubject(testPhoneNum + "1")
+
+	flow, err := CreateVerificationFlow(r, "ForgetFlow", VerificationFlowStrategy{
+		Factors: []FlowFactor{{"sms", rdb}},
+		TTL:     time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	mine, _ := flow.Start(map[string]string{"sms": testPhoneNum})
+	others, _ := flow.Start(map[string]string{"sms": testPhoneNum + "1"})
+	if n, err := flow.ForgetSubject(testPhoneNum); err != nil || n != 1 {
+		t.Error("流程ForgetSubject有bug")
+	}
+	if _, err = flow.QueryFlowStatus(mine); err != ErrFlowNotFound {
+		t.Error("流程ForgetSubject有bug")
+	}
+	if _, err = flow.QueryFlowStatus(others); err != nil {
+		t.Error("流程ForgetSubject误删了其他对象的流程")
+	}
+	_, _ = flow.ForgetSubject(testPhoneNum + "1")
+
+	// 流程中记录的是原始对象名称, 需经因素的验证码服务规范化后比较
+	emailRdb, err := CreateVerificationCodeRdbWithOptionalConfig(r, "ForgetEmail", *strategy, &VerificationCodeRdbOptionalConfig{SubjectNormalizer: EmailNormalizer{}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	emailFlow, err := CreateVerificationFlow(r, "ForgetEmailFlow", VerificationFlowStrategy{
+		Factors: []FlowFactor{{"email", emailRdb}},
+		TTL:     time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, _ = emailFlow.Start(map[string]string{"email": " Test@Example.com"})
+	if n, err := emailFlow.ForgetSubject("test@example.com"); err != nil || n != 1 {
+		t.Error("流程ForgetSubject未规范化对象名称")
+	}
+}
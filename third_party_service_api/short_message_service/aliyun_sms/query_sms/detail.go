@@ -3,6 +3,7 @@ package query_sms
 import (
 	. "github.com/DontBeProud/wow-easy-go/third_party_service_api/short_message_service/aliyun_sms/query_sms/status"
 	. "github.com/DontBeProud/wow-easy-go/third_party_service_api/short_message_service/aliyun_sms/utils"
+	"github.com/DontBeProud/wow-easy-go/utils/wow_time"
 	dysmsapi20170525 "github.com/alibabacloud-go/dysmsapi-20170525/v2/client"
 	"time"
 )
@@ -12,6 +13,9 @@ const (
 	AliYunSMSStatusTimeFormat = "2006-01-02 15:04:05"
 )
 
+// AliYunSMSStatusTimeLocation 阿里云短信状态查询返回的时间及查询参数中的日期所使用的时区(北京时间)
+var AliYunSMSStatusTimeLocation = wow_time.LocationChina
+
 // AliYunSmsStatusDetail 调用阿里云短信状态查询接口返回的单条短信的详细信息
 type AliYunSmsStatusDetail struct {
 	AliYunSmsStatusDetailInterface
@@ -21,8 +25,8 @@ type AliYunSmsStatusDetail struct {
 	content      string                  // 短信内容
 	outId        string                  // 外部流水扩展字段
 	templateCode string                  // 短信模板ID
-	sendDate     time.Time               // 短信发送时间
-	receiveDate  time.Time               // 短信接收时间
+	sendDate     time.Time               // 短信发送时间(已转换至wow_time的默认时区)
+	receiveDate  time.Time               // 短信接收时间(已转换至wow_time的默认时区)
 }
 
 type AliYunSmsStatusDetailInterface interface {
@@ -88,10 +92,24 @@ func refineDetailListFromAliYunRawResponse(raw *dysmsapi20170525.QuerySendDetail
 				content:      ParseStrPointerIntoString(tmp.Content),
 				outId:        ParseStrPointerIntoString(tmp.OutId),
 				templateCode: ParseStrPointerIntoString(tmp.TemplateCode),
-				receiveDate:  ParseStrPointerIntoTime(tmp.ReceiveDate, AliYunSMSStatusTimeFormat),
-				sendDate:     ParseStrPointerIntoTime(tmp.SendDate, AliYunSMSStatusTimeFormat),
+				receiveDate:  parseAliYunSMSStatusTime(tmp.ReceiveDate),
+				sendDate:     parseAliYunSMSStatusTime(tmp.SendDate),
 			}
 		}
 	}
 	return res, size
 }
+
+// 解析阿里云返回的北京时间, 并转换至wow_time的默认时区. 解析失败时返回空时间
+func parseAliYunSMSStatusTime(raw *string) time.Time {
+	t := ParseStrPointerIntoTimeInLocation(raw, AliYunSMSStatusTimeFormat, AliYunSMSStatusTimeLocation)
+	if t.IsZero() {
+		return t
+	}
+	return t.In(wow_time.GetDefaultLocation())
+}
+
+// FormatAliYunSMSQueryDate 将时间格式化为短信状态查询接口所需的发送日期(yyyyMMdd, 北京时间)
+func FormatAliYunSMSQueryDate(t time.Time) string {
+	return t.In(AliYunSMSStatusTimeLocation).Format("20060102")
+}
@@ -16,6 +16,44 @@ const (
 	AliYunSmsApiRequestStatusSuccess = "OK"
 )
 
+// 阿里云短信业务API的常见错误码
+// 详细内容参照 https://help.aliyun.com/document_detail/101346.htm
+const (
+	AliYunSmsApiRequestStatusRamPermissionDeny          = "isp.RAM_PERMISSION_DENY"           // RAM权限不足
+	AliYunSmsApiRequestStatusSystemError                = "isp.SYSTEM_ERROR"                  // 阿里云系统错误
+	AliYunSmsApiRequestStatusOutOfService               = "isv.OUT_OF_SERVICE"                // 业务停机(余额不足)
+	AliYunSmsApiRequestStatusProductUnSubscript         = "isv.PRODUCT_UN_SUBSCRIPT"          // 未开通云通信产品
+	AliYunSmsApiRequestStatusProductUnsubscribe         = "isv.PRODUCT_UNSUBSCRIBE"           // 产品未开通
+	AliYunSmsApiRequestStatusAccountNotExists           = "isv.ACCOUNT_NOT_EXISTS"            // 账户不存在
+	AliYunSmsApiRequestStatusAccountAbnormal            = "isv.ACCOUNT_ABNORMAL"              // 账户异常
+	AliYunSmsApiRequestStatusSecurityFrozenAccount      = "isv.SECURITY_FROZEN_ACCOUNT"       // 因安全原因账户被冻结
+	AliYunSmsApiRequestStatusAmountNotEnough            = "isv.AMOUNT_NOT_ENOUGH"             // 账户余额不足
+	AliYunSmsApiRequestStatusSmsTemplateIllegal         = "isv.SMS_TEMPLATE_ILLEGAL"          // 模板不存在或未通过审核
+	AliYunSmsApiRequestStatusSmsSignatureIllegal        = "isv.SMS_SIGNATURE_ILLEGAL"         // 签名不存在或未通过审核
+	AliYunSmsApiRequestStatusSmsSignIllegal             = "isv.SMS_SIGN_ILLEGAL"              // 签名禁止使用
+	AliYunSmsApiRequestStatusSmsSignatureSceneIllegal   = "isv.SMS_SIGNATURE_SCENE_ILLEGAL"   // 签名与模板的类型不匹配
+	AliYunSmsApiRequestStatusSmsContentIllegal          = "isv.SMS_CONTENT_ILLEGAL"           // 短信内容包含禁止发送的内容
+	AliYunSmsApiRequestStatusInvalidParameters          = "isv.INVALID_PARAMETERS"            // 参数格式不正确
+	AliYunSmsApiRequestStatusInvalidJsonParam           = "isv.INVALID_JSON_PARAM"            // 模板参数不是合法的JSON
+	AliYunSmsApiRequestStatusTemplateMissingParameters  = "isv.TEMPLATE_MISSING_PARAMETERS"   // 模板变量未赋值
+	AliYunSmsApiRequestStatusTemplateParamsIllegal      = "isv.TEMPLATE_PARAMS_ILLEGAL"       // 模板变量包含非法关键字
+	AliYunSmsApiRequestStatusParamLengthLimit           = "isv.PARAM_LENGTH_LIMIT"            // 参数超出长度限制
+	AliYunSmsApiRequestStatusParamNotSupportUrl         = "isv.PARAM_NOT_SUPPORT_URL"         // 模板变量不支持URL
+	AliYunSmsApiRequestStatusBlackKeyControlLimit       = "isv.BLACK_KEY_CONTROL_LIMIT"       // 模板变量包含黑名单关键字
+	AliYunSmsApiRequestStatusExtendCodeError            = "isv.EXTEND_CODE_ERROR"             // 扩展码使用错误
+	AliYunSmsApiRequestStatusDenyIpRange                = "isv.DENY_IP_RANGE"                 // 源IP地址所在的地区被禁用
+	AliYunSmsApiRequestStatusMobileNumberIllegal        = "isv.MOBILE_NUMBER_ILLEGAL"         // 手机号码格式错误
+	AliYunSmsApiRequestStatusMobileCountOverLimit       = "isv.MOBILE_COUNT_OVER_LIMIT"       // 手机号码数量超出限制
+	AliYunSmsApiRequestStatusDomesticNumberNotSupported = "isv.DOMESTIC_NUMBER_NOT_SUPPORTED" // 国际/港澳台模板不支持发送境内号码
+	AliYunSmsApiRequestStatusBusinessLimitControl       = "isv.BUSINESS_LIMIT_CONTROL"        // 触发流控(同一号码发送过于频繁)
+	AliYunSmsApiRequestStatusDayLimitControl            = "isv.DAY_LIMIT_CONTROL"             // 触发日发送限额
+	AliYunSmsApiRequestStatusSignatureDoesNotMatch      = "SignatureDoesNotMatch"             // 请求签名错误
+	AliYunSmsApiRequestStatusInvalidTimeStampExpired    = "InvalidTimeStamp.Expired"          // 请求时间戳过期
+	AliYunSmsApiRequestStatusSignatureNonceUsed         = "SignatureNonceUsed"                // 请求签名随机数重复
+	AliYunSmsApiRequestStatusInvalidVersion             = "InvalidVersion"                    // API版本号错误
+	AliYunSmsApiRequestStatusInvalidActionNotFound      = "InvalidAction.NotFound"            // API接口名错误
+)
+
 // AliYunSmsApiRequestStatusInterface AliYunSmsApiRequestStatus interface
 type AliYunSmsApiRequestStatusInterface interface {
 	IsRequestSuccess() bool               // 请求调用阿里云API是否成功
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"github.com/DontBeProud/wow-easy-go/utils/wow_time"
 	"strconv"
 	"time"
 )
@@ -29,13 +30,18 @@ func ParseIntPointerIntoInt(raw *int64) int64 {
 	return *raw
 }
 
-// ParseStrPointerIntoTime *string -> time.Time. *string等于nil时返回空时间
+// ParseStrPointerIntoTime *string -> time.Time. *string等于nil时返回空时间. 按wow_time的默认时区解析
 func ParseStrPointerIntoTime(raw *string, dateFmt string) time.Time {
+	return ParseStrPointerIntoTimeInLocation(raw, dateFmt, wow_time.GetDefaultLocation())
+}
+
+// ParseStrPointerIntoTimeInLocation *string -> time.Time. *string等于nil时返回空时间. 按指定的时区loc解析
+func ParseStrPointerIntoTimeInLocation(raw *string, dateFmt string, loc *time.Location) time.Time {
 	if raw == nil {
 		return time.Time{}
 	}
 
-	t, err := time.ParseInLocation(dateFmt, *raw, time.Local)
+	t, err := time.ParseInLocation(dateFmt, *raw, loc)
 	if err != nil {
 		return time.Time{}
 	}
@@ -0,0 +1,231 @@
+package wow_captcha
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/DontBeProud/wow-easy-go/utils/wow_random"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"math/rand"
+	"strings"
+	"unicode"
+)
+
+const (
+	// DefaultCaptchaCharset 默认字符集(剔除了0/O、1/I等容易混淆的字符)
+	DefaultCaptchaCharset = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+)
+
+// CaptchaConfig 图形验证码的生成配置
+type CaptchaConfig struct {
+	Width      int     // 图片宽度(像素), 必须大于0
+	Height     int     // 图片高度(像素), 必须大于0
+	Length     uint    // 验证码字符数量, 必须大于0
+	Charset    string  // 验证码字符集, 仅支持数字与英文字母(小写字母按大写字形绘制, 因此答案的核销总是忽略大小写). 为空时使用 DefaultCaptchaCharset
+	NoiseDots  int     // 干扰点数量. 不需要则填0
+	NoiseLines int     // 干扰线数量. 不需要则填0
+	Distortion float64 // 扭曲程度, 即正弦波形变的振幅(像素). 不需要扭曲则填0
+}
+
+// DefaultCaptchaConfig 默认的图形验证码配置(120x40, 4个字符)
+func DefaultCaptchaConfig() CaptchaConfig {
+	return CaptchaConfig{
+		Width:      120,
+		Height:     40,
+		Length:     4,
+		Charset:    DefaultCaptchaCharset,
+		NoiseDots:  80,
+		NoiseLines: 3,
+		Distortion: 2,
+	}
+}
+
+// CheckError 判断配置是否合法
+func (c CaptchaConfig) CheckError() error {
+	if c.Width <= 0 || c.Height <= 0 {
+		return errors.New("CaptchaConfig Width and Height must be greater than 0")
+	}
+
+	if c.Length == 0 {
+		return errors.New("CaptchaConfig Length == 0")
+	}
+
+	for _, ch := range c.charset() {
+		if _, ok := glyphs[unicode.ToUpper(ch)]; !ok {
+			return fmt.Errorf("CaptchaConfig Charset 包含不支持的字符: %q", ch)
+		}
+	}
+	return nil
+}
+
+// GenerateText 根据配置随机生成验证码文本(基于crypto/rand, 不可预测)
+func (c CaptchaConfig) GenerateText() (string, error) {
+	return wow_random.GenerateRandomString(c.Length, c.charset())
+}
+
+// DrawImage 将验证码文本绘制为PNG图片
+func (c CaptchaConfig) DrawImage(text string) ([]byte, error) {
+	if err := c.CheckError(); err != nil {
+		return nil, err
+	}
+	return drawCaptchaImage(c, text)
+}
+
+// GenerateCaptcha 随机生成验证码文本并绘制为PNG图片
+func (c CaptchaConfig) GenerateCaptcha() (text string, img []byte, err error) {
+	if text, err = c.GenerateText(); err != nil {
+		return "", nil, err
+	}
+	img, err = c.DrawImage(text)
+	return text, img, err
+}
+
+func (c CaptchaConfig) charset() string {
+	if c.Charset == "" {
+		return DefaultCaptchaCharset
+	}
+	return c.Charset
+}
+
+// 绘制验证码图片
+func drawCaptchaImage(cfg CaptchaConfig, text string) ([]byte, error) {
+	chars := []rune(strings.ToUpper(text))
+	if len(chars) == 0 {
+		return nil, errors.New("captcha text == \"\"")
+	}
+
+	seed, err := wow_random.GenerateSecureSeed()
+	if err != nil {
+		return nil, err
+	}
+	rd := rand.New(rand.NewSource(seed))
+	bg := color.RGBA{R: uint8(220 + rd.Intn(36)), G: uint8(220 + rd.Intn(36)), B: uint8(220 + rd.Intn(36)), A: 255}
+	canvas := image.NewRGBA(image.Rect(0, 0, cfg.Width, cfg.Height))
+	fillRect(canvas, bg)
+
+	// 字符逐个绘制, 每个字符随机偏移、旋转、着色
+	cellWidth := float64(cfg.Width) / float64(len(chars))
+	scale := math.Min(cellWidth*0.8/glyphWidth, float64(cfg.Height)*0.7/glyphHeight)
+	for i, ch := range chars {
+		g, ok := glyphs[ch]
+		if !ok {
+			return nil, fmt.Errorf("captcha text 包含不支持的字符: %q", ch)
+		}
+		cx := cellWidth*(float64(i)+0.5) + (rd.Float64()-0.5)*cellWidth*0.2
+		cy := float64(cfg.Height)/2 + (rd.Float64()-0.5)*float64(cfg.Height)*0.2
+		drawGlyph(canvas, g, cx, cy, scale, (rd.Float64()-0.5)*0.6, randomDarkColor(rd))
+	}
+
+	// 干扰线
+	for i := 0; i < cfg.NoiseLines; i++ {
+		drawLine(canvas, rd.Intn(cfg.Width), rd.Intn(cfg.Height), rd.Intn(cfg.Width), rd.Intn(cfg.Height), randomDarkColor(rd))
+	}
+
+	// 正弦波扭曲
+	result := canvas
+	if cfg.Distortion > 0 {
+		result = distort(canvas, cfg.Distortion, 2*math.Pi/(float64(cfg.Height)*(1+rd.Float64())), rd.Float64()*2*math.Pi, bg)
+	}
+
+	// 干扰点
+	for i := 0; i < cfg.NoiseDots; i++ {
+		result.Set(rd.Intn(cfg.Width), rd.Intn(cfg.Height), randomDarkColor(rd))
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, result); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 以(cx, cy)为中心、按scale缩放并旋转angle(弧度)绘制字形
+func drawGlyph(img *image.RGBA, g [glyphHeight]string, cx float64, cy float64, scale float64, angle float64, c color.RGBA) {
+	halfW, halfH := glyphWidth*scale/2, glyphHeight*scale/2
+	radius := math.Hypot(halfW, halfH)
+	sin, cos := math.Sin(angle), math.Cos(angle)
+	bounds := img.Bounds()
+
+	for y := int(cy - radius); y <= int(cy+radius); y++ {
+		for x := int(cx - radius); x <= int(cx+radius); x++ {
+			if !(image.Point{X: x, Y: y}).In(bounds) {
+				continue
+			}
+			// 逆向旋转, 将目标像素映射回字形坐标
+			dx, dy := float64(x)-cx, float64(y)-cy
+			gx := (dx*cos + dy*sin + halfW) / scale
+			gy := (-dx*sin + dy*cos + halfH) / scale
+			if gx >= 0 && gy >= 0 && glyphPixel(g, int(gx), int(gy)) {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+}
+
+// Bresenham画线
+func drawLine(img *image.RGBA, x0 int, y0 int, x1 int, y1 int, c color.RGBA) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+
+	for e := dx + dy; ; {
+		img.SetRGBA(x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+// 正弦波形变(水平、垂直方向各自按正弦波偏移)
+func distort(src *image.RGBA, amplitude float64, frequency float64, phase float64, bg color.RGBA) *image.RGBA {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(bounds)
+	fillRect(dst, bg)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			sx := x + int(amplitude*math.Sin(float64(y)*frequency+phase))
+			sy := y + int(amplitude*math.Sin(float64(x)*frequency+phase))
+			if (image.Point{X: sx, Y: sy}).In(bounds) {
+				dst.SetRGBA(x, y, src.RGBAAt(sx, sy))
+			}
+		}
+	}
+	return dst
+}
+
+func fillRect(img *image.RGBA, c color.RGBA) {
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+func randomDarkColor(rd *rand.Rand) color.RGBA {
+	return color.RGBA{R: uint8(rd.Intn(150)), G: uint8(rd.Intn(150)), B: uint8(rd.Intn(150)), A: 255}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,56 @@
+package wow_captcha
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+)
+
+func TestGenerateCaptcha(t *testing.T) {
+	cfg := DefaultCaptchaConfig()
+	text, img, err := cfg.GenerateCaptcha()
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(text) != int(cfg.Length) {
+		t.Error("Find bug in GenerateCaptcha")
+	}
+
+	decoded, err := png.Decode(bytes.NewReader(img))
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if decoded.Bounds().Dx() != cfg.Width || decoded.Bounds().Dy() != cfg.Height {
+		t.Error("Find bug in GenerateCaptcha")
+	}
+}
+
+func TestCaptchaConfigCheckError(t *testing.T) {
+	cfg := DefaultCaptchaConfig()
+	cfg.Charset = "AB#"
+	if cfg.CheckError() == nil {
+		t.Error("Find bug in CheckError")
+	}
+
+	cfg = DefaultCaptchaConfig()
+	cfg.Length = 0
+	if cfg.CheckError() == nil {
+		t.Error("Find bug in CheckError")
+	}
+}
+
+func TestGenerateText(t *testing.T) {
+	cfg := DefaultCaptchaConfig()
+	cfg.Length = 8
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		text, err := cfg.GenerateText()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if seen[text] {
+			t.Error("Find bug in GenerateText")
+		}
+		seen[text] = true
+	}
+}
@@ -1,7 +1,12 @@
 package wow_random
 
 import (
+	cryptoRand "crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"math/big"
 	"math/rand"
 	"strings"
 	"time"
@@ -17,3 +22,42 @@ func GenerateVerificationCode(length uint) string {
 	}
 	return s.String()
 }
+
+// GenerateRandomString 基于crypto/rand从指定的字符集中随机抽取字符生成不可预测的字符串(可用作图形验证码的答案等)
+// length: 位数	charset: 字符集, 不能为空
+func GenerateRandomString(length uint, charset string) (string, error) {
+	chars := []rune(charset)
+	if len(chars) == 0 {
+		return "", errors.New("charset == \"\"")
+	}
+
+	var s strings.Builder
+	max := big.NewInt(int64(len(chars)))
+	for i := 0; i < int(length); i++ {
+		n, err := cryptoRand.Int(cryptoRand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		s.WriteRune(chars[n.Int64()])
+	}
+	return s.String(), nil
+}
+
+// GenerateSecureSeed 基于crypto/rand生成math/rand的随机种子, 避免以时间为种子时并发生成的序列相同且可被预测
+func GenerateSecureSeed() (int64, error) {
+	var buf [8]byte
+	if _, err := cryptoRand.Read(buf[:]); err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(buf[:]) >> 1), nil
+}
+
+// GenerateSecureRandomHexString 基于crypto/rand生成不可预测的十六进制随机字符串(可用作挑战ID、令牌等)
+// byteLength: 随机字节数, 返回的字符串长度为其两倍
+func GenerateSecureRandomHexString(byteLength uint) (string, error) {
+	buf := make([]byte, byteLength)
+	if _, err := cryptoRand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
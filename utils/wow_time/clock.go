@@ -0,0 +1,54 @@
+package wow_time
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock 时钟接口. 用于替代直接调用time.Now(), 便于测试跨日、封禁到期等与时间相关的逻辑
+type Clock interface {
+	Now() time.Time // 获取当前时间
+}
+
+// RealClock 基于系统时间的时钟
+type RealClock struct{}
+
+// Now 获取当前的系统时间
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+// FakeClock 可手动调整的时钟, 仅用于测试. 并发安全
+type FakeClock struct {
+	mutex *sync.RWMutex
+	now   time.Time
+}
+
+// CreateFakeClock 创建以t为当前时间的时钟
+func CreateFakeClock(t time.Time) *FakeClock {
+	return &FakeClock{
+		mutex: &sync.RWMutex{},
+		now:   t,
+	}
+}
+
+// Now 获取时钟的当前时间
+func (c *FakeClock) Now() time.Time {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.now
+}
+
+// Set 将时钟的当前时间设置为t
+func (c *FakeClock) Set(t time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = t
+}
+
+// Advance 将时钟的当前时间向后推移d
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
@@ -0,0 +1,32 @@
+package wow_time
+
+import (
+	"sync"
+	"time"
+)
+
+// LocationChina 中国标准时间(UTC+8). 使用固定时区, 不依赖运行环境中的时区数据库
+var LocationChina = time.FixedZone("CST", 8*60*60)
+
+var (
+	defaultLocation      = time.Local
+	defaultLocationMutex = &sync.RWMutex{}
+)
+
+// SetDefaultLocation 设置默认时区, 影响本包中以当前时间为基准的日期计算(例如GetTomorrowZeroTime)
+// 默认为time.Local. 容器环境的系统时区通常为UTC, 若业务需以北京时间划分自然日, 应设置为LocationChina
+func SetDefaultLocation(loc *time.Location) {
+	if loc == nil {
+		return
+	}
+	defaultLocationMutex.Lock()
+	defer defaultLocationMutex.Unlock()
+	defaultLocation = loc
+}
+
+// GetDefaultLocation 获取默认时区
+func GetDefaultLocation() *time.Location {
+	defaultLocationMutex.RLock()
+	defer defaultLocationMutex.RUnlock()
+	return defaultLocation
+}
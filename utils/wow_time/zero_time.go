@@ -12,9 +12,23 @@ func GetYesterdayZeroTime() time.Time {
 	return GetZeroTimeByDateOffset(-1)
 }
 
-// GetZeroTimeByDateOffset 根据日期偏移获取指定日期零点的时间对象
+// GetZeroTimeByDateOffset 根据日期偏移获取指定日期零点的时间对象(默认时区)
 func GetZeroTimeByDateOffset(off int) time.Time {
-	timeStr := time.Now().Format("2006-01-02")
-	t, _ := time.ParseInLocation("2006-01-02", timeStr, time.Local)
-	return t.AddDate(0, 0, off)
+	return GetZeroTimeByDateOffsetInLocation(off, GetDefaultLocation())
+}
+
+// GetZeroTimeByDateOffsetInLocation 根据日期偏移获取指定时区下指定日期零点的时间对象
+func GetZeroTimeByDateOffsetInLocation(off int, loc *time.Location) time.Time {
+	return GetZeroTimeByDateOffsetFrom(time.Now().In(loc), off)
+}
+
+// GetZeroTimeByDateOffsetWithClock 以时钟的当前时间为基准, 根据日期偏移获取指定日期零点的时间对象(默认时区)
+func GetZeroTimeByDateOffsetWithClock(c Clock, off int) time.Time {
+	return GetZeroTimeByDateOffsetFrom(c.Now().In(GetDefaultLocation()), off)
+}
+
+// GetZeroTimeByDateOffsetFrom 以t为基准, 根据日期偏移获取指定日期零点的时间对象(时区与t一致)
+func GetZeroTimeByDateOffsetFrom(t time.Time, off int) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d+off, 0, 0, 0, 0, t.Location())
 }
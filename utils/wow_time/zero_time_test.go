@@ -0,0 +1,35 @@
+package wow_time
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetZeroTimeByDateOffsetFrom(t *testing.T) {
+	base := time.Date(2021, 12, 31, 23, 59, 59, 0, time.UTC)
+	if !GetZeroTimeByDateOffsetFrom(base, 1).Equal(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Error("Find bug in GetZeroTimeByDateOffsetFrom")
+	}
+	if !GetZeroTimeByDateOffsetFrom(base, -1).Equal(time.Date(2021, 12, 30, 0, 0, 0, 0, time.UTC)) {
+		t.Error("Find bug in GetZeroTimeByDateOffsetFrom")
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	c := CreateFakeClock(time.Date(2021, 12, 31, 23, 59, 59, 0, time.UTC))
+	c.Advance(time.Second)
+	if !GetZeroTimeByDateOffsetWithClock(c, 0).Equal(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Error("Find bug in FakeClock")
+	}
+}
+
+func TestGetZeroTimeByDateOffsetInLocation(t *testing.T) {
+	// UTC 2021-12-31 16:30 即北京时间 2022-01-01 00:30
+	utc := time.Date(2021, 12, 31, 16, 30, 0, 0, time.UTC)
+	if !GetZeroTimeByDateOffsetFrom(utc.In(LocationChina), 0).Equal(time.Date(2022, 1, 1, 0, 0, 0, 0, LocationChina)) {
+		t.Error("Find bug in GetZeroTimeByDateOffsetFrom")
+	}
+	if GetZeroTimeByDateOffsetInLocation(0, LocationChina).Location() != LocationChina {
+		t.Error("Find bug in GetZeroTimeByDateOffsetInLocation")
+	}
+}